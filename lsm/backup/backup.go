@@ -0,0 +1,390 @@
+// Package backup keeps incremental backups of the db.
+//
+// A backup is taken from a checkpoint of the db, see lsm.LSMTree.Checkpoint.
+// Files of the checkpoint are stored once in the backup directory under
+// their SHA-256 checksum, so tables not changed since the previous backup
+// are not copied again. Each backup is a manifest that lists the files of
+// the checkpoint, including its WAL, with their checksums.
+//
+// The layout of the backup directory:
+//
+//	files/<checksum>  content of the files
+//	meta/<id>.json    manifests of the backups
+package backup
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wubba-com/lsm-distributed/lsm"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+const (
+	filesDir = "files"
+	metaDir  = "meta"
+	tmpDir   = "tmp"
+)
+
+var reManifest = regexp.MustCompile(`^([0-9]+)\.json$`)
+
+// ErrNotFound is returned when the backup does not exist.
+var ErrNotFound = errors.New("backup not found")
+
+// File is a file of a backup.
+type File struct {
+	// Path of the file relative to the db directory.
+	Path     string `json:"path"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+// Info describes a backup.
+type Info struct {
+	ID    uint64    `json:"id"`
+	Time  time.Time `json:"time"`
+	Files []File    `json:"files"`
+}
+
+// Size returns the total size of the files of the backup.
+func (i Info) Size() int64 {
+	var size int64
+	for _, f := range i.Files {
+		size += f.Size
+	}
+
+	return size
+}
+
+// Engine creates and restores backups in a directory.
+type Engine struct {
+	dir  string
+	lock sync.Mutex
+}
+
+// Open opens the backup directory and creates it if needed.
+func Open(dir string) (*Engine, error) {
+	for _, d := range []string{filesDir, metaDir} {
+		if err := os.MkdirAll(path.Join(dir, d), os.FileMode(0700)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Engine{dir: dir}, nil
+}
+
+// Create takes a checkpoint of the db and stores its files missing in the backup directory.
+func (e *Engine) Create(db *lsm.LSMTree) (Info, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	infos, err := e.list()
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{ID: 1, Time: time.Now()}
+	if len(infos) > 0 {
+		info.ID = infos[len(infos)-1].ID + 1
+	}
+
+	tmp := path.Join(e.dir, tmpDir)
+	if err := os.RemoveAll(tmp); err != nil {
+		return Info{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := db.Checkpoint(tmp); err != nil {
+		return Info{}, fmt.Errorf("failed to checkpoint db: %w", err)
+	}
+
+	err = filepath.WalkDir(tmp, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		sum, size, err := checksum(name)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(tmp, name)
+		if err != nil {
+			return err
+		}
+		info.Files = append(info.Files, File{Path: filepath.ToSlash(rel), Checksum: sum, Size: size})
+
+		// файл с тем же содержимым уже сохранен предыдущей копией
+		dst := e.filePath(sum)
+		if _, err := os.Stat(dst); err == nil {
+			return nil
+		}
+
+		// файл переименовывается после копирования, чтобы прерванное
+		// копирование не оставило файл под контрольной суммой
+		if err := sst.CopyFile(name, dst+".tmp"); err != nil {
+			return err
+		}
+
+		return os.Rename(dst+".tmp", dst)
+	})
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to store files of backup %d: %w", info.ID, err)
+	}
+
+	if err := e.writeManifest(info); err != nil {
+		return Info{}, err
+	}
+
+	return info, nil
+}
+
+// List returns the backups ordered by ID.
+func (e *Engine) List() ([]Info, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.list()
+}
+
+// Delete deletes the backup and the files not used by other backups.
+func (e *Engine) Delete(id uint64) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if err := os.Remove(e.manifestPath(id)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %d", ErrNotFound, id)
+		}
+		return err
+	}
+
+	return e.collect()
+}
+
+// PurgeOld deletes all backups except the keep latest ones.
+func (e *Engine) PurgeOld(keep int) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	infos, err := e.list()
+	if err != nil {
+		return err
+	}
+	for len(infos) > max(keep, 0) {
+		if err := os.Remove(e.manifestPath(infos[0].ID)); err != nil {
+			return err
+		}
+		infos = infos[1:]
+	}
+
+	return e.collect()
+}
+
+// Verify checks that all files of the backup exist and match their checksums.
+// A mismatch is reported as lsm.ErrCorruption.
+func (e *Engine) Verify(id uint64) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	info, err := e.readManifest(id)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range info.Files {
+		sum, size, err := checksum(e.filePath(f.Checksum))
+		if err != nil {
+			return fmt.Errorf("failed to read file %s of backup %d: %w", f.Path, id, err)
+		}
+		if sum != f.Checksum || size != f.Size {
+			return fmt.Errorf("%w: file %s of backup %d has checksum %s, want %s", lsm.ErrCorruption, f.Path, id, sum, f.Checksum)
+		}
+	}
+
+	return nil
+}
+
+// Restore copies the files of the backup into the directory dir,
+// which must not exist, so it can be opened with lsm.Open.
+func (e *Engine) Restore(id uint64, dir string) (err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	info, err := e.readManifest(id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("restore directory %s already exists", dir)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	for _, f := range info.Files {
+		dst := path.Join(dir, f.Path)
+		if err := os.MkdirAll(path.Dir(dst), os.FileMode(0700)); err != nil {
+			return err
+		}
+		// база меняет свои WAL и blob-файлы, поэтому файлы копируются
+		if err := sst.CopyFile(e.filePath(f.Checksum), dst); err != nil {
+			return fmt.Errorf("failed to restore file %s of backup %d: %w", f.Path, id, err)
+		}
+		if sum, _, err := checksum(dst); err != nil {
+			return err
+		} else if sum != f.Checksum {
+			return fmt.Errorf("%w: file %s of backup %d has checksum %s, want %s", lsm.ErrCorruption, f.Path, id, sum, f.Checksum)
+		}
+	}
+
+	return nil
+}
+
+// list читает манифесты всех копий.
+func (e *Engine) list() ([]Info, error) {
+	entries, err := os.ReadDir(path.Join(e.dir, metaDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []Info
+	for _, entry := range entries {
+		m := reManifest.FindStringSubmatch(entry.Name())
+		if m == nil || entry.IsDir() {
+			continue
+		}
+		id, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := e.readManifest(id)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b Info) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return infos, nil
+}
+
+// collect удаляет файлы, на которые не ссылается ни одна копия.
+func (e *Engine) collect() error {
+	infos, err := e.list()
+	if err != nil {
+		return err
+	}
+
+	live := make(map[string]bool)
+	for _, info := range infos {
+		for _, f := range info.Files {
+			live[f.Checksum] = true
+		}
+	}
+
+	entries, err := os.ReadDir(path.Join(e.dir, filesDir))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if live[entry.Name()] {
+			continue
+		}
+		if err := os.Remove(path.Join(e.dir, filesDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) readManifest(id uint64) (Info, error) {
+	data, err := os.ReadFile(e.manifestPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Info{}, fmt.Errorf("%w: %d", ErrNotFound, id)
+		}
+		return Info{}, err
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return Info{}, fmt.Errorf("%w: manifest of backup %d: %s", lsm.ErrCorruption, id, err)
+	}
+
+	return info, nil
+}
+
+// writeManifest атомарно записывает манифест: копия появляется в списке,
+// только когда все ее файлы сохранены.
+func (e *Engine) writeManifest(info Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	name := e.manifestPath(info.ID)
+	f, err := os.OpenFile(name+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name)
+}
+
+func (e *Engine) manifestPath(id uint64) string {
+	return path.Join(e.dir, metaDir, fmt.Sprintf("%06d.json", id))
+}
+
+func (e *Engine) filePath(sum string) string {
+	return path.Join(e.dir, filesDir, sum)
+}
+
+// checksum возвращает SHA-256 и размер файла.
+func checksum(name string) (string, int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/wubba-com/lsm-distributed/lsm"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := lsm.Open(path.Join(dir, "db"), lsm.MemTableThreshold(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Shutdown()
+
+	e, err := Open(path.Join(dir, "backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var put = func(from, to int) {
+		for i := from; i < to; i++ {
+			key := []byte(fmt.Sprintf("key-%03d", i))
+			if err := db.Put(key, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var files = func() int {
+		entries, err := os.ReadDir(path.Join(dir, "backup", filesDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+
+	put(0, 50)
+	first, err := e.Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := files()
+
+	put(50, 60)
+	second, err := e.Create(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != first.ID+1 {
+		t.Fatalf("id %d != %d", second.ID, first.ID+1)
+	}
+	// неизмененные таблицы не копируются повторно
+	if n := files(); n >= stored+len(second.Files) {
+		t.Fatalf("files %d: backup is not incremental (%d + %d)", n, stored, len(second.Files))
+	}
+
+	infos, err := e.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].ID != first.ID || infos[1].ID != second.ID {
+		t.Fatalf("list %v", infos)
+	}
+	for _, info := range infos {
+		if err := e.Verify(info.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restored := path.Join(dir, "restored")
+	if err := e.Restore(first.ID, restored); err != nil {
+		t.Fatal(err)
+	}
+	r, err := lsm.Open(restored, lsm.MemTableThreshold(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Shutdown()
+	if v, _, err := r.Get([]byte("key-049")); err != nil || !bytes.Equal(v, []byte("key-049")) {
+		t.Fatalf("key-049 = %s: %v", v, err)
+	}
+	if _, _, err := r.Get([]byte("key-055")); !errors.Is(err, lsm.ErrNotFound) {
+		t.Fatalf("key-055 of first backup: %v", err)
+	}
+
+	if err := e.PurgeOld(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Verify(first.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("purged backup err %v != %v", err, ErrNotFound)
+	}
+	if err := e.Verify(second.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// порча файла обнаруживается при проверке
+	f := second.Files[0]
+	if err := os.WriteFile(e.filePath(f.Checksum), []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Verify(second.ID); !errors.Is(err, lsm.ErrCorruption) {
+		t.Fatalf("corrupted backup err %v != %v", err, lsm.ErrCorruption)
+	}
+	if err := e.Restore(second.ID, path.Join(dir, "corrupted")); !errors.Is(err, lsm.ErrCorruption) {
+		t.Fatalf("restore err %v != %v", err, lsm.ErrCorruption)
+	}
+
+	if err := e.Delete(second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := files(); n != 0 {
+		t.Fatalf("files %d left after delete", n)
+	}
+}
+
+func TestBackupDuringWrites(t *testing.T) {
+	const n = 1000
+	dir := t.TempDir()
+
+	db, err := lsm.Open(path.Join(dir, "db"), lsm.MemTableThreshold(256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Shutdown()
+
+	e, err := Open(path.Join(dir, "backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key-%04d", i))
+			if err := db.Put(key, key); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	var infos []Info
+	for len(infos) < 3 {
+		info, err := e.Create(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		infos = append(infos, info)
+	}
+	<-done
+
+	// каждая копия содержит все ключи, записанные до последнего из них
+	for _, info := range infos {
+		if err := e.Verify(info.ID); err != nil {
+			t.Fatal(err)
+		}
+		restored := path.Join(dir, fmt.Sprintf("restored-%d", info.ID))
+		if err := e.Restore(info.ID, restored); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := lsm.Open(restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := -1
+		for i := n - 1; i >= 0 && last < 0; i-- {
+			if _, ok, _ := r.Get([]byte(fmt.Sprintf("key-%04d", i))); ok {
+				last = i
+			}
+		}
+		for i := 0; i < last; i++ {
+			key := []byte(fmt.Sprintf("key-%04d", i))
+			if v, _, err := r.Get(key); err != nil || !bytes.Equal(v, key) {
+				t.Fatalf("backup %d has no key %s before key %d: %s %v", info.ID, key, last, v, err)
+			}
+		}
+		r.Shutdown()
+	}
+}
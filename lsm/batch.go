@@ -0,0 +1,234 @@
+package lsm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/wubba-com/lsm-distributed/lsm/encoder"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+// Batch собирает операции над несколькими column families, которые
+// записываются в WAL одной записью и применяются атомарно.
+type Batch struct {
+	ops []batchOp
+	err error
+}
+
+type batchOp struct {
+	cf   *ColumnFamily
+	kind encoder.OpKind
+	key  []byte
+	// Исходное значение операции: значение ключа для OpKindSet
+	// и конец диапазона для OpKindDeleteRange.
+	value []byte
+	// Значение, закодированное для WAL и MemTable.
+	encoded []byte
+}
+
+// NewBatch создает пустой пакет.
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Put добавляет в пакет запись ключа в column family cf.
+// Если cf равен nil, используется column family по умолчанию.
+func (b *Batch) Put(cf *ColumnFamily, key, value []byte) {
+	if err := checkKeyValue(key, value); err != nil {
+		b.setErr(err)
+		return
+	}
+
+	b.add(cf, encoder.OpKindSet, key, value)
+}
+
+// Delete добавляет в пакет удаление ключа из column family cf.
+func (b *Batch) Delete(cf *ColumnFamily, key []byte) {
+	if len(key) == 0 {
+		b.setErr(ErrKeyRequired)
+		return
+	} else if len(key) > MaxKeySize {
+		b.setErr(ErrKeyTooLarge)
+		return
+	}
+
+	b.add(cf, encoder.OpKindDelete, key, nil)
+}
+
+// DeleteRange добавляет в пакет удаление ключей [start, end) из column family cf.
+func (b *Batch) DeleteRange(cf *ColumnFamily, start, end []byte) {
+	if err := checkRange(start, end); err != nil {
+		b.setErr(err)
+		return
+	}
+
+	b.add(cf, encoder.OpKindDeleteRange, start, end)
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+func (b *Batch) add(cf *ColumnFamily, kind encoder.OpKind, key, value []byte) {
+	b.ops = append(b.ops, batchOp{cf: cf, kind: kind, key: key, value: value})
+}
+
+func (b *Batch) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// encodeBatch кодирует пакет для WAL: последовательность
+// [имя column family][[ключ][закодированное значение]].
+func encodeBatch(ops []batchOp) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		op  bytes.Buffer
+	)
+	for _, o := range ops {
+		op.Reset()
+		if _, err := sst.Encode(&op, o.key, o.encoded); err != nil {
+			return nil, err
+		}
+		if _, err := sst.Encode(&buf, []byte(o.cf.name), op.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Write атомарно записывает пакет: все операции попадают в WAL
+// одной записью и применяются к MemTable под одной блокировкой.
+func (t *LSMTree) Write(b *Batch) error {
+	return t.WriteContext(context.Background(), b)
+}
+
+// WriteContext записывает пакет как Write, но прекращает ожидание записи
+// в WAL, когда контекст завершен. Пакет, не переданный в WAL, не применяется.
+func (t *LSMTree) WriteContext(ctx context.Context, b *Batch) error {
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.ops) == 0 {
+		return nil
+	}
+	if err := t.throttle(ctx, b.ops); err != nil {
+		return err
+	}
+	if err := t.prepare(ctx, b.ops); err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.commit(ctx, b.ops)
+}
+
+// prepare назначает операциям column family и кодирует их значения.
+// Значения для blob-файлов записываются здесь, до блокировки дерева.
+func (t *LSMTree) prepare(ctx context.Context, ops []batchOp) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+
+	for i := range ops {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		op := &ops[i]
+		if op.cf == nil {
+			op.cf = t.def
+		}
+		if op.cf.db != t {
+			return fmt.Errorf("column family %s belongs to another db", op.cf.name)
+		}
+
+		var err error
+		if op.encoded, err = op.cf.encodeValue(op.kind, op.key, op.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commit записывает закодированные операции в WAL и применяет их к MemTable.
+// Одна операция column family по умолчанию пишется в WAL как есть,
+// остальные пакетом с пустым ключом.
+// Функция ожидает, что она будет выполняться под блокировкой дерева.
+func (t *LSMTree) commit(ctx context.Context, ops []batchOp) error {
+	if t.closed {
+		return ErrClosed
+	}
+	if t.readOnly {
+		return ErrReadOnly
+	}
+
+	elem := sst.ElemSST{Key: ops[0].key, Val: ops[0].encoded}
+	if len(ops) > 1 || ops[0].cf != t.def {
+		value, err := encodeBatch(ops)
+		if err != nil {
+			return fmt.Errorf("failed to encode batch: %w", err)
+		}
+		elem = sst.ElemSST{Key: nil, Val: value}
+	}
+
+	images, err := t.images(ctx, ops)
+	if err != nil {
+		return fmt.Errorf("failed to read values for transactions: %w", err)
+	}
+
+	entry := walEntry{ElemSST: elem, done: make(chan error, 1)}
+	select {
+	case t.cSST <- entry:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.ctx.Done():
+		return ErrClosed
+	}
+	// walJob отвечает на каждую принятую запись
+	if err := <-entry.done; err != nil {
+		return fmt.Errorf("failed to append to WAL: %w", err)
+	}
+
+	t.oracle.committed(ops, images)
+	for _, op := range ops {
+		op.cf.apply(op.key, op.encoded)
+	}
+	t.flushIfNeeded()
+
+	return nil
+}
+
+// applyBatch применяет к MemTable пакет, прочитанный из WAL.
+func (t *LSMTree) applyBatch(value []byte) error {
+	r := bytes.NewReader(value)
+	for {
+		name, op, err := sst.Decode(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode batch: %w", err)
+		}
+
+		cf, ok := t.cfs[string(name)]
+		if !ok {
+			return fmt.Errorf("unknown column family %s", name)
+		}
+
+		key, val, err := sst.Decode(bytes.NewReader(op))
+		if err != nil {
+			return fmt.Errorf("failed to decode batch operation: %w", err)
+		}
+
+		cf.apply(key, val)
+	}
+}
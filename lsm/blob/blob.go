@@ -0,0 +1,397 @@
+// Package blob stores large values in append-only blob files, so the
+// tree keeps only a small reference to the value.
+//
+// Each record of a blob file is encoded as an SST entry: the key of the
+// value is kept next to it, so the garbage collector can check if the
+// record is still referenced by the tree.
+package blob
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+const (
+	// Extension of blob files.
+	ext = ".blob"
+	// Size of an encoded reference.
+	refSize = 3 * 8
+	// Size of the record header: total length and key length.
+	headerSize = 2 * 8
+	// Default size after which a new blob file is started.
+	defaultFileSize = 64 << 20 // 64 MB
+)
+
+var reName = regexp.MustCompile(`^([0-9]+)\.blob$`)
+
+// Ref is a reference to a value in a blob file.
+type Ref struct {
+	FileNum uint64
+	Offset  uint64
+	Size    uint64
+}
+
+// Bytes encodes the reference.
+// Must be compatible with DecodeRef.
+func (r Ref) Bytes() []byte {
+	var encoded [refSize]byte
+	binary.BigEndian.PutUint64(encoded[0:8], r.FileNum)
+	binary.BigEndian.PutUint64(encoded[8:16], r.Offset)
+	binary.BigEndian.PutUint64(encoded[16:24], r.Size)
+
+	return encoded[:]
+}
+
+// DecodeRef decodes the reference.
+// Must be compatible with Ref.Bytes.
+func DecodeRef(encoded []byte) (Ref, error) {
+	if len(encoded) != refSize {
+		return Ref{}, fmt.Errorf("%w: invalid blob reference size %d", sst.ErrCorruption, len(encoded))
+	}
+
+	return Ref{
+		FileNum: binary.BigEndian.Uint64(encoded[0:8]),
+		Offset:  binary.BigEndian.Uint64(encoded[8:16]),
+		Size:    binary.BigEndian.Uint64(encoded[16:24]),
+	}, nil
+}
+
+// Storage is a directory of blob files. Values are appended to the
+// active file; a new active file is started when it grows over the
+// file size and on each open.
+type Storage struct {
+	dir      string
+	lock     sync.Mutex
+	fileSize uint64
+
+	active     *os.File
+	activeNum  uint64
+	activeSize uint64
+
+	// Число читателей, закрепивших файлы, см. Pin, и файлы,
+	// удаление которых отложено до их открепления.
+	pins    int
+	removed []uint64
+}
+
+type Option func(*Storage)
+
+// FileSize sets the size after which a new blob file is started.
+func FileSize(fileSize uint64) Option {
+	return func(s *Storage) {
+		s.fileSize = fileSize
+	}
+}
+
+// Open opens the blob storage in the directory and creates it if needed.
+func Open(dir string, options ...Option) (*Storage, error) {
+	if err := os.MkdirAll(dir, os.FileMode(0700)); err != nil {
+		return nil, err
+	}
+
+	s := &Storage{
+		dir:      dir,
+		fileSize: defaultFileSize,
+	}
+	for _, opt := range options {
+		opt(s)
+	}
+
+	nums, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	var next uint64
+	if len(nums) > 0 {
+		next = nums[len(nums)-1] + 1
+	}
+	if err := s.rotate(next); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// OpenReadOnly opens the blob storage in the directory for reading.
+// Unlike Open it does not create the directory and the active file,
+// so values can not be appended.
+func OpenReadOnly(dir string) (*Storage, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	return &Storage{dir: dir}, nil
+}
+
+// Dir returns the directory of the storage.
+func (s *Storage) Dir() string {
+	return s.dir
+}
+
+// Append appends the value of the key to the active blob file.
+func (s *Storage) Append(key, value []byte) (Ref, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.active == nil {
+		return Ref{}, fmt.Errorf("blob storage %s is read-only", s.dir)
+	}
+	if s.activeSize >= s.fileSize {
+		if err := s.rotate(s.activeNum + 1); err != nil {
+			return Ref{}, err
+		}
+	}
+
+	n, err := sst.Encode(s.active, key, value)
+	if err != nil {
+		return Ref{}, fmt.Errorf("failed to append to blob file %s: %w", s.active.Name(), err)
+	}
+
+	ref := Ref{
+		FileNum: s.activeNum,
+		Offset:  s.activeSize + headerSize + uint64(len(key)),
+		Size:    uint64(len(value)),
+	}
+	s.activeSize += uint64(n)
+
+	return ref, nil
+}
+
+// Read reads the value by the reference.
+func (s *Storage) Read(ref Ref) ([]byte, error) {
+	f, err := os.Open(s.path(ref.FileNum))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	value := make([]byte, ref.Size)
+	if _, err := f.ReadAt(value, int64(ref.Offset)); err != nil {
+		if err == io.EOF {
+			err = sst.Corrupted(f.Name(), int64(ref.Offset), fmt.Errorf("%w: blob of size %d is truncated", sst.ErrCorruption, ref.Size))
+		}
+		return nil, fmt.Errorf("failed to read blob %d:%d from %s: %w", ref.Offset, ref.Size, f.Name(), err)
+	}
+
+	return value, nil
+}
+
+// Files returns the numbers of all blob files except the active one
+// and the removed ones.
+func (s *Storage) Files() ([]uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	nums, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(nums, func(num uint64) bool {
+		return s.active != nil && num == s.activeNum || slices.Contains(s.removed, num)
+	}), nil
+}
+
+// Scan calls fn for each record of the blob file.
+func (s *Storage) Scan(num uint64, fn func(key []byte, ref Ref) error) error {
+	f, err := os.Open(s.path(num))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var offset uint64
+	for {
+		key, value, err := sst.Decode(f)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read blob file %s: %w", f.Name(), sst.Corrupted(f.Name(), int64(offset), err))
+		}
+
+		ref := Ref{
+			FileNum: num,
+			Offset:  offset + headerSize + uint64(len(key)),
+			Size:    uint64(len(value)),
+		}
+		if err := fn(key, ref); err != nil {
+			return err
+		}
+		offset = ref.Offset + ref.Size
+	}
+}
+
+// Remove removes the blob file. While the files are pinned, see Pin,
+// the removal is delayed until the last pin is released.
+func (s *Storage) Remove(num uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.active != nil && num == s.activeNum {
+		return fmt.Errorf("cannot remove active blob file %d", num)
+	}
+	if s.pins > 0 {
+		if !slices.Contains(s.removed, num) {
+			s.removed = append(s.removed, num)
+		}
+		return nil
+	}
+
+	return os.Remove(s.path(num))
+}
+
+// Pin keeps the blob files from being removed until the returned function
+// is called, so a reference read before Remove stays readable. Removals
+// requested meanwhile are done when the last pin is released.
+func (s *Storage) Pin() func() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pins++
+
+	var once sync.Once
+	return func() {
+		once.Do(s.unpin)
+	}
+}
+
+// unpin снимает закрепление и удаляет отложенные файлы, если
+// закреплений больше нет. Файл, который не удалось удалить,
+// остается отложенным до следующего открепления.
+func (s *Storage) unpin() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pins--
+	if s.pins > 0 {
+		return
+	}
+
+	s.removed = slices.DeleteFunc(s.removed, func(num uint64) bool {
+		err := os.Remove(s.path(num))
+		return err == nil || os.IsNotExist(err)
+	})
+}
+
+// Checkpoint copies the blob files into the directory dir. Sealed files
+// are never changed, so they are hard linked when possible, see
+// sst.LinkOrCopy; the active file is copied, since it is still appended.
+func (s *Storage) Checkpoint(dir string) error {
+	if err := os.MkdirAll(dir, os.FileMode(0700)); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.active != nil {
+		if err := s.active.Sync(); err != nil {
+			return err
+		}
+	}
+
+	nums, err := s.files()
+	if err != nil {
+		return err
+	}
+	for _, num := range nums {
+		src, dst := s.path(num), path.Join(dir, path.Base(s.path(num)))
+		if s.active != nil && num == s.activeNum {
+			err = sst.CopyFile(src, dst)
+		} else {
+			err = sst.LinkOrCopy(src, dst)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to checkpoint blob file %s: %w", src, err)
+		}
+	}
+
+	return nil
+}
+
+// Sync commits the active blob file to stable storage.
+func (s *Storage) Sync() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.active == nil {
+		return nil
+	}
+
+	return s.active.Sync()
+}
+
+// Close closes the active blob file.
+func (s *Storage) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.active == nil {
+		return nil
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+
+	return s.active.Close()
+}
+
+// rotate closes the active file and starts a new one with the number.
+func (s *Storage) rotate(num uint64) error {
+	if s.active != nil {
+		if err := s.active.Sync(); err != nil {
+			return err
+		}
+		if err := s.active.Close(); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(s.path(num), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	s.active, s.activeNum, s.activeSize = f, num, 0
+
+	return nil
+}
+
+func (s *Storage) files() ([]uint64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var nums []uint64
+	for _, entry := range entries {
+		m := reName.FindStringSubmatch(entry.Name())
+		if m == nil || entry.IsDir() {
+			continue
+		}
+
+		num, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		nums = append(nums, num)
+	}
+	slices.Sort(nums)
+
+	return nums, nil
+}
+
+func (s *Storage) path(num uint64) string {
+	return path.Join(s.dir, fmt.Sprintf("%06d%s", num, ext))
+}
@@ -0,0 +1,127 @@
+package blob
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, FileSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string][]byte{
+		"a": bytes.Repeat([]byte("a"), 100),
+		"b": bytes.Repeat([]byte("b"), 10),
+		"c": bytes.Repeat([]byte("c"), 1000),
+	}
+	refs := make(map[string]Ref)
+	for _, key := range []string{"a", "b", "c"} {
+		ref, err := s.Append([]byte(key), values[key])
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs[key] = ref
+	}
+
+	for key, ref := range refs {
+		decoded, err := DecodeRef(ref.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != ref {
+			t.Fatalf("decoded ref %v != %v", decoded, ref)
+		}
+
+		value, err := s.Read(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, values[key]) {
+			t.Fatalf("value of %s (l=%d) != %d", key, len(value), len(values[key]))
+		}
+	}
+
+	nums, err := s.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nums) != 1 {
+		t.Fatalf("files %d != 1", len(nums))
+	}
+
+	var scanned int
+	err = s.Scan(refs["a"].FileNum, func(key []byte, ref Ref) error {
+		if ref != refs[string(key)] {
+			t.Fatalf("scanned ref %v != %v", ref, refs[string(key)])
+		}
+		scanned++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanned != 1 {
+		t.Fatalf("scanned %d != 1", scanned)
+	}
+
+	if err := s.Remove(refs["c"].FileNum); err == nil {
+		t.Fatal("active file removed!")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if value, err := s.Read(refs["c"]); err != nil || !bytes.Equal(value, values["c"]) {
+		t.Fatalf("value of c is not read after reopen: %v", err)
+	}
+	if err := s.Remove(refs["a"].FileNum); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoragePin(t *testing.T) {
+	s, err := Open(t.TempDir(), FileSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	value := bytes.Repeat([]byte("v"), 32)
+	ref, err := s.Append([]byte("a"), value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Append([]byte("b"), value); err != nil {
+		t.Fatal(err)
+	}
+
+	// закрепленный файл удаляется после открепления
+	release := s.Pin()
+	other := s.Pin()
+	if err := s.Remove(ref.FileNum); err != nil {
+		t.Fatal(err)
+	}
+	if nums, err := s.Files(); err != nil || len(nums) != 0 {
+		t.Fatalf("files %v after remove: %v", nums, err)
+	}
+	release()
+	release()
+	if v, err := s.Read(ref); err != nil || !bytes.Equal(v, value) {
+		t.Fatalf("pinned value (l=%d): %v", len(v), err)
+	}
+
+	other()
+	if _, err := s.Read(ref); err == nil {
+		t.Fatal("removed file is read after the last pin is released")
+	}
+}
@@ -0,0 +1,139 @@
+package lsm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wubba-com/lsm-distributed/lsm/blob"
+	"github.com/wubba-com/lsm-distributed/lsm/encoder"
+)
+
+// GarbageCollectBlobs собирает мусор в blob-файлах всех column families.
+func (t *LSMTree) GarbageCollectBlobs() error {
+	for _, cf := range t.cfs {
+		if err := cf.GarbageCollectBlobs(); err != nil {
+			return fmt.Errorf("failed to collect blobs of column family %s: %w", cf.name, err)
+		}
+	}
+
+	return nil
+}
+
+// GarbageCollectBlobs собирает мусор в blob-файлах column family.
+// Значение в blob-файле живое, если дерево все еще ссылается на него.
+// Если доля мусора в файле больше BlobGarbageRatio, живые значения
+// переписываются в активный blob-файл, а сам файл удаляется.
+func (cf *ColumnFamily) GarbageCollectBlobs() error {
+	if cf.db.readOnly {
+		return ErrReadOnly
+	}
+	if cf.blobs == nil {
+		return nil
+	}
+
+	nums, err := cf.blobs.Files()
+	if err != nil {
+		return err
+	}
+
+	for _, num := range nums {
+		if err := cf.collectBlobFile(num); err != nil {
+			return fmt.Errorf("failed to collect blob file %d: %w", num, err)
+		}
+	}
+
+	return nil
+}
+
+type liveBlob struct {
+	key []byte
+	ref blob.Ref
+}
+
+func (cf *ColumnFamily) collectBlobFile(num uint64) error {
+	var (
+		total, live uint64
+		blobs       []liveBlob
+	)
+	err := cf.blobs.Scan(num, func(key []byte, ref blob.Ref) error {
+		total += ref.Size
+
+		cf.db.lock.RLock()
+		ok, err := cf.isLiveBlob(key, ref)
+		cf.db.lock.RUnlock()
+		if err != nil {
+			return err
+		}
+		if ok {
+			live += ref.Size
+			blobs = append(blobs, liveBlob{key: key, ref: ref})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if total > 0 && float64(total-live)/float64(total) <= cf.config.BlobGarbageRatio {
+		return nil
+	}
+
+	for _, b := range blobs {
+		if err := cf.relocateBlob(b.key, b.ref); err != nil {
+			return err
+		}
+	}
+
+	if cf.db.debug {
+		cf.db.logger.Debug(fmt.Sprintf("collect blob file %d: %d of %d bytes live", num, live, total))
+	}
+
+	// файл, значения которого еще читаются, удаляется после чтения
+	return cf.blobs.Remove(num)
+}
+
+// relocateBlob переписывает живое значение в активный blob-файл
+// и записывает в дерево новую ссылку, если ключ не изменился.
+func (cf *ColumnFamily) relocateBlob(key []byte, ref blob.Ref) error {
+	value, err := cf.blobs.Read(ref)
+	if err != nil {
+		return err
+	}
+
+	newRef, err := cf.blobs.Append(key, value)
+	if err != nil {
+		return err
+	}
+
+	cf.db.lock.Lock()
+	defer cf.db.lock.Unlock()
+
+	// ключ мог быть перезаписан, пока значение копировалось
+	if ok, err := cf.isLiveBlob(key, ref); err != nil || !ok {
+		return err
+	}
+
+	return cf.db.commit(context.Background(), []batchOp{{
+		cf:      cf,
+		kind:    encoder.OpKindBlobRef,
+		key:     key,
+		encoded: cf.db.encoder.Encode(encoder.OpKindBlobRef, newRef.Bytes()),
+	}})
+}
+
+// isLiveBlob сообщает, ссылается ли дерево на значение ключа в blob-файле.
+// Функция ожидает, что она будет выполняться под блокировкой дерева.
+func (cf *ColumnFamily) isLiveBlob(key []byte, ref blob.Ref) (bool, error) {
+	dec, err := cf.get(context.Background(), key)
+	if err != nil || dec == nil || !dec.IsBlobRef() {
+		return false, err
+	}
+
+	cur, err := blob.DecodeRef(dec.Value())
+	if err != nil {
+		return false, err
+	}
+
+	return cur == ref, nil
+}
@@ -0,0 +1,205 @@
+package lsm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBlobValues(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir, MemTableThreshold(1<<20), BlobThreshold(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := bytes.Repeat([]byte("l"), MaxValueSize+1)
+	if err := l.Put([]byte("large"), large); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put([]byte("small"), []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+
+	if dec, _ := l.def.get(context.Background(), []byte("small")); dec == nil || dec.IsBlobRef() {
+		t.Fatal("small value is not inline!")
+	}
+
+	var check = func(stage string) {
+		if v, _, err := l.Get([]byte("large")); err != nil || !bytes.Equal(v, large) {
+			t.Fatalf("%s: large (l=%d) != %d: %v", stage, len(v), len(large), err)
+		}
+		if v, _, _ := l.Get([]byte("small")); !bytes.Equal(v, []byte("small")) {
+			t.Fatalf("%s: small = %s", stage, v)
+		}
+	}
+	check("memtable")
+
+	l.Shutdown()
+	l.Close()
+
+	l, err = Open(dir, MemTableThreshold(1<<20), BlobThreshold(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	check("replay")
+
+	l.lock.Lock()
+	if err := l.flushMemTable(); err != nil {
+		t.Fatal(err)
+	}
+	l.lock.Unlock()
+	check("flush")
+}
+
+func TestBlobValueTooLarge(t *testing.T) {
+	l, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	if err := l.Put([]byte("large"), bytes.Repeat([]byte("l"), MaxValueSize+1)); err != ErrValueTooLarge {
+		t.Fatalf("err %v != %v", err, ErrValueTooLarge)
+	}
+}
+
+func TestGarbageCollectBlobs(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20), ColumnFamilyConfig("blobs", Config{
+		MemtblDataSize: 1 << 20,
+		BlobThreshold:  16,
+		BlobFileSize:   256,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	cf, _ := l.ColumnFamily("blobs")
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	for i := 0; i < 4; i++ {
+		for _, key := range keys {
+			if err := cf.Put(key, bytes.Repeat(key, 100+i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	before, err := cf.blobs.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) == 0 {
+		t.Fatal("no blob files to collect!")
+	}
+
+	if err := l.GarbageCollectBlobs(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := cf.blobs.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the files with old values only must be removed
+	if len(before) > 0 && slices.Contains(after, before[0]) {
+		t.Fatalf("blob file %d is not collected", before[0])
+	}
+
+	for _, key := range keys {
+		if v, _, err := cf.Get(key); err != nil || !bytes.Equal(v, bytes.Repeat(key, 103)) {
+			t.Fatalf("value of %s (l=%d) is lost: %v", key, len(v), err)
+		}
+	}
+}
+
+func TestGarbageCollectBlobsDuringReads(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20), ColumnFamilyConfig("blobs", Config{
+		MemtblDataSize: 1 << 20,
+		BlobThreshold:  16,
+		BlobFileSize:   256,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	cf, _ := l.ColumnFamily("blobs")
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	var put = func(i int) error {
+		for _, key := range keys {
+			if err := cf.Put(key, bytes.Repeat(key, 100+i%10)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := put(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// значения, ссылки на которые прочитаны до сборки мусора, читаются
+	// после нее, а сборщик не читает MemTable во время записей
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	var run = func(fn func(i int) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; ctx.Err() == nil; i++ {
+				if err := fn(i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	run(put)
+	run(func(int) error {
+		for _, key := range keys {
+			if _, _, err := cf.Get(key); err != nil {
+				return fmt.Errorf("get %s: %w", key, err)
+			}
+		}
+		return nil
+	})
+	run(func(int) error {
+		_, errs := cf.MultiGet(keys)
+		return errors.Join(errs...)
+	})
+	run(func(int) error {
+		it, err := cf.NewIterator(nil, nil)
+		if err != nil {
+			return err
+		}
+		// медленный читатель: сборщик успевает переписать значения
+		time.Sleep(time.Millisecond)
+		for it.HasNext() {
+			if _, _, err := it.Next(); err != nil {
+				return fmt.Errorf("iterator: %w", err)
+			}
+		}
+		return nil
+	})
+
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); {
+		if err := cf.GarbageCollectBlobs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
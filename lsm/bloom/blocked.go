@@ -0,0 +1,140 @@
+package bloom
+
+import (
+	"math"
+	"math/bits"
+	"unsafe"
+)
+
+const (
+	// blockWords is the number of words in a block, the size of a block
+	// is 512 bits, a typical cache line.
+	blockWords = 8
+	blockMask  = blockWords<<shift - 1
+	cacheLine  = blockWords * 8
+)
+
+// Blocked represents a blocked Bloom filter.
+//
+// All lookups of an element fall into one block of the size of a cache line,
+// so a membership test costs a single cache miss instead of one miss per
+// lookup in Filter. The price is a higher false-positives rate for the same
+// memory, which Blocked makes up with more bits per element. Filter rounds
+// its size up to a power of 2, so it often uses as much memory or more.
+// Measured on 100000 keys:
+//
+//	    p   Filter bits   rate     Blocked bits   rate
+//	--------------------------------------------------
+//	   16      10.5      0.0102         6.9      0.0403
+//	  100      10.5      0.0058        12.6      0.0049
+//	 1000      21.0      0.0001        21.2      0.0009
+//
+// A test of a present key in a filter of 4M keys takes about 63ns
+// with Blocked and 91ns with Filter.
+type Blocked struct {
+	data    []uint64 // Blocks of the bit array aligned to a cache line.
+	blocks  uint64
+	lookups int
+	count   int64
+	hashing
+}
+
+// NewBlocked creates an empty blocked Bloom filter with room for n elements
+// at a false-positives rate less than 1/p.
+// The options set the hasher and its seed.
+func NewBlocked(n int, p int, options ...Option) *Blocked {
+	// блоки заполняются неравномерно, и ложных срабатываний тем больше,
+	// чем больше проверок, поэтому к оптимальному числу бит добавляется
+	// поправка, растущая с ним квадратично
+	perKey := 1.44 * math.Log2(float64(p))
+	perKey += perKey * perKey / 30
+	blocks := max(uint64(perKey*float64(n)/(blockWords<<shift)), 1)
+
+	return &Blocked{
+		data:    alignedWords(blocks * blockWords),
+		blocks:  blocks,
+		lookups: int(1.4*math.Log(float64(p)) + 1),
+		hashing: newHashing(options),
+	}
+}
+
+// alignedWords выделяет n слов, начало которых выровнено по строке кэша,
+// чтобы каждый блок фильтра занимал ровно одну строку.
+func alignedWords(n uint64) []uint64 {
+	data := make([]uint64, n+blockWords-1)
+	off := uint64((cacheLine - uintptr(unsafe.Pointer(&data[0]))%cacheLine) % cacheLine / 8)
+
+	return data[off : off+n : off+n]
+}
+
+// AddByte adds b to the filter and tells if b was already a likely member.
+func (f *Blocked) AddByte(b []byte) bool {
+	return f.add(f.hash(b))
+}
+
+// Add adds s to the filter and tells if s was already a likely member.
+func (f *Blocked) Add(s string) bool {
+	return f.add(f.hashString(s))
+}
+
+func (f *Blocked) add(h1, h2 uint64) bool {
+	block := f.block(h1)
+	// нечетный шаг дает разные биты блока для всех проверок
+	delta := bits.RotateLeft64(h2, 21) | 1
+	member := true
+	for i := f.lookups; i > 0; i-- {
+		n := h2 & blockMask
+		k, b := n>>shift, uint64(1<<uint(n&mask))
+		if block[k]&b == 0 {
+			member = false
+			block[k] |= b
+		}
+		h2 += delta
+	}
+	if !member {
+		f.count++
+	}
+	return member
+}
+
+// TestByte tells if b is a likely member of the filter.
+// If true, b is probably a member; if false, b is definitely not a member.
+func (f *Blocked) TestByte(b []byte) bool {
+	return f.test(f.hash(b))
+}
+
+// Test tells if s is a likely member of the filter.
+// If true, s is probably a member; if false, s is definitely not a member.
+func (f *Blocked) Test(s string) bool {
+	return f.test(f.hashString(s))
+}
+
+func (f *Blocked) test(h1, h2 uint64) bool {
+	block := f.block(h1)
+	delta := bits.RotateLeft64(h2, 21) | 1
+	for i := f.lookups; i > 0; i-- {
+		n := h2 & blockMask
+		if block[n>>shift]&(1<<uint(n&mask)) == 0 {
+			return false
+		}
+		h2 += delta
+	}
+	return true
+}
+
+// block возвращает блок элемента. Число блоков не обязано быть степенью 2,
+// поэтому блок выбирается умножением, а не взятием остатка.
+func (f *Blocked) block(h uint64) []uint64 {
+	i, _ := bits.Mul64(h, f.blocks)
+	return f.data[i*blockWords : (i+1)*blockWords : (i+1)*blockWords]
+}
+
+// Count returns an estimate of the number of elements in the filter.
+func (f *Blocked) Count() int64 {
+	return f.count
+}
+
+// Size returns the size of the bit array in bytes.
+func (f *Blocked) Size() int {
+	return 8 * len(f.data)
+}
@@ -0,0 +1,102 @@
+package bloom
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+func TestBlocked(t *testing.T) {
+	s1, s2 := "asöldkgjaösldkgaösldkasldgjkaösldkgjöasgkdjg", "elasödlnkgaölsdkfgaölsdkjfaölsdkgaölskgnaösl"
+	for n := 0; n < 100; n++ {
+		for p := 2; p <= 128; p *= 2 {
+			f := NewBlocked(n, p)
+			if f.Test(s1) {
+				t.Fatalf("Test(s1) = true for empty filter %d/%d", n, p)
+			}
+			if f.Add(s1) {
+				t.Fatalf("Add(s1) = true for empty filter %d/%d", n, p)
+			}
+			if !f.Add(s1) || !f.Test(s1) || !f.TestByte([]byte(s1)) {
+				t.Fatalf("s1 is not a member of filter %d/%d", n, p)
+			}
+			if f.Test(s2) {
+				t.Fatalf("Test(s2) = true for filter %d/%d", n, p)
+			}
+			if f.Count() != 1 {
+				t.Fatalf("Count() = %d; want 1", f.Count())
+			}
+			if f.Size()%cacheLine != 0 {
+				t.Fatalf("Size() = %d is not a multiple of a cache line", f.Size())
+			}
+		}
+	}
+}
+
+func TestBlockedAligned(t *testing.T) {
+	for n := 1; n < 64; n++ {
+		f := NewBlocked(n*1000, 100)
+		if f.blocks != uint64(len(f.data)/blockWords) {
+			t.Fatalf("blocks %d != %d", f.blocks, len(f.data)/blockWords)
+		}
+		if addr := uintptr(unsafe.Pointer(&f.data[0])); addr%cacheLine != 0 {
+			t.Fatalf("data address %x is not aligned to cache line", addr)
+		}
+	}
+}
+
+// falsePositives добавляет n ключей в фильтр политики и возвращает долю
+// ложноположительных срабатываний на n других ключах и размер фильтра в битах на ключ.
+func falsePositives(policy FilterPolicy, n int) (float64, float64) {
+	b := policy.NewBuilder(n)
+	for i := 0; i < n; i++ {
+		b.AddKey([]byte("key-" + strconv.Itoa(i)))
+	}
+	f := b.Finish()
+
+	var fp int
+	for i := 0; i < n; i++ {
+		if f.TestByte([]byte("missing-" + strconv.Itoa(i))) {
+			fp++
+		}
+	}
+
+	return float64(fp) / float64(n), float64(8*f.Size()) / float64(n)
+}
+
+func TestFalsePositives(t *testing.T) {
+	const n = 100000
+	for _, p := range []int{16, 100, 1000} {
+		for _, policy := range []FilterPolicy{NewFilterPolicy(p), NewBlockedPolicy(p), NewXorPolicy(p)} {
+			rate, bitsPerKey := falsePositives(policy, n)
+			t.Logf("%s: false positives %.4f, %.1f bits per key", policy.Name(), rate, bitsPerKey)
+			if rate > 1.5/float64(p) {
+				t.Errorf("%s: false positives %.4f, want less than 1/%d", policy.Name(), rate, p)
+			}
+		}
+	}
+}
+
+func BenchmarkPolicyTest(b *testing.B) {
+	keys := make([][]byte, 1<<20)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+	}
+
+	for _, n := range []int{1 << 16, 1 << 22} {
+		for _, policy := range []FilterPolicy{NewFilterPolicy(100), NewBlockedPolicy(100), NewXorPolicy(100)} {
+			builder := policy.NewBuilder(n)
+			for i := 0; i < n; i++ {
+				builder.AddKey([]byte(fmt.Sprintf("key-%d", i)))
+			}
+			f := builder.Finish()
+
+			b.Run(fmt.Sprintf("%s/%d", policy.Name(), n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					f.TestByte(keys[i&(len(keys)-1)])
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package bloom
+
+import (
+	"io"
+	"math"
+)
+
+const (
+	counterBits  = 4
+	counterShift = 4 // log2 of the number of counters in a word
+	counterMask  = 1<<counterShift - 1
+	counterMax   = 1<<counterBits - 1
+)
+
+// Counting represents a counting Bloom filter.
+//
+// Each position of the filter is a 4-bit counter instead of a bit, so
+// elements can be removed as well as added. A counting filter uses four times
+// the memory of Filter with the same false-positives rate. A counter stops
+// at 15 and is never decremented after that, so an overflow can only leave
+// extra likely members, it never causes false negatives.
+//
+// Only elements that have been added may be removed: removing other
+// elements may cause false negatives.
+type Counting struct {
+	data    []uint64 // Counters, 16 per word; the number of counters is a power of 2.
+	lookups int      // Lookups per query
+	count   int64    // Number of added elements not removed yet
+	hashing
+}
+
+// NewCounting creates an empty counting Bloom filter with room for n elements
+// at a false-positives rate less than 1/p.
+// The options set the hasher and its seed.
+func NewCounting(n int, p int, options ...Option) *Counting {
+	minWords := int(0.0325*math.Log(float64(p))*float64(n)) * counterBits
+	words := 1
+	for words < minWords {
+		words *= 2
+	}
+	return &Counting{
+		data:    make([]uint64, words),
+		lookups: int(1.4*math.Log(float64(p)) + 1),
+		hashing: newHashing(options),
+	}
+}
+
+// AddByte adds b to the filter and tells if b was already a likely member.
+func (f *Counting) AddByte(b []byte) bool {
+	return f.add(f.hash(b))
+}
+
+// Add adds s to the filter and tells if s was already a likely member.
+func (f *Counting) Add(s string) bool {
+	return f.add(f.hashString(s))
+}
+
+func (f *Counting) add(h1, h2 uint64) bool {
+	member := f.test(h1, h2)
+	trunc := uint64(len(f.data))<<counterShift - 1
+	for i := f.lookups; i > 0; i-- {
+		h1 += h2
+		k, shift := f.counter(h1 & trunc)
+		if c := f.data[k] >> shift & counterMax; c < counterMax {
+			f.data[k] += 1 << shift
+		}
+	}
+	f.count++
+	return member
+}
+
+// RemoveByte removes b from the filter and tells if b was a likely member.
+// If false, b is definitely not a member and the filter is not changed.
+func (f *Counting) RemoveByte(b []byte) bool {
+	return f.remove(f.hash(b))
+}
+
+// Remove removes s from the filter and tells if s was a likely member.
+// If false, s is definitely not a member and the filter is not changed.
+func (f *Counting) Remove(s string) bool {
+	return f.remove(f.hashString(s))
+}
+
+func (f *Counting) remove(h1, h2 uint64) bool {
+	if !f.test(h1, h2) {
+		return false
+	}
+	trunc := uint64(len(f.data))<<counterShift - 1
+	for i := f.lookups; i > 0; i-- {
+		h1 += h2
+		k, shift := f.counter(h1 & trunc)
+		// переполненный счетчик не знает, сколько элементов в нем учтено
+		if c := f.data[k] >> shift & counterMax; c < counterMax {
+			f.data[k] -= 1 << shift
+		}
+	}
+	f.count--
+	return true
+}
+
+// TestByte tells if b is a likely member of the filter.
+// If true, b is probably a member; if false, b is definitely not a member.
+func (f *Counting) TestByte(b []byte) bool {
+	return f.test(f.hash(b))
+}
+
+// Test tells if s is a likely member of the filter.
+// If true, s is probably a member; if false, s is definitely not a member.
+func (f *Counting) Test(s string) bool {
+	return f.test(f.hashString(s))
+}
+
+func (f *Counting) test(h1, h2 uint64) bool {
+	trunc := uint64(len(f.data))<<counterShift - 1
+	for i := f.lookups; i > 0; i-- {
+		h1 += h2
+		k, shift := f.counter(h1 & trunc)
+		if f.data[k]>>shift&counterMax == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// counter возвращает слово и сдвиг счетчика n.
+func (f *Counting) counter(n uint64) (uint64, uint64) {
+	return n >> counterShift, (n & counterMask) * counterBits
+}
+
+// Count returns the number of elements added to the filter and not removed.
+func (f *Counting) Count() int64 {
+	return f.count
+}
+
+// Size returns the size of the counters in bytes.
+func (f *Counting) Size() int {
+	return 8 * len(f.data)
+}
+
+// Union returns a new counting Bloom filter that consists of all elements
+// that belong to either f1 or f2. The two filters must be of the same size n
+// and have the same false-positives rate p, hasher and seed. The counters of the filters are
+// added, so an element removed from the result is kept if it was added to
+// both filters.
+func (f1 *Counting) Union(f2 *Counting) *Counting {
+	if len(f1.data) != len(f2.data) || f1.lookups != f2.lookups || !f1.same(f2.hashing) {
+		panic("operation requires filters of the same type")
+	}
+	res := &Counting{
+		data:    make([]uint64, len(f1.data)),
+		lookups: f1.lookups,
+		count:   f1.count + f2.count,
+		hashing: f1.hashing,
+	}
+	for i := range res.data {
+		w1, w2 := f1.data[i], f2.data[i]
+		var w uint64
+		for shift := uint64(0); shift < 64; shift += counterBits {
+			c := min(w1>>shift&counterMax+w2>>shift&counterMax, counterMax)
+			w |= c << shift
+		}
+		res.data[i] = w
+	}
+	return res
+}
+
+// MarshalBinary encodes the filter.
+func (f *Counting) MarshalBinary() ([]byte, error) {
+	return marshalWords(countingVersion, f.hashing, f.lookups, f.count, f.data), nil
+}
+
+// UnmarshalBinary decodes the filter encoded by MarshalBinary.
+func (f *Counting) UnmarshalBinary(data []byte) error {
+	h, words, err := unmarshalWords(countingVersion, data)
+	if err != nil {
+		return err
+	}
+
+	f.hashing, f.lookups, f.count, f.data = h.hashing, h.lookups, h.count, words
+	return nil
+}
+
+// WriteTo writes the encoded filter to w.
+func (f *Counting) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(marshalWords(countingVersion, f.hashing, f.lookups, f.count, f.data))
+	return int64(n), err
+}
+
+// ReadFrom reads the filter written by WriteTo from r.
+// It reads exactly the encoded filter, so filters may follow each other in a stream.
+func (f *Counting) ReadFrom(r io.Reader) (int64, error) {
+	data, n, err := readWords(countingVersion, r)
+	if err != nil {
+		return n, err
+	}
+
+	return n, f.UnmarshalBinary(data)
+}
@@ -0,0 +1,144 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCounting(t *testing.T) {
+	f := NewCounting(1000, 100)
+	for i := 0; i < 1000; i++ {
+		if f.Add(fmt.Sprintf("key-%d", i)) && i == 0 {
+			t.Fatal("Add(key-0) = true for empty filter")
+		}
+	}
+	if f.Count() != 1000 {
+		t.Fatalf("Count() = %d; want 1000", f.Count())
+	}
+
+	for i := 0; i < 1000; i += 2 {
+		if !f.Remove(fmt.Sprintf("key-%d", i)) {
+			t.Fatalf("Remove(key-%d) = false", i)
+		}
+	}
+	if f.Count() != 500 {
+		t.Fatalf("Count() = %d; want 500", f.Count())
+	}
+
+	var removed int
+	for i := 0; i < 1000; i++ {
+		member := f.TestByte([]byte(fmt.Sprintf("key-%d", i)))
+		if i%2 == 1 && !member {
+			t.Fatalf("key-%d is not a member after removal of other keys", i)
+		}
+		if i%2 == 0 && !member {
+			removed++
+		}
+	}
+	if removed < 490 {
+		t.Fatalf("%d of 500 removed keys are not members", removed)
+	}
+
+	if f.Remove("missing") {
+		t.Fatal("Remove(missing) = true")
+	}
+	if f.Count() != 500 {
+		t.Fatalf("Count() = %d after removal of missing key; want 500", f.Count())
+	}
+}
+
+func TestCountingDuplicates(t *testing.T) {
+	f := NewCounting(10, 100)
+	f.Add("a")
+	if !f.Add("a") {
+		t.Fatal("Add(a) = false for member")
+	}
+	f.Remove("a")
+	if !f.Test("a") {
+		t.Fatal("a is not a member after one of two removals")
+	}
+	f.Remove("a")
+	if f.Test("a") {
+		t.Fatal("a is a member after all removals")
+	}
+
+	// переполненные счетчики не уменьшаются
+	for i := 0; i < 2*counterMax; i++ {
+		f.Add("b")
+	}
+	for i := 0; i < 2*counterMax; i++ {
+		f.Remove("b")
+	}
+	if !f.Test("b") {
+		t.Fatal("b is not a member after overflow")
+	}
+}
+
+func TestCountingUnion(t *testing.T) {
+	f1, f2 := NewCounting(100, 100), NewCounting(100, 100)
+	f1.Add("a")
+	f1.Add("both")
+	f2.Add("b")
+	f2.Add("both")
+
+	f := f1.Union(f2)
+	for _, s := range []string{"a", "b", "both"} {
+		if !f.Test(s) {
+			t.Fatalf("%s is not a member of union", s)
+		}
+	}
+	if f.Count() != 4 {
+		t.Fatalf("Count() = %d; want 4", f.Count())
+	}
+
+	f.Remove("both")
+	if !f.Test("both") {
+		t.Fatal("both is not a member of union after one removal")
+	}
+	f.Remove("a")
+	if f.Test("a") {
+		t.Fatal("a is a member of union after removal")
+	}
+}
+
+func TestCountingEncoding(t *testing.T) {
+	f := NewCounting(100, 100)
+	for i := 0; i < 100; i++ {
+		f.Add(fmt.Sprintf("key-%d", i))
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Clone(buf.Bytes())
+
+	var g Counting
+	if _, err := g.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if g.Count() != f.Count() || g.lookups != f.lookups {
+		t.Fatalf("decoded filter %d/%d != %d/%d", g.Count(), g.lookups, f.Count(), f.lookups)
+	}
+	for i := 0; i < 100; i++ {
+		if !g.Remove(fmt.Sprintf("key-%d", i)) {
+			t.Fatalf("key-%d is not a member of decoded filter", i)
+		}
+	}
+
+	var bf Filter
+	if err := bf.UnmarshalBinary(data); !errors.Is(err, ErrVersion) {
+		t.Fatalf("decoding counting filter as Filter: err %v != %v", err, ErrVersion)
+	}
+}
+
+func BenchmarkCountingAddRemove(b *testing.B) {
+	f := NewCounting(1<<20, 100)
+	bytes := []byte(fox)
+	for i := 0; i < b.N; i++ {
+		f.AddByte(bytes)
+		f.RemoveByte(bytes)
+	}
+}
@@ -0,0 +1,217 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+)
+
+// Encoding
+//
+// A filter is encoded independently of the byte order of the machine,
+// all integers are little-endian:
+//
+//	[version uint8][hasher uint8][seed uint64][bits uint64][lookups uint32]
+//	[count uint64][bit array: bits/64 words uint64][checksum uint32]
+//
+// The hasher is the Algorithm of the filter, see Hasher. The checksum
+// is CRC-32C of all preceding bytes. Counting is encoded the same way
+// with its own version, its bit array holds the counters.
+//
+// Filters of the version 1 have no hasher and seed, they are hashed
+// by Murmur3 with the seed 0 and are still decoded.
+
+const (
+	// version is the current version of the encoding of Filter.
+	version = 2
+	// countingVersion is the current version of the encoding of Counting.
+	countingVersion = 0x42
+	// legacy is the difference between the current version and
+	// the version 1 without the hasher and the seed.
+	legacy = 1
+
+	headerSize       = 1 + 1 + 8 + 8 + 4 + 8
+	legacyHeaderSize = 1 + 8 + 4 + 8
+	sumSize          = 4
+)
+
+var (
+	// ErrVersion is returned when decoding a filter of an unknown version.
+	ErrVersion = errors.New("bloom: unsupported filter version")
+	// ErrCorrupted is returned when the encoded filter is malformed
+	// or does not match its checksum.
+	ErrCorrupted = errors.New("bloom: corrupted filter")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// MarshalBinary encodes the filter.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	return marshalWords(version, f.hashing, f.lookups, f.count, f.data), nil
+}
+
+// UnmarshalBinary decodes the filter encoded by MarshalBinary.
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	h, words, err := unmarshalWords(version, data)
+	if err != nil {
+		return err
+	}
+
+	f.hashing, f.lookups, f.count, f.data = h.hashing, h.lookups, h.count, words
+	return nil
+}
+
+// WriteTo writes the encoded filter to w.
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(marshalWords(version, f.hashing, f.lookups, f.count, f.data))
+	return int64(n), err
+}
+
+// ReadFrom reads the filter written by WriteTo from r.
+// It reads exactly the encoded filter, so filters may follow each other in a stream.
+func (f *Filter) ReadFrom(r io.Reader) (int64, error) {
+	data, n, err := readWords(version, r)
+	if err != nil {
+		return n, err
+	}
+
+	return n, f.UnmarshalBinary(data)
+}
+
+// header является декодированным заголовком фильтра.
+type header struct {
+	hashing
+	words   int
+	lookups int
+	count   int64
+}
+
+// marshalWords кодирует заголовок и слова фильтра.
+func marshalWords(version byte, h hashing, lookups int, count int64, words []uint64) []byte {
+	buf := make([]byte, 0, headerSize+8*len(words)+sumSize)
+	buf = append(buf, version, byte(h.algorithm()))
+	buf = binary.LittleEndian.AppendUint64(buf, h.seed)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(words))<<shift)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(lookups))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(count))
+	for _, w := range words {
+		buf = binary.LittleEndian.AppendUint64(buf, w)
+	}
+
+	return binary.LittleEndian.AppendUint32(buf, crc32.Checksum(buf, castagnoli))
+}
+
+// unmarshalWords декодирует фильтр, закодированный marshalWords с той же версией.
+func unmarshalWords(version byte, data []byte) (header, []uint64, error) {
+	if len(data) == 0 {
+		return header{}, nil, fmt.Errorf("%w: empty data", ErrCorrupted)
+	}
+	size, err := headerLen(version, data[0])
+	if err != nil {
+		return header{}, nil, err
+	}
+	if len(data) < size+sumSize {
+		return header{}, nil, fmt.Errorf("%w: size %d less than header", ErrCorrupted, len(data))
+	}
+
+	h, err := decodeHeader(data[:size])
+	if err != nil {
+		return header{}, nil, err
+	}
+	if want := size + 8*h.words + sumSize; len(data) != want {
+		return header{}, nil, fmt.Errorf("%w: size %d, want %d", ErrCorrupted, len(data), want)
+	}
+
+	body, sum := data[:len(data)-sumSize], binary.LittleEndian.Uint32(data[len(data)-sumSize:])
+	if crc32.Checksum(body, castagnoli) != sum {
+		return header{}, nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+
+	words := make([]uint64, h.words)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(data[size+8*i:])
+	}
+
+	return h, words, nil
+}
+
+// readWords читает из r ровно один закодированный фильтр.
+func readWords(version byte, r io.Reader) ([]byte, int64, error) {
+	data := make([]byte, 1, headerSize)
+	n, err := io.ReadFull(r, data)
+	if err != nil {
+		return nil, int64(n), unexpectedEOF(err)
+	}
+	size, err := headerLen(version, data[0])
+	if err != nil {
+		return nil, int64(n), err
+	}
+
+	data = data[:size]
+	m, err := io.ReadFull(r, data[1:])
+	n += m
+	if err != nil {
+		return nil, int64(n), unexpectedEOF(err)
+	}
+	h, err := decodeHeader(data)
+	if err != nil {
+		return nil, int64(n), err
+	}
+
+	data = append(data, make([]byte, 8*h.words+sumSize)...)
+	m, err = io.ReadFull(r, data[size:])
+	n += m
+	if err != nil {
+		return nil, int64(n), unexpectedEOF(err)
+	}
+
+	return data, int64(n), nil
+}
+
+// headerLen проверяет версию фильтра и возвращает размер его заголовка.
+func headerLen(version, got byte) (int, error) {
+	switch got {
+	case version:
+		return headerSize, nil
+	case version - legacy:
+		return legacyHeaderSize, nil
+	}
+
+	return 0, fmt.Errorf("%w: %d", ErrVersion, got)
+}
+
+// decodeHeader декодирует заголовок, версия которого проверена headerLen.
+func decodeHeader(data []byte) (header, error) {
+	var h header
+	if len(data) == headerSize {
+		hasher, err := hasherOf(Algorithm(data[1]))
+		if err != nil {
+			return header{}, err
+		}
+		h.hasher, h.seed = hasher, binary.LittleEndian.Uint64(data[2:10])
+		data = data[10:]
+	} else {
+		data = data[1:]
+	}
+
+	nbits := binary.LittleEndian.Uint64(data[0:8])
+	if nbits < 1<<shift || bits.OnesCount64(nbits) != 1 || nbits > 1<<40 {
+		return header{}, fmt.Errorf("%w: invalid bit count %d", ErrCorrupted, nbits)
+	}
+	h.words = int(nbits >> shift)
+	h.lookups = int(binary.LittleEndian.Uint32(data[8:12]))
+	h.count = int64(binary.LittleEndian.Uint64(data[12:20]))
+
+	return h, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
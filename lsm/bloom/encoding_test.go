@@ -0,0 +1,132 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	f := New(100, 64)
+	for i := 0; i < 100; i++ {
+		f.Add(fmt.Sprintf("key-%d", i))
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var g Filter
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if g.lookups != f.lookups || g.count != f.count || len(g.data) != len(f.data) {
+		t.Fatalf("decoded filter %d/%d/%d != %d/%d/%d", g.lookups, g.count, len(g.data), f.lookups, f.count, len(f.data))
+	}
+	for i := 0; i < 100; i++ {
+		if !g.Test(fmt.Sprintf("key-%d", i)) {
+			t.Fatalf("key-%d is not a member of decoded filter", i)
+		}
+	}
+}
+
+func TestEncodingByteOrder(t *testing.T) {
+	f := New(1, 2)
+	f.Add("hello")
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// версия, Murmur3, зерно 0, 64 бита, 1 проверка, 1 элемент, слово битового массива
+	want := "02" + "00" + "0000000000000000" + "4000000000000000" + "01000000" + "0100000000000000" + "0000000800000000"
+	if got := hex.EncodeToString(data[:len(data)-sumSize]); got != want {
+		t.Fatalf("encoded filter %s != %s", got, want)
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	// фильтр версии 1 без хеш-функции и зерна
+	data, err := hex.DecodeString("01" + "4000000000000000" + "01000000" + "0100000000000000" + "0000000800000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = binary.LittleEndian.AppendUint32(data, crc32.Checksum(data, castagnoli))
+
+	var f Filter
+	if err := f.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Test("hello") || f.Count() != 1 || f.algorithm() != AlgMurmur3 || f.seed != 0 {
+		t.Fatalf("decoded legacy filter %+v", f)
+	}
+
+	var g Filter
+	if n, err := g.ReadFrom(bytes.NewReader(data)); err != nil || n != int64(len(data)) || !g.Test("hello") {
+		t.Fatalf("read legacy filter: %d bytes, %v", n, err)
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	f1, f2 := New(10, 8), New(1000, 128)
+	f1.Add("a")
+	f2.Add("b")
+
+	var buf bytes.Buffer
+	for _, f := range []*Filter{f1, f2} {
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := buf.Len()
+
+	var g1, g2 Filter
+	n1, err := g1.ReadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, err := g2.ReadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n1+n2) != size {
+		t.Fatalf("read %d + %d != %d", n1, n2, size)
+	}
+	if !g1.Test("a") || !g2.Test("b") {
+		t.Fatal("keys are not members of read filters")
+	}
+
+	var g Filter
+	if _, err := g.ReadFrom(&buf); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("empty stream err %v != %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestUnmarshalCorrupted(t *testing.T) {
+	data, err := New(10, 8).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f Filter
+	corrupted := bytes.Clone(data)
+	corrupted[headerSize] ^= 1
+	if err := f.UnmarshalBinary(corrupted); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("checksum err %v != %v", err, ErrCorrupted)
+	}
+	if err := f.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("truncated err %v != %v", err, ErrCorrupted)
+	}
+
+	corrupted = bytes.Clone(data)
+	corrupted[0] = version + 1
+	if err := f.UnmarshalBinary(corrupted); !errors.Is(err, ErrVersion) {
+		t.Fatalf("version err %v != %v", err, ErrVersion)
+	}
+}
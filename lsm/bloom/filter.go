@@ -40,14 +40,18 @@
 //
 // Each membership test makes a single call to a 128-bit hash function.
 // This improves speed without increasing the false-positives rate
-// as shown by Kirsch and Mitzenmacher.
+// as shown by Kirsch and Mitzenmacher. The hash function is MurmurHash3
+// by default, other functions and a seed are set with WithHasher and
+// WithSeed, see Hasher.
 //
 // Limitations
 //
 // This implementation is not intended for cryptographic use.
 //
 // The internal data representation is different for big-endian
-// and little-endian machines.
+// and little-endian machines. The encoding produced by MarshalBinary
+// and WriteTo does not depend on the byte order, so encoded filters
+// can be stored and shipped between machines.
 //
 // Typical use case
 //
@@ -70,11 +74,13 @@ type Filter struct {
 	data    []uint64 // Bit array, the length is a power of 2.
 	lookups int      // Lookups per query
 	count   int64    // Estimate number of elements
+	hashing
 }
 
 // New creates an empty Bloom filter with room for n elements
 // at a false-positives rate less than 1/p.
-func New(n int, p int) *Filter {
+// The options set the hasher and its seed.
+func New(n int, p int, options ...Option) *Filter {
 	minWords := int(0.0325 * math.Log(float64(p)) * float64(n))
 	words := 1
 	for words < minWords {
@@ -83,17 +89,18 @@ func New(n int, p int) *Filter {
 	return &Filter{
 		data:    make([]uint64, words),
 		lookups: int(1.4*math.Log(float64(p)) + 1),
+		hashing: newHashing(options),
 	}
 }
 
 // AddByte adds b to the filter and tells if b was already a likely member.
 func (f *Filter) AddByte(b []byte) bool {
-	return f.add(hash(b))
+	return f.add(f.hash(b))
 }
 
 // Add adds s to the filter and tells if s was already a likely member.
 func (f *Filter) Add(s string) bool {
-	return f.add(hashString(s))
+	return f.add(f.hashString(s))
 }
 
 func (f *Filter) add(h1, h2 uint64) bool {
@@ -117,13 +124,13 @@ func (f *Filter) add(h1, h2 uint64) bool {
 // TestByte tells if b is a likely member of the filter.
 // If true, b is probably a member; if false, b is definitely not a member.
 func (f *Filter) TestByte(b []byte) bool {
-	return f.test(hash(b))
+	return f.test(f.hash(b))
 }
 
 // Test tells if s is a likely member of the filter.
 // If true, s is probably a member; if false, s is definitely not a member.
 func (f *Filter) Test(s string) bool {
-	return f.test(hashString(s))
+	return f.test(f.hashString(s))
 }
 
 func (f *Filter) test(h1, h2 uint64) bool {
@@ -144,20 +151,27 @@ func (f *Filter) Count() int64 {
 	return f.count
 }
 
+// Size returns the size of the bit array in bytes.
+func (f *Filter) Size() int {
+	return 8 * len(f.data)
+}
+
 // Union returns a new Bloom filter that consists of all elements
 // that belong to either f1 or f2. The two filters must be of
-// the same size n and have the same false-positives rate p.
+// the same size n, have the same false-positives rate p and
+// the same hasher and seed.
 //
 // The resulting filter is the same as the filter created
 // from scratch using the union of the two sets.
 func (f1 *Filter) Union(f2 *Filter) *Filter {
-	if len(f1.data) != len(f2.data) || f1.lookups != f2.lookups {
+	if len(f1.data) != len(f2.data) || f1.lookups != f2.lookups || !f1.same(f2.hashing) {
 		panic("operation requires filters of the same type")
 	}
 	len := len(f1.data)
 	res := &Filter{
 		data:    make([]uint64, len),
 		lookups: f1.lookups,
+		hashing: f1.hashing,
 	}
 	bitCount := 0
 	for i := 0; i < len; i++ {
@@ -27,7 +27,8 @@ func uint64string(s string) uint64 {
 		uint64(s[4])<<32 | uint64(s[5])<<40 | uint64(s[6])<<48 | uint64(s[7])<<56
 }
 
-func hash(b []byte) (h1, h2 uint64) {
+func hash(b []byte, seed uint64) (h1, h2 uint64) {
+	h1, h2 = seed, seed
 	nblocks := len(b) / 16
 	for i := 0; i < nblocks; i++ {
 		j := 16 * i
@@ -118,7 +119,8 @@ func hash(b []byte) (h1, h2 uint64) {
 	return
 }
 
-func hashString(s string) (h1, h2 uint64) {
+func hashString(s string, seed uint64) (h1, h2 uint64) {
+	h1, h2 = seed, seed
 	nblocks := len(s) / 16
 	for i := 0; i < nblocks; i++ {
 		j := 16 * i
@@ -16,7 +16,7 @@ func TestHash(t *testing.T) {
 		{0xcd99481f9ee902c9, 0x695da1a38987b6e7, "The quick brown fox jumps over the lazy dog."},
 	}
 	for _, x := range data {
-		h1, h2 := hash([]byte(x.s))
+		h1, h2 := hash([]byte(x.s), 0)
 		if h1 != x.h1 {
 			t.Errorf("hash(%q).h1 = %d; want %d\n", x.s, h1, x.h1)
 		}
@@ -38,7 +38,7 @@ func TestHashString(t *testing.T) {
 		{0xcd99481f9ee902c9, 0x695da1a38987b6e7, "The quick brown fox jumps over the lazy dog."},
 	}
 	for _, x := range data {
-		h1, h2 := hashString(x.s)
+		h1, h2 := hashString(x.s, 0)
 		if h1 != x.h1 {
 			t.Errorf("hashString(%q).h1 = %d; want %d\n", x.s, h1, x.h1)
 		}
@@ -0,0 +1,260 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+// ErrHasher is returned when decoding a filter hashed by an unknown algorithm.
+var ErrHasher = errors.New("bloom: unknown hash algorithm")
+
+// Algorithm identifies a hash function in encoded filters.
+// Values from 128 are reserved for hashers registered with RegisterHasher.
+type Algorithm uint8
+
+const (
+	AlgMurmur3 Algorithm = iota
+	AlgXXHash64
+	AlgFNV1a
+)
+
+// Hasher is a family of seeded hash functions that return two 64-bit hashes
+// of an element. Filters derive all lookups of an element from the two hashes.
+// Filters with different seeds hash the same elements differently, so their
+// false positives are independent and cannot be predicted without the seed.
+type Hasher interface {
+	// Algorithm identifies the hasher in encoded filters.
+	Algorithm() Algorithm
+	// Hash returns the hashes of b with the seed.
+	Hash(b []byte, seed uint64) (h1, h2 uint64)
+	// HashString returns the same hashes as Hash([]byte(s), seed).
+	HashString(s string, seed uint64) (h1, h2 uint64)
+}
+
+var (
+	// Murmur3 is the 128-bit MurmurHash3, the default hasher.
+	// With the seed 0 it hashes as filters before seeds were added.
+	Murmur3 Hasher = murmur3Hasher{}
+	// XXHash64 is the 64-bit xxHash, the second hash is derived from the first.
+	XXHash64 Hasher = xxhash64Hasher{}
+	// FNV1a is the 64-bit FNV-1a with the bits mixed by the finalizer of
+	// MurmurHash3, the second hash is derived from the first.
+	FNV1a Hasher = fnv1aHasher{}
+)
+
+var (
+	hashersMu sync.RWMutex
+	hashers   = map[Algorithm]Hasher{
+		AlgMurmur3:  Murmur3,
+		AlgXXHash64: XXHash64,
+		AlgFNV1a:    FNV1a,
+	}
+)
+
+// RegisterHasher makes the hasher available to decode filters hashed by it.
+// It panics if a hasher with the same algorithm is already registered.
+func RegisterHasher(h Hasher) {
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+
+	if _, ok := hashers[h.Algorithm()]; ok {
+		panic(fmt.Sprintf("bloom: hasher %d is already registered", h.Algorithm()))
+	}
+	hashers[h.Algorithm()] = h
+}
+
+func hasherOf(alg Algorithm) (Hasher, error) {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+
+	h, ok := hashers[alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrHasher, alg)
+	}
+	return h, nil
+}
+
+// Option configures the hashing of a filter.
+type Option func(*hashing)
+
+// WithHasher sets the hasher of the filter, Murmur3 by default.
+func WithHasher(h Hasher) Option {
+	return func(o *hashing) {
+		o.hasher = h
+	}
+}
+
+// WithSeed sets the seed of the hasher of the filter, 0 by default.
+func WithSeed(seed uint64) Option {
+	return func(o *hashing) {
+		o.seed = seed
+	}
+}
+
+// hashing хеширует элементы фильтра. Нулевое значение хеширует
+// Murmur3 с зерном 0.
+type hashing struct {
+	hasher Hasher
+	seed   uint64
+}
+
+func newHashing(options []Option) hashing {
+	var h hashing
+	for _, option := range options {
+		option(&h)
+	}
+	return h
+}
+
+func (h hashing) algorithm() Algorithm {
+	if h.hasher == nil {
+		return AlgMurmur3
+	}
+	return h.hasher.Algorithm()
+}
+
+func (h hashing) hash(b []byte) (uint64, uint64) {
+	if h.hasher == nil {
+		return hash(b, h.seed)
+	}
+	return h.hasher.Hash(b, h.seed)
+}
+
+func (h hashing) hashString(s string) (uint64, uint64) {
+	if h.hasher == nil {
+		return hashString(s, h.seed)
+	}
+	return h.hasher.HashString(s, h.seed)
+}
+
+func (h hashing) same(other hashing) bool {
+	return h.algorithm() == other.algorithm() && h.seed == other.seed
+}
+
+type murmur3Hasher struct{}
+
+func (murmur3Hasher) Algorithm() Algorithm { return AlgMurmur3 }
+
+func (murmur3Hasher) Hash(b []byte, seed uint64) (uint64, uint64) {
+	return hash(b, seed)
+}
+
+func (murmur3Hasher) HashString(s string, seed uint64) (uint64, uint64) {
+	return hashString(s, seed)
+}
+
+type xxhash64Hasher struct{}
+
+func (xxhash64Hasher) Algorithm() Algorithm { return AlgXXHash64 }
+
+func (xxhash64Hasher) Hash(b []byte, seed uint64) (uint64, uint64) {
+	return split(xxhash64(b, seed))
+}
+
+func (xxhash64Hasher) HashString(s string, seed uint64) (uint64, uint64) {
+	return split(xxhash64(s, seed))
+}
+
+type fnv1aHasher struct{}
+
+func (fnv1aHasher) Algorithm() Algorithm { return AlgFNV1a }
+
+func (fnv1aHasher) Hash(b []byte, seed uint64) (uint64, uint64) {
+	return split(fmix(fnv1a(b, seed)))
+}
+
+func (fnv1aHasher) HashString(s string, seed uint64) (uint64, uint64) {
+	return split(fmix(fnv1a(s, seed)))
+}
+
+// split выводит второй хеш из первого для 64-битных функций.
+func split(h uint64) (uint64, uint64) {
+	return h, fmix(h + 0x9e3779b97f4a7c15)
+}
+
+// xxHash64 adapted from the specification by Yann Collet
+// github.com/Cyan4973/xxHash, released under BSD-2-Clause.
+
+const (
+	prime64x1 = 11400714785074694791
+	prime64x2 = 14029467366897019727
+	prime64x3 = 1609587929392839161
+	prime64x4 = 9650029242287828579
+	prime64x5 = 2870177450012600261
+)
+
+func xxhash64[T []byte | string](b T, seed uint64) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		v1, v2, v3, v4 := seed+prime64x1+prime64x2, seed+prime64x2, seed, seed-prime64x1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxround(v1, le64(b[0:8]))
+			v2 = xxround(v2, le64(b[8:16]))
+			v3 = xxround(v3, le64(b[16:24]))
+			v4 = xxround(v4, le64(b[24:32]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		for _, v := range [4]uint64{v1, v2, v3, v4} {
+			h ^= xxround(0, v)
+			h = h*prime64x1 + prime64x4
+		}
+	} else {
+		h = seed + prime64x5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxround(0, le64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*prime64x1 + prime64x4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(le32(b[:4])) * prime64x1
+		h = bits.RotateLeft64(h, 23)*prime64x2 + prime64x3
+		b = b[4:]
+	}
+	for i := 0; i < len(b); i++ {
+		h ^= uint64(b[i]) * prime64x5
+		h = bits.RotateLeft64(h, 11) * prime64x1
+	}
+
+	h ^= h >> 33
+	h *= prime64x2
+	h ^= h >> 29
+	h *= prime64x3
+	h ^= h >> 32
+	return h
+}
+
+func xxround(acc, input uint64) uint64 {
+	acc += input * prime64x2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime64x1
+}
+
+func le64[T []byte | string](b T) uint64 {
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+}
+
+func le32[T []byte | string](b T) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// fnv1a возвращает FNV-1a. Зерно меняет начальное значение,
+// с зерном 0 функция совпадает с hash/fnv.New64a.
+func fnv1a[T []byte | string](b T, seed uint64) uint64 {
+	h := uint64(fnvOffset64) ^ fmix(seed)
+	for i := 0; i < len(b); i++ {
+		h ^= uint64(b[i])
+		h *= fnvPrime64
+	}
+	return h
+}
@@ -0,0 +1,166 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	var data = []struct {
+		h uint64
+		s string
+	}{
+		{0xef46db3751d8e999, ""},
+		{0x44bc2cf5ad770999, "abc"},
+		{0x0b242d361fda71bc, "The quick brown fox jumps over the lazy dog"},
+	}
+	for _, x := range data {
+		if h := xxhash64([]byte(x.s), 0); h != x.h {
+			t.Errorf("xxhash64(%q) = %#x; want %#x", x.s, h, x.h)
+		}
+		if h := xxhash64(x.s, 0); h != x.h {
+			t.Errorf("xxhash64(string %q) = %#x; want %#x", x.s, h, x.h)
+		}
+	}
+}
+
+func TestFNV1a(t *testing.T) {
+	for _, s := range []string{"", "a", "hello, world", "The quick brown fox jumps over the lazy dog."} {
+		h := fnv.New64a()
+		h.Write([]byte(s))
+		if got := fnv1a(s, 0); got != h.Sum64() {
+			t.Errorf("fnv1a(%q) = %#x; want %#x", s, got, h.Sum64())
+		}
+	}
+}
+
+func TestHashers(t *testing.T) {
+	for _, hasher := range []Hasher{Murmur3, XXHash64, FNV1a} {
+		for _, s := range []string{"", "a", "hello, world", "The quick brown fox jumps over the lazy dog."} {
+			h1, h2 := hasher.Hash([]byte(s), 42)
+			s1, s2 := hasher.HashString(s, 42)
+			if h1 != s1 || h2 != s2 {
+				t.Errorf("%d: Hash(%q) != HashString(%q)", hasher.Algorithm(), s, s)
+			}
+			if u1, _ := hasher.Hash([]byte(s), 43); u1 == h1 {
+				t.Errorf("%d: Hash(%q) does not depend on seed", hasher.Algorithm(), s)
+			}
+		}
+	}
+
+	if h1, h2 := Murmur3.Hash([]byte("hello"), 0); h1 != 0xcbd8a7b341bd9b02 || h2 != 0x5b1e906a48ae1d19 {
+		t.Errorf("Murmur3 with seed 0 differs from hash")
+	}
+}
+
+func TestHasherPolicies(t *testing.T) {
+	const n = 100000
+	for _, hasher := range []Hasher{Murmur3, XXHash64, FNV1a} {
+		for _, policy := range []FilterPolicy{
+			NewFilterPolicy(100, WithHasher(hasher), WithSeed(7)),
+			NewBlockedPolicy(100, WithHasher(hasher), WithSeed(7)),
+			NewXorPolicy(100, WithHasher(hasher), WithSeed(7)),
+		} {
+			rate, _ := falsePositives(policy, n)
+			if rate > 1.5/100 {
+				t.Errorf("%s with hasher %d: false positives %.4f, want less than 1/100", policy.Name(), hasher.Algorithm(), rate)
+			}
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	f1, f2 := New(1000, 100, WithSeed(1)), New(1000, 100, WithSeed(2))
+	for i := 0; i < 1000; i++ {
+		f1.Add(fmt.Sprintf("key-%d", i))
+		f2.Add(fmt.Sprintf("key-%d", i))
+	}
+
+	// ложные срабатывания фильтров с разными зернами не совпадают
+	var fp1, fp2, both int
+	for i := 0; i < 100000; i++ {
+		s := fmt.Sprintf("missing-%d", i)
+		t1, t2 := f1.Test(s), f2.Test(s)
+		if t1 {
+			fp1++
+		}
+		if t2 {
+			fp2++
+		}
+		if t1 && t2 {
+			both++
+		}
+	}
+	if both*10 > min(fp1, fp2) {
+		t.Fatalf("%d common false positives of %d and %d", both, fp1, fp2)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("union of filters with different seeds did not panic")
+		}
+	}()
+	f1.Union(f2)
+}
+
+func TestEncodingHasher(t *testing.T) {
+	f := New(100, 100, WithHasher(XXHash64), WithSeed(0xdeadbeef))
+	c := NewCounting(100, 100, WithHasher(FNV1a), WithSeed(1))
+	for i := 0; i < 100; i++ {
+		f.Add(fmt.Sprintf("key-%d", i))
+		c.Add(fmt.Sprintf("key-%d", i))
+	}
+
+	var buf bytes.Buffer
+	f.WriteTo(&buf)
+	c.WriteTo(&buf)
+
+	var g Filter
+	var d Counting
+	if _, err := g.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if g.algorithm() != AlgXXHash64 || g.seed != 0xdeadbeef || d.algorithm() != AlgFNV1a || d.seed != 1 {
+		t.Fatalf("decoded hashers %d/%#x and %d/%#x", g.algorithm(), g.seed, d.algorithm(), d.seed)
+	}
+	for i := 0; i < 100; i++ {
+		if !g.Test(fmt.Sprintf("key-%d", i)) || !d.Test(fmt.Sprintf("key-%d", i)) {
+			t.Fatalf("key-%d is not a member of decoded filters", i)
+		}
+	}
+}
+
+// customHasher хеширует как Murmur3 под своим номером алгоритма.
+type customHasher struct {
+	murmur3Hasher
+}
+
+func (customHasher) Algorithm() Algorithm { return 200 }
+
+func TestRegisterHasher(t *testing.T) {
+	f := New(10, 100, WithHasher(customHasher{}))
+	f.Add("a")
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var g Filter
+	if err := g.UnmarshalBinary(data); !errors.Is(err, ErrHasher) {
+		t.Fatalf("decoding with unknown hasher: err %v != %v", err, ErrHasher)
+	}
+
+	RegisterHasher(customHasher{})
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !g.Test("a") {
+		t.Fatal("a is not a member of decoded filter")
+	}
+}
@@ -0,0 +1,168 @@
+package bloom
+
+import "fmt"
+
+// KeyFilter tests keys for membership in a set.
+type KeyFilter interface {
+	// TestByte tells if key is a likely member of the set.
+	// If false, key is definitely not a member.
+	TestByte(key []byte) bool
+	// Size returns the memory used by the filter in bytes.
+	Size() int
+}
+
+// FilterBuilder collects the keys of a table and builds its filter.
+type FilterBuilder interface {
+	// AddKey adds the key to the set.
+	AddKey(key []byte)
+	// Finish returns the filter of the added keys.
+	// The builder must not be used after it.
+	Finish() KeyFilter
+}
+
+// FilterPolicy creates the filters of tables. Each table gets the filter
+// of the policy it was built with, so tables of a db may have filters
+// of different kinds.
+type FilterPolicy interface {
+	// Name returns the name of the policy.
+	Name() string
+	// NewBuilder returns a builder of a filter for about n keys.
+	NewBuilder(n int) FilterBuilder
+}
+
+// NewFilterPolicy returns the policy that builds Filter
+// with the false-positives rate less than 1/p and the options.
+func NewFilterPolicy(p int, options ...Option) FilterPolicy {
+	return filterPolicy{p: p, options: options}
+}
+
+// NewBlockedPolicy returns the policy that builds Blocked
+// with the false-positives rate less than 1/p and the options.
+func NewBlockedPolicy(p int, options ...Option) FilterPolicy {
+	return blockedPolicy{p: p, options: options}
+}
+
+// NewXorPolicy returns the policy that builds a xor filter with
+// the false-positives rate not greater than 1/p: Xor8 for p up to 256
+// and Xor16 for greater p. The builder keeps a hash of each added key
+// until Finish builds the filter. The options set the hasher and its seed.
+func NewXorPolicy(p int, options ...Option) FilterPolicy {
+	return xorPolicy{wide: p > 256, options: options}
+}
+
+// NewScalablePolicy returns the policy that builds Scalable with
+// the false-positives rate less than 1/p. The number of keys given to
+// the builder is only the initial capacity, so the rate holds even
+// if the table gets more keys. The options set the hasher and its seed.
+func NewScalablePolicy(p int, options ...Option) FilterPolicy {
+	return scalablePolicy{p: p, options: options}
+}
+
+type filterPolicy struct {
+	p       int
+	options []Option
+}
+
+func (p filterPolicy) Name() string {
+	return fmt.Sprintf("bloom.Filter(1/%d)", p.p)
+}
+
+func (p filterPolicy) NewBuilder(n int) FilterBuilder {
+	return filterBuilder{New(n, p.p, p.options...)}
+}
+
+type filterBuilder struct {
+	*Filter
+}
+
+func (b filterBuilder) AddKey(key []byte) {
+	b.AddByte(key)
+}
+
+func (b filterBuilder) Finish() KeyFilter {
+	return b.Filter
+}
+
+type blockedPolicy struct {
+	p       int
+	options []Option
+}
+
+func (p blockedPolicy) Name() string {
+	return fmt.Sprintf("bloom.Blocked(1/%d)", p.p)
+}
+
+func (p blockedPolicy) NewBuilder(n int) FilterBuilder {
+	return blockedBuilder{NewBlocked(n, p.p, p.options...)}
+}
+
+type blockedBuilder struct {
+	*Blocked
+}
+
+func (b blockedBuilder) AddKey(key []byte) {
+	b.AddByte(key)
+}
+
+func (b blockedBuilder) Finish() KeyFilter {
+	return b.Blocked
+}
+
+type scalablePolicy struct {
+	p       int
+	options []Option
+}
+
+func (p scalablePolicy) Name() string {
+	return fmt.Sprintf("bloom.Scalable(1/%d)", p.p)
+}
+
+func (p scalablePolicy) NewBuilder(n int) FilterBuilder {
+	return scalableBuilder{NewScalable(n, p.p, p.options...)}
+}
+
+type scalableBuilder struct {
+	*Scalable
+}
+
+func (b scalableBuilder) AddKey(key []byte) {
+	b.AddByte(key)
+}
+
+func (b scalableBuilder) Finish() KeyFilter {
+	return b.Scalable
+}
+
+type xorPolicy struct {
+	wide    bool
+	options []Option
+}
+
+func (p xorPolicy) Name() string {
+	if p.wide {
+		return "bloom.Xor16"
+	}
+	return "bloom.Xor8"
+}
+
+func (p xorPolicy) NewBuilder(n int) FilterBuilder {
+	return &xorBuilder{wide: p.wide, hashes: make([]uint64, 0, n), hashing: newHashing(p.options)}
+}
+
+type xorBuilder struct {
+	wide   bool
+	hashes []uint64
+	hashing
+}
+
+func (b *xorBuilder) AddKey(key []byte) {
+	h, _ := b.hash(key)
+	b.hashes = append(b.hashes, h)
+}
+
+func (b *xorBuilder) Finish() KeyFilter {
+	if b.wide {
+		return buildXor[uint16](b.hashes, b.hashing)
+	}
+	return buildXor[uint8](b.hashes, b.hashing)
+}
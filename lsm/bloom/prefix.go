@@ -0,0 +1,100 @@
+package bloom
+
+import (
+	"bytes"
+	"slices"
+)
+
+// PrefixExtractor extracts the prefixes of keys, which are added to filters
+// along with the keys, so prefix scans can skip tables without the prefix.
+//
+// If a key starts with the bytes of a prefix returned by the extractor,
+// the extractor must return the same prefix for that key: a scan over the
+// keys starting with the prefix relies on it to test filters.
+type PrefixExtractor interface {
+	// Prefix returns the prefix of the key and false if the key has no prefix.
+	Prefix(key []byte) ([]byte, bool)
+}
+
+// PrefixFunc is a custom PrefixExtractor.
+type PrefixFunc func(key []byte) ([]byte, bool)
+
+// Prefix returns f(key).
+func (f PrefixFunc) Prefix(key []byte) ([]byte, bool) {
+	return f(key)
+}
+
+// FixedPrefix returns the extractor of the first n bytes of keys.
+// Keys shorter than n bytes have no prefix.
+func FixedPrefix(n int) PrefixExtractor {
+	return PrefixFunc(func(key []byte) ([]byte, bool) {
+		if len(key) < n {
+			return nil, false
+		}
+		return key[:n], true
+	})
+}
+
+// DelimitedPrefix returns the extractor of the bytes of keys up to and
+// including the first delimiter. Keys without the delimiter have no prefix.
+func DelimitedPrefix(delim byte) PrefixExtractor {
+	return PrefixFunc(func(key []byte) ([]byte, bool) {
+		i := bytes.IndexByte(key, delim)
+		if i < 0 {
+			return nil, false
+		}
+		return key[:i+1], true
+	})
+}
+
+// NewPrefixPolicy returns the policy that adds to the filters of the policy
+// the prefixes of keys along with the keys. Its filters are PrefixFilter.
+func NewPrefixPolicy(policy FilterPolicy, extractor PrefixExtractor) FilterPolicy {
+	return prefixPolicy{policy: policy, extractor: extractor}
+}
+
+// PrefixFilter is a filter of keys and their prefixes.
+type PrefixFilter struct {
+	KeyFilter
+}
+
+// TestPrefix tells if a key with the prefix may be a member of the set.
+// If false, there is definitely no such key.
+func (f *PrefixFilter) TestPrefix(prefix []byte) bool {
+	return f.TestByte(prefix)
+}
+
+type prefixPolicy struct {
+	policy    FilterPolicy
+	extractor PrefixExtractor
+}
+
+func (p prefixPolicy) Name() string {
+	return p.policy.Name() + "+prefix"
+}
+
+func (p prefixPolicy) NewBuilder(n int) FilterBuilder {
+	// в худшем случае у каждого ключа свой префикс
+	return &prefixBuilder{builder: p.policy.NewBuilder(2 * n), extractor: p.extractor}
+}
+
+type prefixBuilder struct {
+	builder   FilterBuilder
+	extractor PrefixExtractor
+	last      []byte
+}
+
+func (b *prefixBuilder) AddKey(key []byte) {
+	b.builder.AddKey(key)
+
+	// ключи таблицы упорядочены, поэтому одинаковые префиксы идут подряд
+	// и добавляются один раз
+	if prefix, ok := b.extractor.Prefix(key); ok && (b.last == nil || !bytes.Equal(prefix, b.last)) {
+		b.builder.AddKey(prefix)
+		b.last = slices.Clone(prefix)
+	}
+}
+
+func (b *prefixBuilder) Finish() KeyFilter {
+	return &PrefixFilter{KeyFilter: b.builder.Finish()}
+}
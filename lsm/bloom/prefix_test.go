@@ -0,0 +1,55 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPrefixExtractor(t *testing.T) {
+	tests := []struct {
+		extractor PrefixExtractor
+		key       string
+		prefix    string
+		ok        bool
+	}{
+		{FixedPrefix(3), "abcd", "abc", true},
+		{FixedPrefix(3), "abc", "abc", true},
+		{FixedPrefix(3), "ab", "", false},
+		{DelimitedPrefix(':'), "user:42:name", "user:", true},
+		{DelimitedPrefix(':'), "user", "", false},
+	}
+	for _, test := range tests {
+		prefix, ok := test.extractor.Prefix([]byte(test.key))
+		if ok != test.ok || string(prefix) != test.prefix {
+			t.Errorf("Prefix(%q) = %q, %t; want %q, %t", test.key, prefix, ok, test.prefix, test.ok)
+		}
+	}
+}
+
+func TestPrefixPolicy(t *testing.T) {
+	for _, policy := range []FilterPolicy{NewFilterPolicy(100), NewBlockedPolicy(100), NewXorPolicy(100)} {
+		b := NewPrefixPolicy(policy, DelimitedPrefix(':')).NewBuilder(300)
+		for i := 0; i < 100; i++ {
+			for j := 0; j < 3; j++ {
+				b.AddKey([]byte(fmt.Sprintf("user%03d:%d", i, j)))
+			}
+		}
+		f, ok := b.Finish().(*PrefixFilter)
+		if !ok {
+			t.Fatalf("%s: filter is not a prefix filter", policy.Name())
+		}
+
+		var fp int
+		for i := 0; i < 100; i++ {
+			if !f.TestPrefix([]byte(fmt.Sprintf("user%03d:", i))) || !f.TestByte([]byte(fmt.Sprintf("user%03d:0", i))) {
+				t.Fatalf("%s: user%03d is not a member", policy.Name(), i)
+			}
+			if f.TestPrefix([]byte(fmt.Sprintf("group%03d:", i))) {
+				fp++
+			}
+		}
+		if fp > 5 {
+			t.Errorf("%s: %d false positives of 100 prefixes", policy.Name(), fp)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package bloom
+
+import "math"
+
+const (
+	// scalableGrowth is the growth factor of the capacity of sub-filters.
+	scalableGrowth = 2
+	// scalableTightening is the ratio of the false-positives rates of
+	// successive sub-filters.
+	scalableTightening = 0.8
+)
+
+// Scalable represents a scalable Bloom filter, as shown by Almeida et al.
+//
+// A scalable filter does not need the number of elements in advance.
+// It starts with one Filter and adds a new sub-filter each time the last
+// one is full. Each sub-filter has twice the capacity of the previous one
+// and 0.8 of its false-positives rate, so the total false-positives rate
+// stays less than 1/p however many elements are added:
+//
+//	1/p · 0.2 · (1 + 0.8 + 0.8² + ...) < 1/p
+//
+// A filter grown from room for 100 elements to 100000 elements at p = 100
+// has 10 sub-filters and uses about 21 bits per element.
+//
+// A test checks all sub-filters, their number grows logarithmically
+// with the number of elements.
+type Scalable struct {
+	filters  []*Filter
+	capacity int // Capacity of the last sub-filter
+	p        int
+	hashing
+}
+
+// NewScalable creates an empty scalable Bloom filter with the initial room
+// for n elements at a false-positives rate less than 1/p.
+// The options set the hasher and its seed.
+func NewScalable(n int, p int, options ...Option) *Scalable {
+	f := &Scalable{capacity: max(n, 1) / scalableGrowth, p: p, hashing: newHashing(options)}
+	f.grow()
+	return f
+}
+
+// grow добавляет подфильтр вдвое большей емкости. Доля ложных срабатываний
+// i-го подфильтра (1-r)·r^i/p, в сумме они дают меньше 1/p.
+func (f *Scalable) grow() {
+	i := len(f.filters)
+	rate := (1 - scalableTightening) * math.Pow(scalableTightening, float64(i)) / float64(f.p)
+	f.capacity = max(f.capacity*scalableGrowth, 1)
+	sub := New(f.capacity, int(math.Ceil(1/rate)))
+	sub.hashing = f.hashing
+	f.filters = append(f.filters, sub)
+}
+
+// AddByte adds b to the filter and tells if b was already a likely member.
+func (f *Scalable) AddByte(b []byte) bool {
+	return f.add(f.hash(b))
+}
+
+// Add adds s to the filter and tells if s was already a likely member.
+func (f *Scalable) Add(s string) bool {
+	return f.add(f.hashString(s))
+}
+
+func (f *Scalable) add(h1, h2 uint64) bool {
+	if f.test(h1, h2) {
+		return true
+	}
+
+	last := f.filters[len(f.filters)-1]
+	if last.count >= int64(f.capacity) {
+		f.grow()
+		last = f.filters[len(f.filters)-1]
+	}
+	return last.add(h1, h2)
+}
+
+// TestByte tells if b is a likely member of the filter.
+// If true, b is probably a member; if false, b is definitely not a member.
+func (f *Scalable) TestByte(b []byte) bool {
+	return f.test(f.hash(b))
+}
+
+// Test tells if s is a likely member of the filter.
+// If true, s is probably a member; if false, s is definitely not a member.
+func (f *Scalable) Test(s string) bool {
+	return f.test(f.hashString(s))
+}
+
+func (f *Scalable) test(h1, h2 uint64) bool {
+	// последний подфильтр самый большой, в нем больше всего элементов
+	for i := len(f.filters) - 1; i >= 0; i-- {
+		if f.filters[i].test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns an estimate of the number of elements in the filter.
+func (f *Scalable) Count() int64 {
+	var count int64
+	for _, sub := range f.filters {
+		count += sub.count
+	}
+	return count
+}
+
+// Size returns the size of the bit arrays of the sub-filters in bytes.
+func (f *Scalable) Size() int {
+	var size int
+	for _, sub := range f.filters {
+		size += sub.Size()
+	}
+	return size
+}
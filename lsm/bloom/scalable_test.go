@@ -0,0 +1,50 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScalable(t *testing.T) {
+	const n, p = 100000, 100
+	f := NewScalable(100, p)
+	for i := 0; i < n; i++ {
+		if f.Add(fmt.Sprintf("key-%d", i)) && i == 0 {
+			t.Fatal("Add(key-0) = true for empty filter")
+		}
+	}
+	for i := 0; i < n; i++ {
+		if !f.TestByte([]byte(fmt.Sprintf("key-%d", i))) {
+			t.Fatalf("key-%d is not a member", i)
+		}
+	}
+	if len(f.filters) < 2 {
+		t.Fatalf("filter has %d sub-filters, want more than one", len(f.filters))
+	}
+
+	// фильтр, рассчитанный на 100 элементов, насыщается
+	fixed := New(100, p)
+	for i := 0; i < n; i++ {
+		fixed.Add(fmt.Sprintf("key-%d", i))
+	}
+
+	var fp, fixedFP int
+	for i := 0; i < n; i++ {
+		s := fmt.Sprintf("missing-%d", i)
+		if f.Test(s) {
+			fp++
+		}
+		if fixed.Test(s) {
+			fixedFP++
+		}
+	}
+	rate, fixedRate := float64(fp)/n, float64(fixedFP)/n
+	t.Logf("%d sub-filters, %d elements: false positives %.4f, %.1f bits per key; undersized Filter: %.4f",
+		len(f.filters), f.Count(), rate, float64(8*f.Size())/n, fixedRate)
+	if rate > 1.0/p {
+		t.Errorf("false positives %.4f, want less than 1/%d", rate, p)
+	}
+	if f.Count() < n*99/100 {
+		t.Errorf("Count() = %d; want about %d", f.Count(), n)
+	}
+}
@@ -0,0 +1,193 @@
+package bloom
+
+import (
+	"math/bits"
+	"slices"
+	"unsafe"
+)
+
+// Xor filters
+//
+// A xor filter is a static filter built once from a complete set of keys,
+// as shown by Graf and Lemire. Each key is mapped to three slots of an array
+// of fingerprints, and the array is filled so that the xor of the three
+// slots equals the fingerprint of the key.
+//
+// A xor filter with f-bit fingerprints has the false-positives rate 1/2^f
+// and uses 1.23f bits per key, which is less than a Bloom filter needs for
+// the same rate, even without rounding its size to a power of 2:
+//
+//	filter    rate        bits per key
+//	----------------------------------
+//	Xor8      1/256          9.8
+//	Xor16     1/65536       19.7
+//	Filter    1/256         11.5 .. 23
+//	Filter    1/65536       23.1 .. 46
+//
+// A test makes three array lookups. Keys cannot be added to a built filter.
+
+// fingerprint is the type of the fingerprints of a xor filter.
+type fingerprint interface {
+	~uint8 | ~uint16
+}
+
+// Xor represents a xor filter with fingerprints of type T.
+type Xor[T fingerprint] struct {
+	seed         uint64 // Seed of the construction, see buildXor
+	blockLength  uint32
+	fingerprints []T
+	hashing
+}
+
+// NewXor8 builds a xor filter of the keys with 8-bit fingerprints
+// and the false-positives rate 1/256. The options set the hasher and its seed.
+func NewXor8(keys [][]byte, options ...Option) *Xor[uint8] {
+	h := newHashing(options)
+	return buildXor[uint8](h.hashKeys(keys), h)
+}
+
+// NewXor16 builds a xor filter of the keys with 16-bit fingerprints
+// and the false-positives rate 1/65536. The options set the hasher and its seed.
+func NewXor16(keys [][]byte, options ...Option) *Xor[uint16] {
+	h := newHashing(options)
+	return buildXor[uint16](h.hashKeys(keys), h)
+}
+
+func (h hashing) hashKeys(keys [][]byte) []uint64 {
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i], _ = h.hash(key)
+	}
+
+	return hashes
+}
+
+// TestByte tells if b is a likely member of the filter.
+// If true, b is probably a member; if false, b is definitely not a member.
+func (f *Xor[T]) TestByte(b []byte) bool {
+	h1, _ := f.hash(b)
+	return f.test(h1)
+}
+
+// Test tells if s is a likely member of the filter.
+// If true, s is probably a member; if false, s is definitely not a member.
+func (f *Xor[T]) Test(s string) bool {
+	h1, _ := f.hashString(s)
+	return f.test(h1)
+}
+
+func (f *Xor[T]) test(key uint64) bool {
+	h := fmix(key + f.seed)
+	i0, i1, i2 := f.slots(h)
+
+	return T(h^h>>32) == f.fingerprints[i0]^f.fingerprints[i1]^f.fingerprints[i2]
+}
+
+// slots возвращает три ячейки ключа, по одной в каждой трети массива.
+func (f *Xor[T]) slots(h uint64) (uint32, uint32, uint32) {
+	return reduce(uint32(h), f.blockLength),
+		reduce(uint32(bits.RotateLeft64(h, 21)), f.blockLength) + f.blockLength,
+		reduce(uint32(bits.RotateLeft64(h, 42)), f.blockLength) + 2*f.blockLength
+}
+
+// Size returns the size of the fingerprints in bytes.
+func (f *Xor[T]) Size() int {
+	var fp T
+	return len(f.fingerprints) * int(unsafe.Sizeof(fp))
+}
+
+// buildXor строит фильтр по хешам ключей. Построение может не найти
+// порядок заполнения ячеек, тогда оно повторяется с другим зерном.
+// Совпадающие хеши не дают построению завершиться, поэтому они удаляются.
+func buildXor[T fingerprint](hashes []uint64, hs hashing) *Xor[T] {
+	slices.Sort(hashes)
+	hashes = slices.Compact(hashes)
+
+	size := 32 + 123*len(hashes)/100
+	f := &Xor[T]{blockLength: uint32(size / 3), hashing: hs}
+	capacity := 3 * int(f.blockLength)
+	f.fingerprints = make([]T, capacity)
+
+	type slot struct {
+		mask  uint64 // xor хешей ключей ячейки
+		count uint32
+	}
+	type entry struct {
+		hash  uint64
+		index uint32
+	}
+
+	var (
+		rng   = uint64(0x726b2b9d438b9d4d)
+		slots = make([]slot, capacity)
+		queue = make([]uint32, 0, capacity)
+		stack = make([]entry, 0, len(hashes))
+	)
+	for {
+		f.seed = splitmix64(&rng)
+		clear(slots)
+		for _, key := range hashes {
+			h := fmix(key + f.seed)
+			i0, i1, i2 := f.slots(h)
+			for _, i := range [3]uint32{i0, i1, i2} {
+				slots[i].mask ^= h
+				slots[i].count++
+			}
+		}
+
+		// ключи снимаются с ячеек, в которых они остались одни
+		queue, stack = queue[:0], stack[:0]
+		for i := range slots {
+			if slots[i].count == 1 {
+				queue = append(queue, uint32(i))
+			}
+		}
+		for len(queue) > 0 {
+			i := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			if slots[i].count != 1 {
+				continue
+			}
+
+			h := slots[i].mask
+			stack = append(stack, entry{hash: h, index: i})
+			i0, i1, i2 := f.slots(h)
+			for _, j := range [3]uint32{i0, i1, i2} {
+				slots[j].mask ^= h
+				slots[j].count--
+				if slots[j].count == 1 {
+					queue = append(queue, j)
+				}
+			}
+		}
+
+		if len(stack) == len(hashes) {
+			break
+		}
+	}
+
+	// ячейки заполняются в обратном порядке: ячейка ключа еще свободна,
+	// а две другие уже не изменятся
+	clear(f.fingerprints)
+	for i := len(stack) - 1; i >= 0; i-- {
+		e := stack[i]
+		i0, i1, i2 := f.slots(e.hash)
+		fp := T(e.hash ^ e.hash>>32)
+		f.fingerprints[e.index] = fp ^ f.fingerprints[i0] ^ f.fingerprints[i1] ^ f.fingerprints[i2]
+	}
+
+	return f
+}
+
+// reduce отображает x на [0, n) без деления.
+func reduce(x, n uint32) uint32 {
+	return uint32(uint64(x) * uint64(n) >> 32)
+}
+
+func splitmix64(seed *uint64) uint64 {
+	*seed += 0x9e3779b97f4a7c15
+	z := *seed
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
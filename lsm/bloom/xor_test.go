@@ -0,0 +1,58 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestXor(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 10, 1000, 100000} {
+		keys := make([][]byte, n)
+		for i := range keys {
+			keys[i] = []byte(fmt.Sprintf("key-%d", i))
+		}
+		// повторяющиеся ключи не мешают построению
+		keys = append(keys, keys...)
+
+		f8, f16 := NewXor8(keys), NewXor16(keys)
+		for _, key := range keys {
+			if !f8.TestByte(key) || !f16.TestByte(key) || !f16.Test(string(key)) {
+				t.Fatalf("%s is not a member of filter of %d keys", key, n)
+			}
+		}
+		if n > 0 && f16.Size() != 2*f8.Size() {
+			t.Fatalf("size of Xor16 %d != 2 * size of Xor8 %d", f16.Size(), f8.Size())
+		}
+	}
+}
+
+func TestXorSize(t *testing.T) {
+	const n = 100000
+	for _, p := range []int{256, 65536} {
+		xor, bloom := NewXorPolicy(p), NewFilterPolicy(p)
+		xorRate, xorBits := falsePositives(xor, n)
+		bloomRate, bloomBits := falsePositives(bloom, n)
+		t.Logf("%s: false positives %.5f, %.1f bits per key", xor.Name(), xorRate, xorBits)
+		t.Logf("%s: false positives %.5f, %.1f bits per key", bloom.Name(), bloomRate, bloomBits)
+
+		// на 100000 ключей при 1/65536 ожидается лишь 1.5 срабатывания
+		if xorRate > 4/float64(p) {
+			t.Errorf("%s: false positives %.5f, want less than 1/%d", xor.Name(), xorRate, p)
+		}
+		if xorBits > 0.7*bloomBits {
+			t.Errorf("%s: %.1f bits per key, want 30%% less than %.1f", xor.Name(), xorBits, bloomBits)
+		}
+	}
+}
+
+func BenchmarkBuildXor8(b *testing.B) {
+	keys := make([][]byte, 1<<20)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		NewXor8(keys)
+	}
+}
@@ -0,0 +1,120 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/wubba-com/lsm-distributed/lsm/encoder"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+	"github.com/wubba-com/lsm-distributed/lsm/wal"
+)
+
+// Checkpoint creates a consistent copy of the running db in the directory dir,
+// which must not exist. The copy can be opened with Open as an independent db
+// with the same column families.
+//
+// SST files are never changed after they are written, so they are hard linked
+// into the checkpoint, or copied if dir is on another file system. The MemTable
+// is written to the WAL of the checkpoint, and the sequence number of the db
+// is kept, so the checkpoint does not reuse the names of its tables.
+func (t *LSMTree) Checkpoint(dir string) (err error) {
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("checkpoint directory %s already exists", dir)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// блокировка на чтение останавливает записи, сброс MemTable, загрузку
+	// внешних таблиц и удаление таблиц уплотнением: все они идут под
+	// блокировкой дерева на запись
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.closed {
+		return ErrClosed
+	}
+
+	if err := os.MkdirAll(dir, os.FileMode(0700)); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	var ops []batchOp
+	for _, cf := range t.cfs {
+		if err := cf.checkpoint(dir); err != nil {
+			return fmt.Errorf("failed to checkpoint column family %s: %w", cf.name, err)
+		}
+		ops = append(ops, cf.memOps()...)
+	}
+
+	w, err := wal.NewWAL(dir)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := w.WriteSequence(t.wal.Sequence()); err != nil {
+		return fmt.Errorf("failed to write sequence of checkpoint: %w", err)
+	}
+	if len(ops) > 0 {
+		value, err := encodeBatch(ops)
+		if err != nil {
+			return err
+		}
+		if err := w.Append(nil, value); err != nil {
+			return fmt.Errorf("failed to write MemTable to checkpoint: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// checkpoint копирует SST-файлы и blob-файлы column family в каталог
+// контрольной точки. Функция ожидает, что она будет выполняться под блокировкой дерева.
+func (cf *ColumnFamily) checkpoint(dir string) error {
+	rel, err := filepath.Rel(cf.db.root, cf.root)
+	if err != nil {
+		return err
+	}
+	root := path.Join(dir, rel)
+	if err := os.MkdirAll(root, os.FileMode(0700)); err != nil {
+		return err
+	}
+
+	for _, level := range cf.levels {
+		for _, file := range level.Files {
+			if err := sst.Link(cf.root, root, file.Level, file.SeqNum); err != nil {
+				return fmt.Errorf("failed to link table %d of level %d: %w", file.SeqNum, file.Level, err)
+			}
+		}
+	}
+
+	if cf.blobs != nil {
+		return cf.blobs.Checkpoint(path.Join(root, blobDir))
+	}
+
+	return nil
+}
+
+// memOps возвращает содержимое MemTable как операции пакета. Ключи MemTable
+// новее ее надгробий диапазонов, поэтому надгробия идут первыми.
+func (cf *ColumnFamily) memOps() []batchOp {
+	var ops []batchOp
+	for _, rd := range cf.mem.RangeDels() {
+		ops = append(ops, batchOp{cf: cf, kind: encoder.OpKindDeleteRange, key: rd.Start, value: rd.End, encoded: cf.db.encoder.Encode(encoder.OpKindDeleteRange, rd.End)})
+	}
+
+	it := cf.mem.Iterator()
+	for it.HasNext() {
+		k, v := it.Next()
+		ops = append(ops, batchOp{cf: cf, kind: encoder.OpKind(v[0]), key: k, encoded: v})
+	}
+
+	return ops
+}
@@ -0,0 +1,202 @@
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	var options = func() []func(*LSMTree) {
+		return []func(*LSMTree){
+			MemTableThreshold(1 << 20),
+			BlobThreshold(64),
+			ColumnFamilyConfig("meta", Config{}),
+		}
+	}
+
+	l, err := Open(path.Join(dir, "db"), options()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	meta, _ := l.ColumnFamily("meta")
+
+	large := bytes.Repeat([]byte("l"), 128)
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key-%02d", i))
+		if err := l.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Put([]byte("large"), large); err != nil {
+		t.Fatal(err)
+	}
+	l.lock.Lock()
+	err = l.flushMemTable()
+	l.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// в MemTable остаются надгробие диапазона и более новые ключи
+	if err := l.DeleteRange([]byte("key-00"), []byte("key-10")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put([]byte("key-05"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := meta.Put([]byte("m"), []byte("m")); err != nil {
+		t.Fatal(err)
+	}
+
+	cp := path.Join(dir, "checkpoint")
+	if err := l.Checkpoint(cp); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Checkpoint(cp); err == nil {
+		t.Fatal("checkpoint into existing directory")
+	}
+
+	// изменения после контрольной точки не попадают в нее
+	if err := l.Put([]byte("key-15"), []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Open(cp, options()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	want := map[string][]byte{
+		"key-03": nil,
+		"key-05": []byte("new"),
+		"key-15": []byte("key-15"),
+		"large":  large,
+	}
+	for key, value := range want {
+		v, _, err := c.Get([]byte(key))
+		if value == nil {
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("%s: err %v != %v", key, err, ErrNotFound)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(v, value) {
+			t.Fatalf("%s = %s, want %s: %v", key, v, value, err)
+		}
+	}
+	cmeta, _ := c.ColumnFamily("meta")
+	if v, _, err := cmeta.Get([]byte("m")); err != nil || !bytes.Equal(v, []byte("m")) {
+		t.Fatalf("meta m = %s: %v", v, err)
+	}
+
+	// контрольная точка независима от исходной базы
+	if err := c.Put([]byte("key-16"), []byte("checkpoint")); err != nil {
+		t.Fatal(err)
+	}
+	c.lock.Lock()
+	err = c.flushMemTable()
+	c.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ := l.Get([]byte("key-16")); !bytes.Equal(v, []byte("key-16")) {
+		t.Fatalf("key-16 of db = %s", v)
+	}
+}
+
+func TestCheckpointDuringWrites(t *testing.T) {
+	const (
+		n     = 2000
+		every = 400
+	)
+	dir := t.TempDir()
+
+	l, err := Open(path.Join(dir, "db"), MemTableThreshold(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	l.SetMergeSettings(MergeSettings{NumberOfSstFiles: 1, MaxLevels: 3})
+
+	// записи сбрасывают MemTable, уплотнения удаляют таблицы,
+	// контрольные точки снимаются во время тех и других
+	var (
+		wg          sync.WaitGroup
+		done        = make(chan struct{})
+		checkpoints = make(chan string, n/every)
+	)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key-%04d", i))
+			if err := l.Put(key, key); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			if err := l.def.merge(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		defer close(checkpoints)
+		for i := 0; i < n/every; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			cp := path.Join(dir, fmt.Sprintf("checkpoint-%d", i))
+			if err := l.Checkpoint(cp); err != nil {
+				t.Error(err)
+				return
+			}
+			checkpoints <- cp
+		}
+	}()
+	wg.Wait()
+
+	// каждая контрольная точка содержит все ключи, записанные до последнего из них
+	for cp := range checkpoints {
+		c, err := Open(cp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		last := -1
+		for i := n - 1; i >= 0 && last < 0; i-- {
+			if _, ok, _ := c.Get([]byte(fmt.Sprintf("key-%04d", i))); ok {
+				last = i
+			}
+		}
+		for i := 0; i < last; i++ {
+			key := []byte(fmt.Sprintf("key-%04d", i))
+			if v, _, err := c.Get(key); err != nil || !bytes.Equal(v, key) {
+				t.Fatalf("%s has no key %s before key %d: %s %v", cp, key, last, v, err)
+			}
+		}
+		c.Shutdown()
+	}
+}
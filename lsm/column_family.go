@@ -0,0 +1,500 @@
+package lsm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/wubba-com/lsm-distributed/lsm/blob"
+	"github.com/wubba-com/lsm-distributed/lsm/bloom"
+	"github.com/wubba-com/lsm-distributed/lsm/encoder"
+	"github.com/wubba-com/lsm-distributed/lsm/memtable"
+	"github.com/wubba-com/lsm-distributed/lsm/ratelimit"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+// ColumnFamily это именованное пространство ключей внутри дерева
+// со своей MemTable, уровнями и настройками уплотнения. Все column
+// families пишут в общий WAL дерева.
+type ColumnFamily struct {
+	db   *LSMTree
+	name string
+	// Каталог с SST-файлами column family.
+	root string
+
+	levels []sst.SSTLevel
+	config *Config
+
+	// Все изменения, которые стираются в WAL, но не стираются
+	// в отсортированные файлы, хранятся в памяти для ускорения поиска.
+	mem *memtable.Memtable
+
+	// Blob-файлы для значений не короче config.BlobThreshold.
+	blobs *blob.Storage
+
+	// Если число DiskTable превышает порог, дисковые таблицы должны быть
+	// объединить, чтобы уменьшить его.
+	diskTableNumThreshold int
+
+	// Последнее вычисленное состояние записи, защищено db.stallMu.
+	writeState WriteState
+
+	// Уплотнение и загрузка внешних таблиц меняют уровни и не
+	// должны выполняться одновременно.
+	compactMu sync.Mutex
+	// Запросы уплотнения от контроллера записи, см. requestCompaction.
+	compactCh chan struct{}
+}
+
+func newColumnFamily(db *LSMTree, name, root string, config Config) *ColumnFamily {
+	if config.MemtblDataSize == 0 {
+		config.MemtblDataSize = defaultMemTableThreshold
+	}
+	if config.SparseKeyDistance == 0 {
+		config.SparseKeyDistance = defaultSparseKeyDistance
+	}
+	if config.BloomFalsePositive == 0 {
+		config.BloomFalsePositive = defaultBloomFalsePositive
+	}
+	if config.FilterPolicy == nil {
+		config.FilterPolicy = bloom.NewFilterPolicy(config.BloomFalsePositive)
+	}
+	if config.BlobGarbageRatio == 0 {
+		config.BlobGarbageRatio = defaultBlobGarbageRatio
+	}
+	if config.Stall.SlowdownDelay == 0 {
+		config.Stall.SlowdownDelay = defaultSlowdownDelay
+	}
+
+	return &ColumnFamily{
+		db:                    db,
+		name:                  name,
+		root:                  root,
+		levels:                []sst.SSTLevel{{}},
+		config:                &config,
+		mem:                   memtable.NewMem(),
+		diskTableNumThreshold: defaultDiskTableNumThreshold,
+		compactCh:             make(chan struct{}, 1),
+	}
+}
+
+// Name returns the name of the column family.
+func (cf *ColumnFamily) Name() string {
+	return cf.name
+}
+
+// Put puts the key into the column family.
+func (cf *ColumnFamily) Put(key []byte, value []byte) error {
+	return cf.PutContext(context.Background(), key, value)
+}
+
+// PutContext puts the key into the column family unless the context is done.
+func (cf *ColumnFamily) PutContext(ctx context.Context, key []byte, value []byte) error {
+	b := NewBatch()
+	b.Put(cf, key, value)
+
+	return cf.db.WriteContext(ctx, b)
+}
+
+// Get the value for the key from the column family.
+func (cf *ColumnFamily) Get(key []byte) ([]byte, bool, error) {
+	return cf.GetContext(context.Background(), key)
+}
+
+// GetContext gets the value for the key from the column family unless the context is done.
+func (cf *ColumnFamily) GetContext(ctx context.Context, key []byte) ([]byte, bool, error) {
+	value, ok, err := cf.value(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return nil, false, ErrNotFound
+	}
+
+	return value, true, nil
+}
+
+// value возвращает значение ключа, читая его из blob-файла при необходимости.
+// Если ключа нет или он удален, возвращается false без ошибки.
+func (cf *ColumnFamily) value(ctx context.Context, key []byte) ([]byte, bool, error) {
+	return cf.valueAt(ctx, key, math.MaxUint64)
+}
+
+// valueAt возвращает значение ключа после записи с номером seq. Значение,
+// замененное более поздней записью, берется из истории oracle.
+func (cf *ColumnFamily) valueAt(ctx context.Context, key []byte, seq uint64) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	cf.db.lock.RLock()
+	if cf.db.closed {
+		cf.db.lock.RUnlock()
+		return nil, false, ErrClosed
+	}
+	dec, err := cf.getAt(ctx, key, seq)
+	if err == nil && dec != nil && dec.IsBlobRef() {
+		// сборщик мусора не удалит blob-файл, пока значение читается
+		defer cf.blobs.Pin()()
+	}
+	cf.db.lock.RUnlock()
+	if err != nil || dec == nil {
+		return nil, false, err
+	}
+
+	value, err := cf.resolve(dec)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, value != nil, nil
+}
+
+// resolve возвращает значение ключа, читая его из blob-файла,
+// если в таблице хранится ссылка на него.
+func (cf *ColumnFamily) resolve(dec *encoder.EncodedValue) ([]byte, error) {
+	if !dec.IsBlobRef() {
+		return dec.Value(), nil
+	}
+
+	ref, err := blob.DecodeRef(dec.Value())
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := cf.blobs.Read(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	return value, nil
+}
+
+// get возвращает закодированное значение ключа или nil,
+// если ключа нет или он удален.
+func (cf *ColumnFamily) get(ctx context.Context, key []byte) (*encoder.EncodedValue, error) {
+	value, exists := cf.mem.Get(key)
+	if exists {
+		if cf.db.debug {
+			logger.Debug("found key memtable")
+		}
+		dec := cf.db.decoder.Decode(value)
+		if dec.IsTombstone() {
+			return nil, nil
+		}
+
+		return dec, nil
+	}
+
+	if cf.mem.Covers(key) {
+		return nil, nil
+	}
+
+	value, exists, err := sst.SearchInDiskTablesContext(ctx, key, cf.root, cf.levels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search in DiskTables: %w", err)
+	}
+
+	if exists {
+		dec := cf.db.decoder.Decode(value)
+
+		if dec.IsTombstone() {
+			return nil, nil
+		}
+
+		if cf.db.debug {
+			logger.Debug("found key disk")
+		}
+
+		return dec, nil
+	}
+
+	return nil, nil
+}
+
+// getAt ищет ключ после записи с номером seq. Вызывается под блокировкой дерева.
+func (cf *ColumnFamily) getAt(ctx context.Context, key []byte, seq uint64) (*encoder.EncodedValue, error) {
+	if image, ok := cf.db.oracle.image(cf, key, seq); ok {
+		if image == nil {
+			return nil, nil
+		}
+		return cf.db.decoder.Decode(image), nil
+	}
+
+	return cf.get(ctx, key)
+}
+
+// Delete delete the value by key from the column family.
+func (cf *ColumnFamily) Delete(key []byte) error {
+	return cf.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes the key from the column family unless the context is done.
+func (cf *ColumnFamily) DeleteContext(ctx context.Context, key []byte) error {
+	b := NewBatch()
+	b.Delete(cf, key)
+
+	return cf.db.WriteContext(ctx, b)
+}
+
+// DeleteRange удаляет из column family все ключи полуинтервала [start, end).
+func (cf *ColumnFamily) DeleteRange(start, end []byte) error {
+	b := NewBatch()
+	b.DeleteRange(cf, start, end)
+
+	return cf.db.Write(b)
+}
+
+// encodeValue кодирует значение операции для записи в WAL.
+// Значения не короче BlobThreshold пишутся в blob-файл,
+// и вместо них кодируется ссылка.
+func (cf *ColumnFamily) encodeValue(kind encoder.OpKind, key, value []byte) ([]byte, error) {
+	if kind != encoder.OpKindSet {
+		return cf.db.encoder.Encode(kind, value), nil
+	}
+
+	if cf.blobs != nil && cf.config.BlobThreshold > 0 && len(value) >= int(cf.config.BlobThreshold) {
+		ref, err := cf.blobs.Append(key, value)
+		if err != nil {
+			return nil, err
+		}
+
+		return cf.db.encoder.Encode(encoder.OpKindBlobRef, ref.Bytes()), nil
+	}
+
+	if uint64(len(value)) > MaxValueSize {
+		return nil, ErrValueTooLarge
+	}
+
+	return cf.db.encoder.Encode(kind, value), nil
+}
+
+// loadLevels загружает метаданные SST-файлов column family, оставшихся
+// с прошлых открытий. Bloom-фильтры не хранятся на диске, поэтому
+// загруженные таблицы проверяются без них.
+func (cf *ColumnFamily) loadLevels() error {
+	levels, err := cf.readLevels()
+	if err != nil {
+		return err
+	}
+	cf.levels = levels
+
+	return nil
+}
+
+// readLevels читает метаданные SST-файлов column family с диска.
+func (cf *ColumnFamily) readLevels() ([]sst.SSTLevel, error) {
+	levels := []sst.SSTLevel{{}}
+
+	lvls, err := sst.Levels(cf.root)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lvl := range lvls {
+		files, err := sst.Filename(cf.root, lvl)
+		if err != nil {
+			return nil, err
+		}
+
+		for len(levels) <= int(lvl) {
+			levels = append(levels, sst.SSTLevel{})
+		}
+		for _, file := range files {
+			meta, err := sst.LoadTable(cf.root, file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load table %d of level %d: %w", file.SeqNum, lvl, err)
+			}
+			if err := cf.openTable(&meta); err != nil {
+				return nil, fmt.Errorf("failed to open table %d of level %d: %w", file.SeqNum, lvl, err)
+			}
+			levels[lvl].Files = append(levels[lvl].Files, meta)
+		}
+	}
+
+	return levels, nil
+}
+
+// openBlobs открывает blob-файлы column family, если они включены
+// или остались с прошлых открытий.
+func (cf *ColumnFamily) openBlobs() error {
+	dir := path.Join(cf.root, blobDir)
+	if _, err := os.Stat(dir); os.IsNotExist(err) && cf.config.BlobThreshold == 0 {
+		return nil
+	}
+
+	var options []blob.Option
+	if cf.config.BlobFileSize > 0 {
+		options = append(options, blob.FileSize(cf.config.BlobFileSize))
+	}
+
+	blobs, err := blob.Open(dir, options...)
+	if err != nil {
+		return err
+	}
+	cf.blobs = blobs
+
+	return nil
+}
+
+// apply применяет закодированную операцию к MemTable.
+func (cf *ColumnFamily) apply(key, value []byte) {
+	if cf.db.decoder.Decode(value).IsRangeTombstone() {
+		cf.mem.DeleteRange(key, value[1:])
+		return
+	}
+
+	cf.mem.Put(key, value)
+}
+
+// flushMemTable сбрасывает текущую MemTable на диск и очищает ее.
+// Функция ожидает, что она будет выполняться под блокировкой дерева на запись.
+func (cf *ColumnFamily) flushMemTable() error {
+	if cf.mem.Len() == 0 && len(cf.mem.RangeDels()) == 0 {
+		return nil
+	}
+
+	seqNum, err := cf.db.wal.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	options := append([]sst.OptionWriter{sst.AtLevel(sst.BaseLevel), sst.SeqNum(seqNum), sst.SparseKeyDistance(cf.config.SparseKeyDistance)}, cf.writerOptions(ratelimit.PriorityHigh)...)
+	wr, err := sst.NewWriter(cf.root, options...)
+	if err != nil {
+		return err
+	}
+	// MemTable очищается после добавления таблицы на уровень 0,
+	// так что при ошибке сброса ключи остаются видимыми
+	mem := cf.mem
+
+	var filter bloom.FilterBuilder
+	if policy := cf.filterPolicy(false); policy != nil {
+		filter = policy.NewBuilder(mem.Len())
+	}
+	it := mem.Iterator()
+	for it.HasNext() {
+		k, v := it.Next()
+		if filter != nil {
+			filter.AddKey(k)
+		}
+		if err := wr.Write(k, v); err != nil {
+			return err
+		}
+	}
+	for _, rd := range mem.RangeDels() {
+		wr.AddRangeDel(rd.Start, rd.End)
+	}
+
+	if err := wr.AddIdxBlock(); err != nil {
+		return err
+	}
+	if err := wr.Close(); err != nil {
+		return err
+	}
+	var f bloom.KeyFilter
+	if filter != nil {
+		f = filter.Finish()
+	}
+	memMeta, err := sst.NewMemMetaSST(wr.NameSparseFile(), sst.BaseLevel, f)
+	if err != nil {
+		return err
+	}
+	if err := cf.openTable(&memMeta); err != nil {
+		return err
+	}
+
+	cf.levels[sst.BaseLevel].Files = append(cf.levels[sst.BaseLevel].Files, memMeta)
+	cf.mem.Switch()
+
+	return nil
+}
+
+// filterPolicy возвращает политику фильтров новых таблиц, bottommost
+// сообщает, что таблицы пишутся на нижний уровень. Секционированные
+// таблицы хранят фильтры в секциях, для них политика nil.
+func (cf *ColumnFamily) filterPolicy(bottommost bool) bloom.FilterPolicy {
+	if cf.config.IndexPartitionKeys > 0 {
+		return nil
+	}
+
+	policy := cf.config.FilterPolicy
+	if bottommost && cf.config.BottommostFilterPolicy != nil {
+		policy = cf.config.BottommostFilterPolicy
+	}
+	if cf.config.PrefixExtractor != nil {
+		policy = bloom.NewPrefixPolicy(policy, cf.config.PrefixExtractor)
+	}
+
+	return policy
+}
+
+func checkKeyValue(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	} else if len(value) == 0 {
+		return ErrValueRequired
+	}
+
+	return nil
+}
+
+func checkRange(start, end []byte) error {
+	if len(start) == 0 || len(end) == 0 {
+		return ErrKeyRequired
+	} else if len(start) > MaxKeySize || len(end) > MaxKeySize {
+		return ErrKeyTooLarge
+	} else if bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+
+	return nil
+}
+
+// writerOptions возвращает настройки записи новых таблиц с приоритетом pri.
+func (cf *ColumnFamily) writerOptions(pri ratelimit.Priority) []sst.OptionWriter {
+	options := []sst.OptionWriter{sst.RateLimit(cf.db.limiter, pri)}
+	if cf.config.IndexPartitionKeys > 0 {
+		options = append(options, sst.Partitioned(cf.config.IndexPartitionKeys, cf.config.BloomFalsePositive))
+	}
+
+	return options
+}
+
+// openTable загружает индекс верхнего уровня секционированной таблицы
+// и, если включен MmapReads, отображает файлы таблицы в память.
+func (cf *ColumnFamily) openTable(file *sst.SSTFile) error {
+	binpath := sst.PathBy(cf.root, file.Level, file.SeqNum)
+
+	p, err := sst.OpenPartitions(binpath, cf.db.blockCache)
+	if err != nil {
+		return err
+	}
+	file.Partitions = p
+
+	if cf.db.mmap {
+		if file.Reader, err = sst.New(binpath, sst.Mmap(sst.RandomAccess)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// closeTables закрывает читателей таблиц, убранных из уровней.
+// Вызывается под db.lock, чтобы поиск не читал закрытые отображения.
+func closeTables(levels []sst.SSTLevel) {
+	for _, level := range levels {
+		for _, file := range level.Files {
+			if file.Reader != nil {
+				file.Reader.Close()
+			}
+		}
+	}
+}
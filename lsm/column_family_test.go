@@ -0,0 +1,168 @@
+package lsm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+func TestColumnFamilies(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir, MemTableThreshold(1<<20), ColumnFamilyConfig("meta", Config{MemtblDataSize: 1 << 20}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, ok := l.ColumnFamily("meta")
+	if !ok {
+		t.Fatal("column family meta not found!")
+	}
+
+	if err := l.Put([]byte("a"), []byte("default")); err != nil {
+		t.Fatal(err)
+	}
+	if err := meta.Put([]byte("a"), []byte("meta")); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBatch()
+	b.Put(nil, []byte("b"), []byte("default"))
+	b.Put(meta, []byte("b"), []byte("meta"))
+	b.Delete(meta, []byte("a"))
+	if err := l.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	var check = func(stage string) {
+		if v, _, _ := l.Get([]byte("a")); !bytes.Equal(v, []byte("default")) {
+			t.Fatalf("%s: default a = %s", stage, v)
+		}
+		if v, _, _ := l.Get([]byte("b")); !bytes.Equal(v, []byte("default")) {
+			t.Fatalf("%s: default b = %s", stage, v)
+		}
+		if _, ok, _ := meta.Get([]byte("a")); ok {
+			t.Fatalf("%s: meta a found!", stage)
+		}
+		if v, _, _ := meta.Get([]byte("b")); !bytes.Equal(v, []byte("meta")) {
+			t.Fatalf("%s: meta b = %s", stage, v)
+		}
+	}
+	check("memtable")
+
+	l.Shutdown()
+	l.Close()
+
+	l, err = Open(dir, MemTableThreshold(1<<20), ColumnFamilyConfig("meta", Config{MemtblDataSize: 1 << 20}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	meta, _ = l.ColumnFamily("meta")
+	check("replay")
+
+	l.lock.Lock()
+	if err := l.flushMemTable(); err != nil {
+		t.Fatal(err)
+	}
+	l.lock.Unlock()
+	check("flush")
+
+	files, err := sst.Filename(path.Join(dir, cfDirPrefix+"meta"), sst.BaseLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("meta files %d != 1", len(files))
+	}
+	if stat, err := os.Stat(l.wal.Path()); err != nil || stat.Size() != 0 {
+		t.Fatalf("WAL is not cleared: %v", err)
+	}
+}
+
+func TestBatchInvalid(t *testing.T) {
+	l, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	b := NewBatch()
+	b.Put(nil, []byte("a"), []byte("a"))
+	b.Put(nil, nil, []byte("b"))
+	if err := l.Write(b); err != ErrKeyRequired {
+		t.Fatalf("err %v != %v", err, ErrKeyRequired)
+	}
+	if _, ok, _ := l.Get([]byte("a")); ok {
+		t.Fatal("key a of failed batch found!")
+	}
+}
+
+func TestColumnFamiliesConcurrentFlush(t *testing.T) {
+	const (
+		writers = 4
+		n       = 200
+	)
+	dir := t.TempDir()
+	options := []func(*LSMTree){MemTableThreshold(256), ColumnFamilyConfig("meta", Config{MemtblDataSize: 512})}
+
+	l, err := Open(dir, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := l.ColumnFamily("meta")
+
+	// сбросы MemTable идут во время записей и чтений: записи не теряются
+	// и остаются видимыми
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%03d", w, i))
+				b := NewBatch()
+				b.Put(nil, key, key)
+				b.Put(meta, key, key)
+				if err := l.Write(b); err != nil {
+					t.Error(err)
+					return
+				}
+				if v, _, err := meta.Get(key); err != nil || !bytes.Equal(v, key) {
+					t.Errorf("%s: get %s (%v)", key, v, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	l.Shutdown()
+	l.Close()
+
+	l, err = Open(dir, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	meta, _ = l.ColumnFamily("meta")
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key-%d-%03d", w, i))
+			if v, _, err := l.Get(key); err != nil || !bytes.Equal(v, key) {
+				t.Fatalf("default %s: get %s (%v)", key, v, err)
+			}
+			if v, _, err := meta.Get(key); err != nil || !bytes.Equal(v, key) {
+				t.Fatalf("meta %s: get %s (%v)", key, v, err)
+			}
+		}
+	}
+}
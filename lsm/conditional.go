@@ -0,0 +1,98 @@
+package lsm
+
+import (
+	"bytes"
+	"errors"
+)
+
+// CompareAndSwap puts the value into the key of the db if the current value is expected.
+// It returns true if the value is replaced.
+func (t *LSMTree) CompareAndSwap(key, expected, value []byte) (bool, error) {
+	return t.def.CompareAndSwap(key, expected, value)
+}
+
+// PutIfAbsent puts the value into the key of the db if the key does not exist.
+// It returns true if the value is put.
+func (t *LSMTree) PutIfAbsent(key, value []byte) (bool, error) {
+	return t.def.PutIfAbsent(key, value)
+}
+
+// DeleteIfEquals deletes the key from the db if the current value is expected.
+// It returns true if the key is deleted.
+func (t *LSMTree) DeleteIfEquals(key, expected []byte) (bool, error) {
+	return t.def.DeleteIfEquals(key, expected)
+}
+
+// CompareAndSwap puts the value into the key of the column family if the current value is expected.
+// It returns true if the value is replaced.
+func (cf *ColumnFamily) CompareAndSwap(key, expected, value []byte) (bool, error) {
+	if err := checkKeyValue(key, value); err != nil {
+		return false, err
+	}
+
+	return cf.conditional(key, func(current []byte, ok bool) bool {
+		return ok && bytes.Equal(current, expected)
+	}, func(txn *Txn) error {
+		return txn.Put(cf, key, value)
+	})
+}
+
+// PutIfAbsent puts the value into the key of the column family if the key does not exist.
+// It returns true if the value is put.
+func (cf *ColumnFamily) PutIfAbsent(key, value []byte) (bool, error) {
+	if err := checkKeyValue(key, value); err != nil {
+		return false, err
+	}
+
+	return cf.conditional(key, func(_ []byte, ok bool) bool {
+		return !ok
+	}, func(txn *Txn) error {
+		return txn.Put(cf, key, value)
+	})
+}
+
+// DeleteIfEquals deletes the key from the column family if the current value is expected.
+// It returns true if the key is deleted.
+func (cf *ColumnFamily) DeleteIfEquals(key, expected []byte) (bool, error) {
+	return cf.conditional(key, func(current []byte, ok bool) bool {
+		return ok && bytes.Equal(current, expected)
+	}, func(txn *Txn) error {
+		return txn.Delete(cf, key)
+	})
+}
+
+// conditional выполняет запись, если текущее значение ключа удовлетворяет условию.
+// Значение читается без блокировки в оптимистичной транзакции, а Commit
+// проверяет под блокировкой записи, что ключ не изменился с момента чтения,
+// поэтому условная запись линеаризуема относительно остальных записей.
+// При конфликте попытка повторяется.
+func (cf *ColumnFamily) conditional(key []byte, cond func(current []byte, ok bool) bool, write func(txn *Txn) error) (bool, error) {
+	for {
+		txn := cf.db.BeginTxn()
+
+		current, ok, err := txn.get(cf, key)
+		if err != nil {
+			txn.Discard()
+			return false, err
+		}
+		if !cond(current, ok) {
+			txn.Discard()
+			return false, nil
+		}
+
+		if err := write(txn); err != nil {
+			txn.Discard()
+			return false, err
+		}
+
+		err = txn.Commit()
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+}
@@ -0,0 +1,94 @@
+package lsm
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	if ok, err := l.PutIfAbsent([]byte("a"), []byte("1")); err != nil || !ok {
+		t.Fatalf("put if absent: %t, %v", ok, err)
+	}
+	if ok, err := l.PutIfAbsent([]byte("a"), []byte("2")); err != nil || ok {
+		t.Fatalf("put if absent existing: %t, %v", ok, err)
+	}
+
+	if ok, err := l.CompareAndSwap([]byte("a"), []byte("2"), []byte("3")); err != nil || ok {
+		t.Fatalf("cas with wrong value: %t, %v", ok, err)
+	}
+	if ok, err := l.CompareAndSwap([]byte("a"), []byte("1"), []byte("3")); err != nil || !ok {
+		t.Fatalf("cas: %t, %v", ok, err)
+	}
+	if ok, err := l.CompareAndSwap([]byte("b"), nil, []byte("3")); err != nil || ok {
+		t.Fatalf("cas of absent key: %t, %v", ok, err)
+	}
+	if v, _, _ := l.Get([]byte("a")); !bytes.Equal(v, []byte("3")) {
+		t.Fatalf("a = %s", v)
+	}
+
+	if ok, err := l.DeleteIfEquals([]byte("a"), []byte("1")); err != nil || ok {
+		t.Fatalf("delete with wrong value: %t, %v", ok, err)
+	}
+	if ok, err := l.DeleteIfEquals([]byte("a"), []byte("3")); err != nil || !ok {
+		t.Fatalf("delete: %t, %v", ok, err)
+	}
+	if _, ok, _ := l.Get([]byte("a")); ok {
+		t.Fatal("a found after delete!")
+	}
+	if ok, err := l.PutIfAbsent([]byte("a"), []byte("4")); err != nil || !ok {
+		t.Fatalf("put if absent deleted: %t, %v", ok, err)
+	}
+}
+
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	if err := l.Put([]byte("counter"), []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		workers    = 4
+		increments = 25
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; {
+				v, _, err := l.Get([]byte("counter"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				n, _ := strconv.Atoi(string(v))
+				ok, err := l.CompareAndSwap([]byte("counter"), v, []byte(strconv.Itoa(n+1)))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if ok {
+					j++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _, _ := l.Get([]byte("counter")); string(v) != strconv.Itoa(workers*increments) {
+		t.Fatalf("counter %s != %d", v, workers*increments)
+	}
+}
@@ -0,0 +1,77 @@
+package lsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestContextCanceled(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	if err := l.Put([]byte("a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := l.PutContext(ctx, []byte("b"), []byte("b")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("put err %v != %v", err, context.Canceled)
+	}
+	if _, _, err := l.Get([]byte("b")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("b of canceled put: %v", err)
+	}
+	if _, _, err := l.GetContext(ctx, []byte("a")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("get err %v != %v", err, context.Canceled)
+	}
+	if err := l.DeleteContext(ctx, []byte("a")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("delete err %v != %v", err, context.Canceled)
+	}
+
+	it, err := l.NewIteratorContext(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.HasNext() {
+		t.Fatal("iterator is empty!")
+	}
+	if _, err := l.NewIteratorContext(ctx, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("iterator err %v != %v", err, context.Canceled)
+	}
+}
+
+func TestWriteContextDeadline(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	// the WAL job exited, the write must not block forever
+	l.cancel()
+	l.wg.Wait()
+	if err := l.Put([]byte("a"), []byte("a")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("err %v != %v", err, ErrClosed)
+	}
+
+	// the tree is running, but nobody receives from the WAL channel
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	b := NewBatch()
+	b.Put(nil, []byte("a"), []byte("a"))
+	if err := l.WriteContext(ctx, b); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err %v != %v", err, context.DeadlineExceeded)
+	}
+	if _, _, err := l.Get([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a of timed out write: %v", err)
+	}
+}
@@ -5,6 +5,12 @@ type OpKind uint8
 const (
 	OpKindDelete OpKind = iota
 	OpKindSet
+	// OpKindDeleteRange is a range tombstone: the key is the start
+	// of the range and the value is the exclusive end.
+	OpKindDeleteRange
+	// OpKindBlobRef is a value kept in a blob file:
+	// the value is the encoded reference to it.
+	OpKindBlobRef
 )
 
 type Encoder struct{}
@@ -49,3 +55,11 @@ func (ev *EncodedValue) Value() []byte {
 func (ev *EncodedValue) IsTombstone() bool {
 	return ev.opKind == OpKindDelete
 }
+
+func (ev *EncodedValue) IsBlobRef() bool {
+	return ev.opKind == OpKindBlobRef
+}
+
+func (ev *EncodedValue) IsRangeTombstone() bool {
+	return ev.opKind == OpKindDeleteRange
+}
@@ -0,0 +1,101 @@
+package lsm
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+func TestErrNotFound(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	if _, _, err := l.Get([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err %v != %v", err, ErrNotFound)
+	}
+
+	if err := l.Put([]byte("a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.Get([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err of deleted key %v != %v", err, ErrNotFound)
+	}
+}
+
+func TestErrClosed(t *testing.T) {
+	l, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Put([]byte("a"), []byte("a")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("put err %v != %v", err, ErrClosed)
+	}
+	if _, _, err := l.Get([]byte("a")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("get err %v != %v", err, ErrClosed)
+	}
+	if err := l.Shutdown(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("shutdown err %v != %v", err, ErrClosed)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestErrCorruption(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir, MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Put([]byte("a"), []byte("value of a")); err != nil {
+		t.Fatal(err)
+	}
+	l.lock.Lock()
+	if err := l.flushMemTable(); err != nil {
+		t.Fatal(err)
+	}
+	l.lock.Unlock()
+
+	// the record of the only key is truncated
+	file := l.def.levels[sst.BaseLevel].Files[0]
+	bin := sst.PathBy(dir, file.Level, file.SeqNum)
+	if err := os.Truncate(bin, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = l.Get([]byte("a"))
+	var ce *CorruptionError
+	if !errors.Is(err, ErrCorruption) || !errors.As(err, &ce) {
+		t.Fatalf("err %v is not a corruption", err)
+	}
+	if ce.File != bin || ce.Offset != 0 {
+		t.Fatalf("corruption of %s at %d, expected %s at 0", ce.File, ce.Offset, bin)
+	}
+
+	walPath := l.wal.Name()
+	l.Shutdown()
+	l.Close()
+
+	// the last record of the WAL is truncated
+	if err := os.WriteFile(walPath, []byte{0, 0, 0, 0, 0, 0, 0, 100, 1}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(dir)
+	if !errors.As(err, &ce) || ce.File != walPath {
+		t.Fatalf("err %v is not a corruption of WAL", err)
+	}
+}
@@ -0,0 +1,232 @@
+package lsm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/wubba-com/lsm-distributed/lsm/bloom"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+func TestFilterPolicy(t *testing.T) {
+	l, err := Open(t.TempDir(), FilterPolicy(bloom.NewBlockedPolicy(100)),
+		ColumnFamilyConfig("cf", Config{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	cf, ok := l.ColumnFamily("cf")
+	if !ok {
+		t.Fatal("column family cf not found")
+	}
+
+	var keys [][]byte
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		keys = append(keys, key)
+		if err := l.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := cf.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l.lock.Lock()
+	err = l.flushMemTable()
+	l.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := l.def.levels[0].Files[0].Filter.(*bloom.Blocked); !ok {
+		t.Fatalf("filter of default column family %T, want %T", l.def.levels[0].Files[0].Filter, &bloom.Blocked{})
+	}
+	if _, ok := cf.levels[0].Files[0].Filter.(*bloom.Filter); !ok {
+		t.Fatalf("filter of column family %T, want %T", cf.levels[0].Files[0].Filter, &bloom.Filter{})
+	}
+
+	values, errs := l.MultiGet(append(keys, []byte("missing")))
+	for i, key := range keys {
+		if errs[i] != nil || !bytes.Equal(values[i], key) {
+			t.Fatalf("%s: multi get %s (%v)", key, values[i], errs[i])
+		}
+	}
+	if errs[len(keys)] == nil {
+		t.Fatalf("missing key found: %s", values[len(keys)])
+	}
+}
+
+func TestBottommostFilterPolicy(t *testing.T) {
+	l, err := Open(t.TempDir(), FilterPolicy(bloom.NewBlockedPolicy(100)), BottommostFilterPolicy(bloom.NewXorPolicy(100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("key%03d", i))
+			if err := l.Put(key, []byte(fmt.Sprintf("%s-%d", key, round))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		l.lock.Lock()
+		err = l.flushMemTable()
+		l.lock.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := l.def.levels[0].Files[0].Filter.(*bloom.Blocked); !ok {
+		t.Fatalf("filter of level 0 %T, want %T", l.def.levels[0].Files[0].Filter, &bloom.Blocked{})
+	}
+
+	if err := l.def.compact(sst.BaseLevel); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range l.def.levels[1].Files {
+		if _, ok := file.Filter.(*bloom.Xor[uint8]); !ok {
+			t.Fatalf("filter of bottommost level %T, want %T", file.Filter, &bloom.Xor[uint8]{})
+		}
+	}
+
+	keys := [][]byte{[]byte("key000"), []byte("key099"), []byte("missing")}
+	values, errs := l.MultiGet(keys)
+	for i, want := range []string{"key000-1", "key099-1"} {
+		if errs[i] != nil || string(values[i]) != want {
+			t.Fatalf("%s: multi get %s (%v), want %s", keys[i], values[i], errs[i], want)
+		}
+	}
+	if errs[2] == nil {
+		t.Fatalf("missing key found: %s", values[2])
+	}
+}
+
+func TestPrefixIterator(t *testing.T) {
+	l, err := Open(t.TempDir(), PrefixExtractor(bloom.DelimitedPrefix(':')))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	for _, user := range []string{"user1:", "user2:"} {
+		for i := 0; i < 10; i++ {
+			if err := l.Put([]byte(fmt.Sprintf("%s%d", user, i)), []byte(user)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		l.lock.Lock()
+		err = l.flushMemTable()
+		l.lock.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Put([]byte("user1:mem"), []byte("user1:")); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := l.NewPrefixIterator([]byte("user1:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for it.HasNext() {
+		k, v, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(k, []byte("user1:")) || string(v) != "user1:" {
+			t.Fatalf("key %s = %s, want prefix user1:", k, v)
+		}
+		n++
+	}
+	if n != 11 {
+		t.Fatalf("prefix iterator returned %d keys, want 11", n)
+	}
+
+	// без данных таблицы user2 итератор по user1 создается,
+	// только если таблица пропускается по фильтру
+	user2 := l.def.levels[0].Files[1]
+	if err := os.Remove(sst.PathBy(l.def.root, user2.Level, user2.SeqNum)); err != nil {
+		t.Fatal(err)
+	}
+	it, err = l.NewPrefixIterator([]byte("user1:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n = 0; it.HasNext(); n++ {
+		if _, _, err := it.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n != 11 {
+		t.Fatalf("prefix iterator returned %d keys, want 11", n)
+	}
+	if _, err := l.NewPrefixIterator([]byte("user2:")); err == nil {
+		t.Fatal("table with prefix user2: was not read")
+	}
+	if _, err := l.NewIterator([]byte("user1:"), []byte("user3:")); err == nil {
+		t.Fatal("table was skipped for range of different prefixes")
+	}
+}
+
+func TestIndexPartitions(t *testing.T) {
+	dir := t.TempDir()
+	cache := sst.NewBlockCache(1 << 20)
+	l, err := Open(dir, SparseKeyDistance(4), IndexPartitionKeys(16), BlockCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("key%03d", i))
+			if err := l.Put(key, []byte(fmt.Sprintf("%s-%d", key, round))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		l.lock.Lock()
+		err = l.flushMemTable()
+		l.lock.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.def.compact(sst.BaseLevel); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range l.def.levels[1].Files {
+		if file.Filter != nil || file.Partitions == nil || file.Partitions.Len() < 2 {
+			t.Fatalf("partitioned table has filter %T and partitions %v", file.Filter, file.Partitions)
+		}
+	}
+	if err := l.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// таблицы остаются секционированными после открытия
+	l, err = Open(dir, BlockCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		value, ok, err := l.Get(key)
+		if err != nil || !ok || string(value) != fmt.Sprintf("%s-1", key) {
+			t.Fatalf("%s: get %s %t (%v)", key, value, ok, err)
+		}
+	}
+	if _, ok, _ := l.Get([]byte("missing")); ok {
+		t.Fatal("missing key found")
+	}
+	if cache.Len() == 0 {
+		t.Fatal("partitions are not cached")
+	}
+}
@@ -0,0 +1,224 @@
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/wubba-com/lsm-distributed/lsm/encoder"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+var (
+	// ErrKeyOrder is returned when a key added to SSTFileWriter is not greater than the previous one.
+	ErrKeyOrder = errors.New("keys are not in increasing order")
+	// ErrOverlap is returned when the key ranges of ingested files overlap.
+	ErrOverlap = errors.New("key ranges of external files overlap")
+)
+
+// SSTFileWriter builds an external table offline in the format of the tree,
+// so it can be loaded with IngestExternalFiles without rewriting the data.
+// Keys must be added in increasing order.
+type SSTFileWriter struct {
+	w       *sst.Writer
+	path    string
+	last    []byte
+	encoder *encoder.Encoder
+}
+
+// NewSSTFileWriter creates the external table with the data file path,
+// which must have the extension sst.ExtBin. The index and sparse index
+// files are created next to it.
+func NewSSTFileWriter(path string) (*SSTFileWriter, error) {
+	if filepath.Ext(path) != sst.ExtBin {
+		return nil, fmt.Errorf("external table %s must have extension %s", path, sst.ExtBin)
+	}
+
+	w, err := sst.NewWriter(filepath.Dir(path), sst.FileName(filepath.Base(path)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSTFileWriter{w: w, path: path, encoder: encoder.NewEncoder()}, nil
+}
+
+// Put adds the key with the value to the table.
+func (w *SSTFileWriter) Put(key, value []byte) error {
+	if err := checkKeyValue(key, value); err != nil {
+		return err
+	}
+
+	return w.add(key, w.encoder.Encode(encoder.OpKindSet, value))
+}
+
+// Delete adds the tombstone of the key to the table, so the key is
+// deleted from the tree when the table is ingested.
+func (w *SSTFileWriter) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+	if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+
+	return w.add(key, w.encoder.Encode(encoder.OpKindDelete, nil))
+}
+
+func (w *SSTFileWriter) add(key, value []byte) error {
+	if w.last != nil && bytes.Compare(key, w.last) <= 0 {
+		return fmt.Errorf("%w: %q after %q", ErrKeyOrder, key, w.last)
+	}
+	w.last = slices.Clone(key)
+
+	return w.w.Write(key, value)
+}
+
+// Finish writes the index of the table and closes its files.
+func (w *SSTFileWriter) Finish() error {
+	if err := w.w.AddIdxBlock(); err != nil {
+		return err
+	}
+	if err := w.w.Close(); err != nil {
+		return err
+	}
+	if w.last == nil {
+		return fmt.Errorf("external table %s is empty", w.path)
+	}
+
+	return nil
+}
+
+// IngestExternalFiles loads the external tables built by SSTFileWriter into the db.
+func (t *LSMTree) IngestExternalFiles(paths []string) error {
+	return t.def.IngestExternalFiles(paths)
+}
+
+// IngestExternalFiles loads the external tables built by SSTFileWriter into
+// the column family. The tables are given by the paths of their data files,
+// their key ranges must not overlap. The keys of the tables are newer than
+// all keys of the column family: each table gets the next sequence number
+// and is placed at the lowest level, where neither it nor the levels above
+// overlap it. The MemTable is flushed first, if it overlaps a table.
+// The files are hard linked when possible, so the data is not rewritten.
+func (cf *ColumnFamily) IngestExternalFiles(paths []string) error {
+	files := make([]sst.SSTFile, len(paths))
+	for i, p := range paths {
+		file, err := sst.ReadExternal(p)
+		if err != nil {
+			return fmt.Errorf("failed to read external table %s: %w", p, err)
+		}
+		if len(file.Smallest) == 0 {
+			return fmt.Errorf("external table %s is empty", p)
+		}
+		files[i] = file
+	}
+
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return bytes.Compare(files[a].Smallest, files[b].Smallest)
+	})
+	for i := 1; i < len(order); i++ {
+		prev, cur := files[order[i-1]], files[order[i]]
+		if bytes.Compare(cur.Smallest, prev.Largest) <= 0 {
+			return fmt.Errorf("%w: %s and %s", ErrOverlap, paths[order[i-1]], paths[order[i]])
+		}
+	}
+
+	if err := cf.ingest(paths, files); err != nil {
+		return err
+	}
+	cf.db.notifyWriters()
+
+	return nil
+}
+
+// ingest размещает проверенные внешние таблицы на уровнях column family.
+func (cf *ColumnFamily) ingest(paths []string, files []sst.SSTFile) error {
+	cf.compactMu.Lock()
+	defer cf.compactMu.Unlock()
+
+	t := cf.db
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.closed {
+		return ErrClosed
+	}
+	if t.readOnly {
+		return ErrReadOnly
+	}
+
+	// ключи MemTable читаются раньше таблиц, поэтому пересекающая
+	// их MemTable сбрасывается, чтобы загруженные ключи стали новее
+	for _, file := range files {
+		if cf.memOverlaps(file.Smallest, file.Largest) {
+			if err := t.flushMemTable(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	for i, file := range files {
+		seqNum, err := t.wal.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		level := cf.ingestLevel(file)
+		meta, err := sst.Ingest(paths[i], cf.root, level, seqNum)
+		if err != nil {
+			return fmt.Errorf("failed to ingest external table %s: %w", paths[i], err)
+		}
+		if err := cf.openTable(&meta); err != nil {
+			return err
+		}
+		cf.levels[level].Files = append(cf.levels[level].Files, meta)
+	}
+
+	return nil
+}
+
+// ingestLevel возвращает самый нижний уровень, на котором таблица
+// не пересекается ни с таблицами этого уровня, ни с таблицами уровней выше.
+func (cf *ColumnFamily) ingestLevel(file sst.SSTFile) sst.Level {
+	var level sst.Level
+	for lvl, l := range cf.levels {
+		if slices.ContainsFunc(l.Files, func(f sst.SSTFile) bool {
+			return f.Overlaps(file.Smallest, file.Largest)
+		}) {
+			break
+		}
+		level = sst.Level(lvl)
+	}
+
+	return level
+}
+
+// memOverlaps сообщает, есть ли в MemTable ключи или надгробия диапазонов
+// из отрезка [smallest, largest].
+func (cf *ColumnFamily) memOverlaps(smallest, largest []byte) bool {
+	for _, rd := range cf.mem.RangeDels() {
+		if bytes.Compare(rd.Start, largest) <= 0 && bytes.Compare(smallest, rd.End) < 0 {
+			return true
+		}
+	}
+
+	it := cf.mem.Iterator()
+	for it.HasNext() {
+		k, _ := it.Next()
+		if bytes.Compare(k, largest) > 0 {
+			break
+		}
+		if bytes.Compare(k, smallest) >= 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,125 @@
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+func TestIngestExternalFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(path.Join(dir, "db"), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	l.SetMergeSettings(MergeSettings{MaxLevels: 3})
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if err := l.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.lock.Lock()
+	err = l.flushMemTable()
+	l.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.def.compact(sst.BaseLevel); err != nil {
+		t.Fatal(err)
+	}
+	// более новое значение в MemTable перекрывается загруженной таблицей
+	if err := l.Put([]byte("c"), []byte("memtable")); err != nil {
+		t.Fatal(err)
+	}
+
+	var build = func(name string, keys ...string) string {
+		p := path.Join(dir, name+sst.ExtBin)
+		w, err := NewSSTFileWriter(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			if key == "d" {
+				err = w.Delete([]byte(key))
+			} else {
+				err = w.Put([]byte(key), []byte("ingested-"+key))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Finish(); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	w, err := NewSSTFileWriter(path.Join(dir, "unsorted"+sst.ExtBin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Put([]byte("b"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Put([]byte("a"), []byte("a")); !errors.Is(err, ErrKeyOrder) {
+		t.Fatalf("unsorted put err %v != %v", err, ErrKeyOrder)
+	}
+	w.Finish()
+
+	upd := build("update", "c", "d")
+	bulk := build("bulk", "x1", "x2", "x3")
+	if err := l.IngestExternalFiles([]string{upd, build("overlap", "c1", "c2")}); !errors.Is(err, ErrOverlap) {
+		t.Fatalf("overlapping files err %v != %v", err, ErrOverlap)
+	}
+
+	if err := l.IngestExternalFiles([]string{upd, bulk}); err != nil {
+		t.Fatal(err)
+	}
+
+	l.lock.RLock()
+	levels := make([]int, len(l.def.levels))
+	for lvl, level := range l.def.levels {
+		levels[lvl] = len(level.Files)
+	}
+	l.lock.RUnlock()
+	// MemTable сброшена на уровень 0, update пересекает уровень 1 и ложится
+	// на уровень 0, bulk не пересекает ничего и ложится на уровень 1
+	if fmt.Sprint(levels) != "[2 2]" {
+		t.Fatalf("files by levels %v != [2 2]", levels)
+	}
+
+	var check = func(stage string) {
+		want := map[string]string{
+			"a":  "a",
+			"c":  "ingested-c",
+			"d":  "",
+			"x2": "ingested-x2",
+		}
+		for key, value := range want {
+			v, _, err := l.Get([]byte(key))
+			if value == "" {
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("%s: %s err %v != %v", stage, key, err, ErrNotFound)
+				}
+				continue
+			}
+			if err != nil || !bytes.Equal(v, []byte(value)) {
+				t.Fatalf("%s: %s = %s, want %s: %v", stage, key, v, value, err)
+			}
+		}
+	}
+	check("ingest")
+
+	// уплотнение упорядочивает ключи по номеру последовательности загруженной таблицы
+	if err := l.def.compact(sst.BaseLevel); err != nil {
+		t.Fatal(err)
+	}
+	check("compaction")
+}
@@ -0,0 +1,445 @@
+package lsm
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"fmt"
+	"runtime"
+	"slices"
+
+	"github.com/wubba-com/lsm-distributed/lsm/bloom"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+// Iterator перебирает ключи полуинтервала [start, end) в порядке возрастания.
+//
+// Итератор сливает записи MemTable и таблиц, открытых при его создании,
+// поэтому он видит дерево на момент создания: последующие записи, сброс
+// MemTable и уплотнение его не меняют. Ключи не собираются в память, каждая
+// таблица читается последовательно с первого ключа не меньше start.
+//
+// Источники упорядочены по рангу: чем меньше ранг, тем новее записи.
+// Из версий ключа берется версия источника с наименьшим рангом, а надгробие
+// диапазона удаляет ключи только источников с большим рангом, как и при
+// поиске ключа: значение в таблице новее ее надгробий диапазонов, а значения
+// уровня новее надгробий диапазонов уровня.
+type Iterator struct {
+	ctx context.Context
+	cf  *ColumnFamily
+
+	sources   mergeHeap
+	rangeDels []rankedRangeDel
+	readers   []*sst.Reader
+	// Открепляет blob-файлы, на которые ссылаются записи итератора.
+	unpin func()
+	// Возвращать закодированные значения, не читая blob-файлы.
+	encoded bool
+
+	key   []byte
+	value []byte
+	err   error
+}
+
+// mergeSource это источник записей итератора: MemTable, записи транзакции
+// или таблица. next возвращает nil, когда записи кончились.
+type mergeSource struct {
+	rank  int
+	key   []byte
+	value []byte
+	next  func() ([]byte, []byte, error)
+}
+
+// rankedRangeDel это надгробие диапазона с рангом его источника.
+type rankedRangeDel struct {
+	sst.RangeTombstone
+	rank int
+}
+
+// mergeHeap упорядочивает источники по текущему ключу, а источники
+// с одинаковым ключом - по рангу.
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if cmp := bytes.Compare(h[i].key, h[j].key); cmp != 0 {
+		return cmp < 0
+	}
+	return h[i].rank < h[j].rank
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x interface{}) {
+	*h = append(*h, x.(*mergeSource))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+// newIterator открывает источники column family для [start, end).
+// overlay возвращает под блокировкой дерева записи транзакции,
+// упорядоченные по ключу, которые новее записей дерева.
+func (cf *ColumnFamily) newIterator(ctx context.Context, start, end []byte, overlay func() []sst.ElemSST) (*Iterator, error) {
+	cf.db.lock.RLock()
+	defer cf.db.lock.RUnlock()
+
+	if cf.db.closed {
+		return nil, ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var elems []sst.ElemSST
+	if overlay != nil {
+		elems = overlay()
+	}
+	it := &Iterator{ctx: ctx, cf: cf}
+	if err := it.open(start, end, elems); err != nil {
+		return nil, err
+	}
+
+	return it, nil
+}
+
+// open открывает источники итератора. Вызывается под блокировкой дерева.
+func (it *Iterator) open(start, end []byte, overlay []sst.ElemSST) error {
+	cf := it.cf
+	if cf.blobs != nil {
+		it.unpin = cf.blobs.Pin()
+	}
+	var rank int
+	var add = func(next func() ([]byte, []byte, error)) error {
+		key, value, err := next()
+		if err != nil {
+			return err
+		}
+		if key != nil {
+			heap.Push(&it.sources, &mergeSource{rank: rank, key: key, value: value, next: next})
+		}
+		return nil
+	}
+	var addRangeDels = func(rds []sst.RangeTombstone) {
+		for _, rd := range rds {
+			if (end == nil || bytes.Compare(rd.Start, end) < 0) && (start == nil || bytes.Compare(start, rd.End) < 0) {
+				it.rangeDels = append(it.rangeDels, rankedRangeDel{RangeTombstone: rd, rank: rank})
+			}
+		}
+	}
+
+	if len(overlay) > 0 {
+		add(entries(overlay))
+		rank++
+	}
+
+	// MemTable изменяется записями, поэтому ее записи из диапазона копируются
+	var mem []sst.ElemSST
+	mit := cf.mem.Iterator()
+	for mit.HasNext() {
+		k, v := mit.Next()
+		if end != nil && bytes.Compare(k, end) >= 0 {
+			break
+		}
+		if inRange(k, start, end) {
+			mem = append(mem, sst.ElemSST{Key: k, Val: v})
+		}
+	}
+	add(entries(mem))
+	addRangeDels(cf.mem.RangeDels())
+	rank++
+
+	prefix := cf.rangePrefix(start, end)
+	for lvl, level := range cf.levels {
+		// таблицы базового уровня пересекаются, новые таблицы идут последними
+		files := level.Files
+		if lvl == int(sst.BaseLevel) {
+			files = slices.Clone(files)
+			slices.Reverse(files)
+		}
+
+		for _, file := range files {
+			addRangeDels(file.RangeDels)
+			if cf.skipTable(file, start, end, prefix) {
+				if lvl == int(sst.BaseLevel) {
+					rank++
+				}
+				continue
+			}
+
+			next, err := it.openTable(file, start, end)
+			if err == nil {
+				err = add(next)
+			}
+			if err != nil {
+				it.Close()
+				return fmt.Errorf("failed to open table %d of level %d: %w", file.SeqNum, file.Level, err)
+			}
+			if lvl == int(sst.BaseLevel) {
+				rank++
+			}
+		}
+		if lvl != int(sst.BaseLevel) {
+			rank++
+		}
+	}
+
+	it.advance()
+	if it.key != nil {
+		// читатели таблиц брошенного итератора закрываются сборщиком мусора
+		runtime.SetFinalizer(it, (*Iterator).Close)
+	}
+
+	return nil
+}
+
+// skipTable сообщает, что в таблице нет ключей из [start, end)
+// по ее границам или по фильтру префиксов.
+func (cf *ColumnFamily) skipTable(file sst.SSTFile, start, end, prefix []byte) bool {
+	if (end != nil && bytes.Compare(file.Smallest, end) >= 0) ||
+		(start != nil && len(file.Largest) > 0 && bytes.Compare(file.Largest, start) < 0) {
+		return true
+	}
+	if f, ok := file.Filter.(*bloom.PrefixFilter); ok && prefix != nil && !f.TestPrefix(prefix) {
+		return true
+	}
+
+	return false
+}
+
+// openTable открывает таблицу и возвращает перебор ее ключей из [start, end).
+// Таблица, удаленная уплотнением после открытия, читается до закрытия итератора.
+func (it *Iterator) openTable(file sst.SSTFile, start, end []byte) (func() ([]byte, []byte, error), error) {
+	r, err := sst.New(sst.PathBy(it.cf.root, file.Level, file.SeqNum))
+	if err != nil {
+		return nil, err
+	}
+	it.readers = append(it.readers, r)
+
+	tit, err := r.NewIterator(start)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() ([]byte, []byte, error) {
+		if !tit.HasNext() {
+			return nil, nil, nil
+		}
+		k, v, err := tit.Next()
+		if err != nil || (end != nil && bytes.Compare(k, end) >= 0) {
+			return nil, nil, err
+		}
+		return k, v, nil
+	}, nil
+}
+
+// entries возвращает перебор упорядоченных записей.
+func entries(elems []sst.ElemSST) func() ([]byte, []byte, error) {
+	return func() ([]byte, []byte, error) {
+		if len(elems) == 0 {
+			return nil, nil, nil
+		}
+		e := elems[0]
+		elems = elems[1:]
+		return e.Key, e.Val, nil
+	}
+}
+
+// HasNext tells if the iterator has more keys or an error to return.
+func (it *Iterator) HasNext() bool {
+	return it.key != nil || it.err != nil
+}
+
+// Next returns the next key and its value.
+func (it *Iterator) Next() ([]byte, []byte, error) {
+	if it.err != nil {
+		err := it.err
+		it.err = nil
+		return nil, nil, err
+	}
+
+	key, value := it.key, it.value
+	it.advance()
+
+	return key, value, nil
+}
+
+// Close releases the tables and the blob files read by the iterator. The iterator closes them
+// itself once all keys are returned or it fails, so Close is only needed
+// if the iteration is stopped early. It is safe to call Close more than once.
+func (it *Iterator) Close() error {
+	runtime.SetFinalizer(it, nil)
+	it.sources = nil
+
+	var err error
+	for _, r := range it.readers {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	it.readers = nil
+	if it.unpin != nil {
+		it.unpin()
+	}
+
+	return err
+}
+
+// advance находит следующий ключ, который не удален. Версии ключа из всех
+// источников пропускаются, остается версия источника с наименьшим рангом.
+func (it *Iterator) advance() {
+	it.key, it.value = nil, nil
+	for len(it.sources) > 0 {
+		if err := it.ctx.Err(); err != nil {
+			it.fail(err)
+			return
+		}
+
+		top := it.sources[0]
+		key, value, rank := top.key, top.value, top.rank
+		for len(it.sources) > 0 && bytes.Equal(it.sources[0].key, key) {
+			if err := it.step(); err != nil {
+				it.fail(err)
+				return
+			}
+		}
+
+		if it.covered(key, rank) {
+			continue
+		}
+		dec := it.cf.db.decoder.Decode(value)
+		if dec.IsTombstone() {
+			continue
+		}
+		if it.encoded {
+			it.key, it.value = key, value
+			return
+		}
+
+		value, err := it.cf.resolve(dec)
+		if err != nil {
+			it.fail(err)
+			return
+		}
+		it.key, it.value = key, value
+		return
+	}
+
+	it.Close()
+}
+
+// step переводит источник с наименьшим ключом на следующую запись.
+func (it *Iterator) step() error {
+	src := it.sources[0]
+	key, value, err := src.next()
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		heap.Pop(&it.sources)
+		return nil
+	}
+
+	src.key, src.value = key, value
+	heap.Fix(&it.sources, 0)
+
+	return nil
+}
+
+// covered сообщает, удален ли ключ источника ранга rank надгробием
+// диапазона более нового источника.
+func (it *Iterator) covered(key []byte, rank int) bool {
+	for _, rd := range it.rangeDels {
+		if rd.rank < rank && rd.Contains(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (it *Iterator) fail(err error) {
+	it.err = err
+	it.Close()
+}
+
+// NewIterator returns an iterator over the keys of the default column family in [start, end).
+// Nil start or end means the range is not limited from that side.
+func (t *LSMTree) NewIterator(start, end []byte) (*Iterator, error) {
+	return t.def.NewIterator(start, end)
+}
+
+// NewIteratorContext is like NewIterator, but the iterator returns
+// the error of the context once the context is done.
+func (t *LSMTree) NewIteratorContext(ctx context.Context, start, end []byte) (*Iterator, error) {
+	return t.def.NewIteratorContext(ctx, start, end)
+}
+
+// NewPrefixIterator returns an iterator over the keys of the default column family
+// starting with the prefix.
+func (t *LSMTree) NewPrefixIterator(prefix []byte) (*Iterator, error) {
+	return t.def.NewPrefixIterator(prefix)
+}
+
+// NewIterator returns an iterator over the keys of the column family in [start, end).
+// Nil start or end means the range is not limited from that side. The iterator
+// sees the column family as it was when the iterator was created.
+func (cf *ColumnFamily) NewIterator(start, end []byte) (*Iterator, error) {
+	return cf.NewIteratorContext(context.Background(), start, end)
+}
+
+// NewIteratorContext is like NewIterator, but the iterator returns
+// the error of the context once the context is done.
+func (cf *ColumnFamily) NewIteratorContext(ctx context.Context, start, end []byte) (*Iterator, error) {
+	return cf.newIterator(ctx, start, end, nil)
+}
+
+// NewPrefixIterator returns an iterator over the keys of the column family
+// starting with the prefix. If the prefix is extracted by Config.PrefixExtractor,
+// tables whose filters do not contain the prefix are not read.
+func (cf *ColumnFamily) NewPrefixIterator(prefix []byte) (*Iterator, error) {
+	return cf.NewIterator(prefix, prefixEnd(prefix))
+}
+
+// rangePrefix возвращает общий префикс всех ключей из [start, end)
+// или nil, если у ключей нет общего префикса. Ключи не меньше start и
+// меньше prefixEnd(prefix) начинаются с байтов префикса start.
+func (cf *ColumnFamily) rangePrefix(start, end []byte) []byte {
+	if cf.config.PrefixExtractor == nil || start == nil || end == nil {
+		return nil
+	}
+
+	prefix, ok := cf.config.PrefixExtractor.Prefix(start)
+	if !ok {
+		return nil
+	}
+	if pend := prefixEnd(prefix); pend != nil && bytes.Compare(end, pend) > 0 {
+		return nil
+	}
+
+	return prefix
+}
+
+// prefixEnd возвращает наименьший ключ больше всех ключей с префиксом
+// или nil, если такого ключа нет.
+func prefixEnd(prefix []byte) []byte {
+	end := slices.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
+
+// inRange tells if the key is in [start, end); nil start or end is not limited.
+func inRange(key, start, end []byte) bool {
+	return (start == nil || bytes.Compare(key, start) >= 0) &&
+		(end == nil || bytes.Compare(key, end) < 0)
+}
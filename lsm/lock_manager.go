@@ -0,0 +1,192 @@
+package lsm
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of stripes of the lock manager.
+	lockStripes = 64
+	// Default time to wait for a key lock.
+	defaultLockTimeout = time.Second
+)
+
+var (
+	// ErrLockTimeout is returned when a key lock is not acquired in the lock timeout.
+	ErrLockTimeout = errors.New("lock timeout")
+	// ErrDeadlock is returned to the transaction aborted to resolve a deadlock.
+	ErrDeadlock = errors.New("deadlock detected")
+)
+
+// keyLock это эксклюзивная блокировка ключа. Канал released закрывается,
+// когда владелец снимает блокировку.
+type keyLock struct {
+	owner    uint64
+	released chan struct{}
+}
+
+type lockStripe struct {
+	mu    sync.Mutex
+	locks map[txnKey]*keyLock
+}
+
+// lockManager выдает пессимистичным транзакциям эксклюзивные блокировки ключей.
+// Блокировки разбиты на полосы по хэшу ключа, чтобы транзакции с разными
+// ключами не конкурировали за один мьютекс. Ожидания транзакций образуют
+// граф wait-for, цикл в котором означает взаимоблокировку: самая молодая
+// транзакция цикла прерывается.
+type lockManager struct {
+	stripes [lockStripes]lockStripe
+
+	mu sync.Mutex
+	// Транзакция -> владелец блокировки, которую она ждет.
+	waitFor map[uint64]uint64
+	// Каналы прерывания активных транзакций.
+	aborts map[uint64]chan struct{}
+}
+
+func newLockManager() *lockManager {
+	lm := &lockManager{
+		waitFor: make(map[uint64]uint64),
+		aborts:  make(map[uint64]chan struct{}),
+	}
+	for i := range lm.stripes {
+		lm.stripes[i].locks = make(map[txnKey]*keyLock)
+	}
+
+	return lm
+}
+
+// register регистрирует транзакцию, чтобы ее можно было прервать.
+func (lm *lockManager) register(id uint64) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.aborts[id] = make(chan struct{})
+}
+
+func (lm *lockManager) unregister(id uint64) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	delete(lm.aborts, id)
+	delete(lm.waitFor, id)
+}
+
+func (lm *lockManager) stripe(key txnKey) *lockStripe {
+	h := fnv.New32a()
+	h.Write([]byte(key.cf.name))
+	h.Write([]byte(key.key))
+
+	return &lm.stripes[h.Sum32()%lockStripes]
+}
+
+// lock блокирует ключ для транзакции id, ожидая не дольше timeout.
+func (lm *lockManager) lock(id uint64, key txnKey, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	s := lm.stripe(key)
+	for {
+		s.mu.Lock()
+		l, ok := s.locks[key]
+		if !ok {
+			s.locks[key] = &keyLock{owner: id, released: make(chan struct{})}
+			s.mu.Unlock()
+			return nil
+		}
+		if l.owner == id {
+			s.mu.Unlock()
+			return nil
+		}
+		owner, released := l.owner, l.released
+		s.mu.Unlock()
+
+		abort, err := lm.wait(id, owner)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-released:
+			lm.done(id)
+		case <-abort:
+			lm.done(id)
+			return ErrDeadlock
+		case <-timer.C:
+			lm.done(id)
+			return ErrLockTimeout
+		}
+	}
+}
+
+// wait добавляет в граф ребро id -> owner и проверяет, не образовался ли цикл.
+// Если транзакция id самая молодая в цикле, она прерывается сразу,
+// иначе прерывается самая молодая транзакция цикла.
+func (lm *lockManager) wait(id, owner uint64) (<-chan struct{}, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.waitFor[id] = owner
+
+	// граф может содержать цикл без id, если прерванная транзакция
+	// еще не убрала свое ребро, поэтому обход ограничен числом ребер
+	youngest, cycle := id, false
+	cur, ok := owner, true
+	for i := 0; ok && i <= len(lm.waitFor); i++ {
+		if cur == id {
+			cycle = true
+			break
+		}
+		youngest = max(youngest, cur)
+		cur, ok = lm.waitFor[cur]
+	}
+
+	if cycle {
+		if youngest == id {
+			delete(lm.waitFor, id)
+			return nil, ErrDeadlock
+		}
+		if abort, ok := lm.aborts[youngest]; ok {
+			select {
+			case <-abort:
+			default:
+				close(abort)
+			}
+		}
+	}
+
+	return lm.aborts[id], nil
+}
+
+// waiting tells if the transaction waits for a lock.
+func (lm *lockManager) waiting(id uint64) bool {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	_, ok := lm.waitFor[id]
+	return ok
+}
+
+func (lm *lockManager) done(id uint64) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	delete(lm.waitFor, id)
+}
+
+// unlock снимает блокировки ключей транзакции id.
+func (lm *lockManager) unlock(id uint64, keys []txnKey) {
+	for _, key := range keys {
+		s := lm.stripe(key)
+		s.mu.Lock()
+		if l, ok := s.locks[key]; ok && l.owner == id {
+			delete(s.locks, key)
+			close(l.released)
+		}
+		s.mu.Unlock()
+	}
+}
@@ -7,12 +7,13 @@ import (
 	"log/slog"
 	"math"
 	"os"
+	"path"
 	"sync"
 	"time"
 
 	"github.com/wubba-com/lsm-distributed/lsm/bloom"
 	"github.com/wubba-com/lsm-distributed/lsm/encoder"
-	"github.com/wubba-com/lsm-distributed/lsm/memtable"
+	"github.com/wubba-com/lsm-distributed/lsm/ratelimit"
 	"github.com/wubba-com/lsm-distributed/lsm/sst"
 	"github.com/wubba-com/lsm-distributed/lsm/wal"
 )
@@ -37,8 +38,23 @@ const (
 	defaultSparseKeyDistance = 128
 	// Default DiskTable number threshold.
 	defaultDiskTableNumThreshold = 10
+	// Default false-positives rate 1/p of bloom filters.
+	defaultBloomFalsePositive = 100
+	// Prefix of the directory of a column family.
+	cfDirPrefix = "cf-"
+	// Directory of blob files of a column family.
+	blobDir = "blob"
+	// Default garbage ratio of a blob file to collect it.
+	defaultBlobGarbageRatio = 0.5
+	// Default capacity of the block cache in bytes.
+	defaultBlockCacheSize = 8 << 20
 )
 
+// DefaultColumnFamily is the name of the column family used by Put, Get,
+// Delete and DeleteRange of the tree. Its files are kept in the root
+// directory of the tree.
+const DefaultColumnFamily = "default"
+
 var (
 	// ErrKeyRequired is returned when putting a zero-length key or nil.
 	ErrKeyRequired = errors.New("key required")
@@ -48,8 +64,22 @@ var (
 	ErrKeyTooLarge = errors.New("key too large")
 	// ErrValueTooLarge is returned when putting a value that is larger than MaxValueSize.
 	ErrValueTooLarge = errors.New("value too large")
+	// ErrInvalidRange is returned when deleting a range whose start is not less than its end.
+	ErrInvalidRange = errors.New("invalid range")
+	// ErrNotFound is returned when the key does not exist or is deleted.
+	ErrNotFound = errors.New("key not found")
+	// ErrClosed is returned when using the db after Shutdown or Close.
+	ErrClosed = errors.New("db closed")
+	// ErrReadOnly is returned when writing to the db opened in read-only mode.
+	ErrReadOnly = errors.New("db is read-only")
+	// ErrCorruption is returned when a file of the db can not be decoded.
+	// The error is wrapped into CorruptionError with the file and the offset.
+	ErrCorruption = sst.ErrCorruption
 )
 
+// CorruptionError describes the corrupted record of a file of the db.
+type CorruptionError = sst.CorruptionError
+
 // LSMTree (https://en.wikipedia.org/wiki/Log-structured_merge-tree)
 // это реализация лог-структуры merge-tree для хранения данных в файлах.
 // Реализация не является goroutine-safe! Убедитесь, что при необходимости доступ
@@ -67,29 +97,44 @@ type LSMTree struct {
 	wg      sync.WaitGroup
 	encoder *encoder.Encoder
 	decoder *encoder.Decoder
-	levels  []sst.SSTLevel
 	debug   bool
 	bufSize int
-	config  *Config
 
 	// Перед выполнением любой операции записи,
 	// она записывается в журнал опережающей записи (WAL) и только потом применяется.
+	// Журнал общий для всех column families.
 	wal  *wal.WAL
-	cSST chan sst.ElemSST
-
-	// Все изменения, которые стираются в WAL, но не стираются
-	// в отсортированные файлы, хранятся в памяти для ускорения поиска.
-	mem *memtable.Memtable
-
-	// Если размер MemTable в байтах превышает пороговое значение, она должна быть
-	// быть смыта в файловую систему.
-
-	// Если число DiskTable превышает порог, дисковые таблицы должны быть
-	// объединить, чтобы уменьшить его.
-	diskTableNumThreshold int
-
-	// Distance between keys in sparse index.
-	sparseKeyDistance int32
+	cSST chan walEntry
+
+	// Column family по умолчанию, с ней работают Put, Get, Delete и DeleteRange дерева.
+	def *ColumnFamily
+	// Все column families дерева, включая column family по умолчанию.
+	cfs map[string]*ColumnFamily
+
+	// Номера записей для проверки конфликтов транзакций.
+	oracle oracle
+	// Блокировки ключей пессимистичных транзакций.
+	locks *lockManager
+
+	// Дерево закрыто, фоновые задачи остановлены.
+	closed bool
+
+	// Состояние контроллера записи: канал stallCh закрывается после
+	// сброса MemTable или уплотнения, чтобы разбудить остановленные записи.
+	stallMu      sync.Mutex
+	stallCh      chan struct{}
+	stats        Stats
+	onWriteStall func(WriteStallInfo)
+
+	// Ограничитель скорости записи сбросов MemTable и уплотнений.
+	limiter *ratelimit.Limiter
+	// Кэш секций индексов и фильтров секционированных таблиц.
+	blockCache *sst.BlockCache
+	// Таблицы читаются через отображение файлов в память, см. MmapReads.
+	mmap bool
+
+	// Дерево открыто только для чтения, см. OpenReadOnly и OpenAsSecondary.
+	readOnly, secondary bool
 }
 
 func DebugMode(debug bool) func(*LSMTree) {
@@ -103,7 +148,7 @@ func DebugMode(debug bool) func(*LSMTree) {
 // быть сброшен в файловую систему.
 func MemTableThreshold(memTableThreshold uint32) func(*LSMTree) {
 	return func(t *LSMTree) {
-		t.config.MemtblDataSize = memTableThreshold
+		t.def.config.MemtblDataSize = memTableThreshold
 	}
 }
 
@@ -111,7 +156,7 @@ func MemTableThreshold(memTableThreshold uint32) func(*LSMTree) {
 // Расстояние между ключами в разреженном индексе.
 func SparseKeyDistance(sparseKeyDistance int32) func(*LSMTree) {
 	return func(t *LSMTree) {
-		t.sparseKeyDistance = sparseKeyDistance
+		t.def.config.SparseKeyDistance = sparseKeyDistance
 	}
 }
 
@@ -120,7 +165,86 @@ func SparseKeyDistance(sparseKeyDistance int32) func(*LSMTree) {
 // объединены, чтобы уменьшить его.
 func DiskTableNumThreshold(diskTableNumThreshold int) func(*LSMTree) {
 	return func(t *LSMTree) {
-		t.diskTableNumThreshold = diskTableNumThreshold
+		t.def.diskTableNumThreshold = diskTableNumThreshold
+	}
+}
+
+// BlobThreshold устанавливает порог blob-файлов для дерева LSM.
+// Значения длиной не меньше порога пишутся в blob-файлы.
+func BlobThreshold(blobThreshold uint32) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.def.config.BlobThreshold = blobThreshold
+	}
+}
+
+// FilterPolicy устанавливает политику фильтров таблиц для дерева LSM.
+// Политика применяется к таблицам, созданным после открытия дерева.
+func FilterPolicy(policy bloom.FilterPolicy) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.def.config.FilterPolicy = policy
+	}
+}
+
+// BottommostFilterPolicy устанавливает политику фильтров таблиц нижнего уровня
+// для дерева LSM.
+func BottommostFilterPolicy(policy bloom.FilterPolicy) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.def.config.BottommostFilterPolicy = policy
+	}
+}
+
+// PrefixExtractor устанавливает выделение префиксов ключей для дерева LSM.
+// Префиксы попадают в фильтры таблиц, созданных после открытия дерева.
+func PrefixExtractor(extractor bloom.PrefixExtractor) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.def.config.PrefixExtractor = extractor
+	}
+}
+
+// RateLimiter устанавливает общий для всех column families ограничитель скорости
+// записи SST-файлов. Сбросы MemTable пишут с высоким приоритетом, уплотнения
+// с низким. Сбросы идут под блокировкой дерева, поэтому не ограничиваются:
+// они не ждут ограничителя, а берут токены в долг у уплотнений. Скорость
+// можно менять во время работы через limiter.SetBytesPerSecond.
+func RateLimiter(limiter *ratelimit.Limiter) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.limiter = limiter
+	}
+}
+
+// IndexPartitionKeys устанавливает число ключей в секции индекса и фильтра
+// таблиц дерева LSM, см. Config.IndexPartitionKeys.
+func IndexPartitionKeys(keys int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.def.config.IndexPartitionKeys = keys
+	}
+}
+
+// BlockCache устанавливает кэш секций индексов и фильтров таблиц всех column
+// families. Один кэш можно разделить между несколькими деревьями. По умолчанию
+// у дерева свой кэш на 8 МиБ.
+func BlockCache(cache *sst.BlockCache) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.blockCache = cache
+	}
+}
+
+// MmapReads включает чтение таблиц через отображение их файлов в память:
+// каждая таблица открывается один раз, и поиск ключей обходится без
+// системных вызовов и копирования индексов. На платформах без mmap таблицы
+// читаются через ReadAt.
+func MmapReads(enabled bool) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.mmap = enabled
+	}
+}
+
+// ColumnFamilyConfig добавляет в дерево column family с именем name и настройками config.
+// Незаданные поля config принимают значения по умолчанию. Все column families,
+// записи которых могут быть в WAL, должны передаваться при каждом открытии дерева.
+func ColumnFamilyConfig(name string, config Config) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.cfs[name] = newColumnFamily(t, name, path.Join(t.root, cfDirPrefix+name), config)
 	}
 }
 
@@ -140,52 +264,111 @@ func Open(path string, options ...func(*LSMTree)) (*LSMTree, error) {
 		return nil, err
 	}
 
-	memTable, err := wal.LoadMem()
-	if err != nil {
-		return nil, fmt.Errorf("failed to load mem from %s: %w", wal.Path(), err)
-	}
-	var sstLvls []sst.SSTLevel
-	sstLvls = append(sstLvls, sst.SSTLevel{})
-
 	// diskTableNum, maxDiskTableIndex, err := readDiskTableMeta(dbDir)
 	// if err != nil {
 	// 	return nil, fmt.Errorf("failed to read disk table meta: %w", err)
 	// }
 
-	ctx, cancel := context.WithCancel(context.Background())
+	t := newTree(path, wal, options...)
 
-	t := &LSMTree{
-		ctx:    ctx,
-		cancel: cancel,
-		wal:    wal,
-		cSST:   make(chan sst.ElemSST),
-		mem:    memTable,
-		levels: sstLvls,
-		root:   path,
-		config: &Config{
-			MemtblDataSize: defaultMemTableThreshold,
-		},
-		sparseKeyDistance:     defaultSparseKeyDistance,
-		diskTableNumThreshold: defaultDiskTableNumThreshold,
-		logger:                logger,
-		encoder:               encoder.NewEncoder(),
-		decoder:               encoder.NewDecoder(),
+	for _, cf := range t.cfs {
+		if _, err := os.Stat(cf.root); os.IsNotExist(err) {
+			if err := os.MkdirAll(cf.root, os.FileMode(0700)); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := cf.loadLevels(); err != nil {
+			return nil, fmt.Errorf("failed to load levels of column family %s: %w", cf.name, err)
+		}
+		if err := cf.openBlobs(); err != nil {
+			return nil, fmt.Errorf("failed to open blob files of column family %s: %w", cf.name, err)
+		}
 	}
-	for _, option := range options {
-		option(t)
+
+	if err := wal.Replay(t.replay); err != nil {
+		return nil, fmt.Errorf("failed to load mem from %s: %w", wal.Path(), err)
 	}
+
 	t.wg.Add(1)
 	go t.walJob()
 
-	t.wg.Add(1)
-	go t.mergeJob()
+	for _, cf := range t.cfs {
+		t.wg.Add(1)
+		go cf.mergeJob()
+	}
 
 	return t, nil
 }
 
+// newTree создает дерево с WAL и применяет к нему настройки.
+func newTree(path string, wal *wal.WAL, options ...func(*LSMTree)) *LSMTree {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	t := &LSMTree{
+		ctx:     ctx,
+		cancel:  cancel,
+		wal:     wal,
+		cSST:    make(chan walEntry),
+		root:    path,
+		cfs:     make(map[string]*ColumnFamily),
+		logger:  logger,
+		encoder: encoder.NewEncoder(),
+		decoder: encoder.NewDecoder(),
+		locks:   newLockManager(),
+		stallCh: make(chan struct{}),
+
+		blockCache: sst.NewBlockCache(defaultBlockCacheSize),
+	}
+	t.def = newColumnFamily(t, DefaultColumnFamily, path, Config{})
+	t.cfs[DefaultColumnFamily] = t.def
+
+	for _, option := range options {
+		option(t)
+	}
+
+	return t
+}
+
+// Config задает настройки column family.
 type Config struct {
 	MemtblDataSize uint32
-	Merge          MergeSettings
+	// Distance between keys in sparse index.
+	SparseKeyDistance int32
+	// Bloom-фильтры таблиц строятся с долей ложноположительных
+	// срабатываний меньше 1/BloomFalsePositive.
+	BloomFalsePositive int
+	// Политика строит фильтры новых таблиц. По умолчанию таблицы получают
+	// bloom.Filter с долей ложноположительных срабатываний 1/BloomFalsePositive.
+	FilterPolicy bloom.FilterPolicy
+	// Политика фильтров таблиц нижнего уровня, который хранит большую часть
+	// данных. Там выгоднее статические фильтры, например bloom.NewXorPolicy.
+	// По умолчанию совпадает с FilterPolicy.
+	BottommostFilterPolicy bloom.FilterPolicy
+	// Если задан, в фильтры таблиц добавляются и префиксы ключей,
+	// а перебор ключей одного префикса пропускает таблицы без него.
+	PrefixExtractor bloom.PrefixExtractor
+	// Если больше 0, таблицы пишутся секционированными: разреженный индекс
+	// и bloom-фильтр таблицы делятся на секции примерно по IndexPartitionKeys
+	// ключей, которые читаются с диска через кэш блоков по мере надобности.
+	// В памяти остается только индекс верхнего уровня, поэтому секции нужны
+	// большим таблицам. Фильтры секций - bloom.Filter с долей ложноположительных
+	// срабатываний 1/BloomFalsePositive, FilterPolicy и PrefixExtractor
+	// к таким таблицам не применяются.
+	IndexPartitionKeys int
+	Merge              MergeSettings
+
+	// Значения длиной не меньше BlobThreshold байт пишутся в blob-файлы,
+	// а в дереве хранится только ссылка на них. Для таких значений
+	// ограничение MaxValueSize не действует. 0 отключает blob-файлы.
+	BlobThreshold uint32
+	// Размер blob-файла, после которого начинается новый файл.
+	BlobFileSize uint64
+	// Сборщик мусора переписывает живые значения blob-файла и удаляет его,
+	// если доля мусора в файле больше BlobGarbageRatio.
+	BlobGarbageRatio float64
+	// Пороги замедления и остановки записей.
+	Stall StallSettings
 }
 
 // Define parameters for managing the SST levels
@@ -214,35 +397,48 @@ type MergeSettings struct {
 	TimeWindow uint32
 }
 
-// Close closes all allocated resources.
+// Close stops background jobs if Shutdown was not called and closes all allocated resources.
 func (t *LSMTree) Close() error {
+	t.stop()
+
 	if err := t.wal.Close(); err != nil {
 		return fmt.Errorf("failed to close file %s: %w", t.wal.Name(), err)
 	}
 
+	for _, cf := range t.cfs {
+		if cf.blobs == nil {
+			continue
+		}
+		if err := cf.blobs.Close(); err != nil {
+			return fmt.Errorf("failed to close blob files %s: %w", cf.blobs.Dir(), err)
+		}
+	}
+
 	return nil
 }
 
+// walEntry - запись, которую commit передает в walJob. Результат записи
+// в WAL возвращается в done, канал должен быть буферизован.
+type walEntry struct {
+	sst.ElemSST
+	done chan error
+}
+
+// walJob записывает в WAL записи, переданные commit. Сбросом MemTable
+// занимается commit под блокировкой дерева, поэтому walJob ее не берет:
+// commit удерживает блокировку, пока ждет ответа.
 func (t *LSMTree) walJob() {
 	defer t.wg.Done()
 	for {
 		select {
-		case sst, ok := <-t.cSST:
+		case entry, ok := <-t.cSST:
 			if !ok {
 				return
 			}
 
-			if err := t.wal.Append(sst.Key, sst.Val); err != nil {
-				logger.Error(err.Error())
-			}
+			entry.done <- t.wal.Append(entry.Key, entry.Val)
 			if t.debug {
-				logger.Debug(fmt.Sprintf("append wal: %s", string(sst.Key)))
-			}
-
-			if t.mem.Size() >= t.config.MemtblDataSize {
-				if err := t.flushMemTable(); err != nil {
-					logger.Error(err.Error())
-				}
+				logger.Debug(fmt.Sprintf("append wal: %s", string(entry.Key)))
 			}
 
 		case <-t.ctx.Done():
@@ -253,107 +449,86 @@ func (t *LSMTree) walJob() {
 
 // Put puts the key into the db.
 func (t *LSMTree) Put(key []byte, value []byte) error {
-	if len(key) == 0 {
-		return ErrKeyRequired
-	} else if len(key) > MaxKeySize {
-		return ErrKeyTooLarge
-	} else if len(value) == 0 {
-		return ErrValueRequired
-	} else if uint64(len(value)) > MaxValueSize {
-		return ErrValueTooLarge
-	}
+	return t.def.Put(key, value)
+}
 
-	value = t.encoder.Encode(encoder.OpKindSet, value)
-	t.lock.Lock()
-	t.cSST <- sst.ElemSST{Key: key, Val: value}
-	t.mem.Put(key, value)
-	t.lock.Unlock()
-	return nil
+// PutContext puts the key into the db unless the context is done.
+func (t *LSMTree) PutContext(ctx context.Context, key []byte, value []byte) error {
+	return t.def.PutContext(ctx, key, value)
 }
 
 // Get the value for the key from the db.
 func (t *LSMTree) Get(key []byte) ([]byte, bool, error) {
-	value, exists := t.mem.Get(key)
-	if exists {
-		if t.debug {
-			logger.Debug("found key memtable")
-		}
-		dec := t.decoder.Decode(value)
-		if dec.IsTombstone() {
-			return dec.Value(), false, fmt.Errorf("key not found")
-		}
+	return t.def.Get(key)
+}
 
-		return dec.Value(), dec.Value() != nil, nil
-	}
+// GetContext gets the value for the key from the db unless the context is done.
+func (t *LSMTree) GetContext(ctx context.Context, key []byte) ([]byte, bool, error) {
+	return t.def.GetContext(ctx, key)
+}
 
-	value, exists, err := sst.SearchInDiskTables(key, t.root, t.levels)
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in DiskTables: %w", err)
-	}
+// Delete delete the value by key from the db.
+func (t *LSMTree) Delete(key []byte) error {
+	return t.def.Delete(key)
+}
 
-	if exists {
-		dec := t.decoder.Decode(value)
+// DeleteContext deletes the key from the db unless the context is done.
+func (t *LSMTree) DeleteContext(ctx context.Context, key []byte) error {
+	return t.def.DeleteContext(ctx, key)
+}
 
-		if dec.IsTombstone() {
-			return nil, false, fmt.Errorf("key not found")
-		}
+// DeleteRange удаляет из базы все ключи полуинтервала [start, end).
+// Вместо надгробия на каждый ключ записывается один range tombstone.
+func (t *LSMTree) DeleteRange(start, end []byte) error {
+	return t.def.DeleteRange(start, end)
+}
 
-		if t.debug {
-			logger.Debug("found key disk")
-		}
+// ColumnFamily возвращает column family с именем name.
+func (t *LSMTree) ColumnFamily(name string) (*ColumnFamily, bool) {
+	cf, ok := t.cfs[name]
+	return cf, ok
+}
 
-		return dec.Value(), exists, nil
+// needFlush сообщает, превысила ли MemTable какой-либо column family свой порог.
+func (t *LSMTree) needFlush() bool {
+	for _, cf := range t.cfs {
+		if cf.mem.Size() >= cf.config.MemtblDataSize {
+			return true
+		}
 	}
 
-	return nil, exists, fmt.Errorf("key not found")
-
+	return false
 }
 
-// Delete delete the value by key from the db.
-func (t *LSMTree) Delete(key []byte) error {
-	val := t.encoder.Encode(encoder.OpKindDelete, nil)
-	if err := t.wal.Append(key, val); err != nil {
-		return fmt.Errorf("failed to append to file %s: %w", t.wal.Name(), err)
+// flushIfNeeded сбрасывает MemTable, если какая-либо из них превысила порог,
+// и будит записи, ожидающие сброса. Ошибка сброса не отменяет уже
+// записанные в WAL операции, поэтому она только журналируется.
+// Функция ожидает, что она будет выполняться под блокировкой дерева на запись.
+func (t *LSMTree) flushIfNeeded() {
+	if !t.needFlush() {
+		return
 	}
 
-	t.mem.Put(key, val)
-
-	return nil
+	if err := t.flushMemTable(); err != nil {
+		t.logger.Error(err.Error())
+	}
+	t.wakeWriters()
 }
 
-// flushMemTable сбрасывает текущую MemTable на диск и очищает ее.
-// Функция ожидает, что она будет выполняться в синхронизированном блоке,
-// и поэтому не использует никаких механизмов синхронизации.
+// flushMemTable сбрасывает MemTable всех column families на диск и очищает WAL.
+// Журнал общий, поэтому его можно очистить, только когда сброшены все column families.
+// Функция ожидает, что она будет выполняться под блокировкой дерева на запись:
+// тогда walJob ответил на все отправленные записи, и каждая запись WAL
+// уже применена к сбрасываемым MemTable.
 func (t *LSMTree) flushMemTable() error {
-	wr, err := sst.NewWriter(t.root, sst.BaseLevel, t.wal.Sequence(), int32(t.sparseKeyDistance))
-	if err != nil {
-		return err
-	}
-	mem := t.mem.Switch()
-
-	filter := bloom.New(mem.Len(), 100)
-	it := mem.Iterator()
-	for it.HasNext() {
-		k, v := it.Next()
-		filter.Add(string(k))
-		if err := wr.Write(k, v); err != nil {
-			return err
+	for _, cf := range t.cfs {
+		if err := cf.flushMemTable(); err != nil {
+			return fmt.Errorf("failed to flush column family %s: %w", cf.name, err)
 		}
 	}
-
-	if err := wr.Close(); err != nil {
-		return err
-	}
-	memMeta, err := sst.NewMemMetaSST(wr.NameSparseFile(), sst.BaseLevel, filter)
-	if err != nil {
+	if err := t.wal.Clear(); err != nil {
 		return err
 	}
-	if err := t.wal.UpSequence(); err != nil {
-		return err
-	}
-
-	t.levels[sst.BaseLevel].Files = append(t.levels[sst.BaseLevel].Files, memMeta)
-	t.wal.Clear()
 
 	if t.debug {
 		t.logger.Debug("flush mem")
@@ -362,11 +537,48 @@ func (t *LSMTree) flushMemTable() error {
 	return nil
 }
 
+// replay применяет запись WAL к MemTable.
+func (t *LSMTree) replay(key, value []byte) error {
+	if len(key) == 0 {
+		return t.applyBatch(value)
+	}
+
+	t.def.apply(key, value)
+
+	return nil
+}
+
+// Shutdown stops background jobs, after that all operations return ErrClosed.
+// The files are closed by Close.
 func (t *LSMTree) Shutdown() error {
+	if !t.stop() {
+		return ErrClosed
+	}
+
+	return nil
+}
+
+// stop закрывает дерево и останавливает фоновые задачи.
+// Возвращает false, если дерево уже было закрыто.
+func (t *LSMTree) stop() bool {
+	t.lock.Lock()
+	if t.closed {
+		t.lock.Unlock()
+		return false
+	}
+	t.closed = true
+	t.lock.Unlock()
+
 	t.cancel()
 
 	close(t.cSST)
 	t.wg.Wait()
 
-	return nil
+	t.lock.Lock()
+	for _, cf := range t.cfs {
+		closeTables(cf.levels)
+	}
+	t.lock.Unlock()
+
+	return true
 }
@@ -2,8 +2,11 @@ package lsm
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 	"time"
+
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
 )
 
 func TestGetPut(t *testing.T) {
@@ -60,3 +63,108 @@ func TestGetPut(t *testing.T) {
 	time.Sleep(3 * time.Second)
 
 }
+
+func TestDeleteRange(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir, MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetMergeSettings(MergeSettings{MaxLevels: 3})
+
+	var flush = func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if err := l.flushMemTable(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var check = func(stage string, found map[string]bool) {
+		for key, want := range found {
+			_, ok, _ := l.Get([]byte(key))
+			if ok != want {
+				t.Fatalf("%s: key %s found %v, want %v", stage, key, ok, want)
+			}
+		}
+	}
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if err := l.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush()
+
+	if err := l.DeleteRange([]byte("b"), []byte("e")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put([]byte("c"), []byte("cc")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{"a": true, "b": false, "c": true, "d": false, "e": true}
+	check("memtable", want)
+
+	flush()
+	check("flush", want)
+
+	if err := l.def.compact(sst.BaseLevel); err != nil {
+		t.Fatal(err)
+	}
+	check("compaction", want)
+
+	if v, _, _ := l.Get([]byte("c")); !bytes.Equal(v, []byte("cc")) {
+		t.Fatalf("c = %s, want cc", v)
+	}
+
+	if err := l.def.compact(sst.BaseLevel + 1); err != nil {
+		t.Fatal(err)
+	}
+	check("compaction to level 2", want)
+
+	// range tombstone of level 1 covers the key of level 2
+	if err := l.DeleteRange([]byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	flush()
+	if err := l.def.compact(sst.BaseLevel); err != nil {
+		t.Fatal(err)
+	}
+	want["a"] = false
+	check("compaction over level 2", want)
+
+	if err := l.Put([]byte("x"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.DeleteRange([]byte("x"), []byte("y")); err != nil {
+		t.Fatal(err)
+	}
+	l.Shutdown()
+	l.Close()
+
+	l, err = Open(dir, MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	if _, ok, _ := l.Get([]byte("x")); ok {
+		t.Fatal("key x found after replay of WAL!")
+	}
+}
+
+func TestDeleteRangeInvalid(t *testing.T) {
+	l, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	if err := l.DeleteRange([]byte("b"), []byte("a")); !errors.Is(err, ErrInvalidRange) {
+		t.Fatalf("err %v != %v", err, ErrInvalidRange)
+	}
+	if err := l.DeleteRange(nil, []byte("a")); !errors.Is(err, ErrKeyRequired) {
+		t.Fatalf("err %v != %v", err, ErrKeyRequired)
+	}
+}
@@ -1,13 +1,17 @@
 package memtable
 
 import (
+	"bytes"
+
 	sl "github.com/wubba-com/lsm-distributed/lsm/skiplist"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
 )
 
 type Memtable struct {
-	data *sl.SkipList
-	b    int
-	len  int
+	data      *sl.SkipList
+	rangeDels []sst.RangeTombstone
+	b         int
+	len       int
 }
 
 // MemTable. All changes that are flushed to the WAL, but not flushed
@@ -37,6 +41,49 @@ func (mt *Memtable) Get(key []byte) ([]byte, bool) {
 	return mt.data.Get(key)
 }
 
+// DeleteRange marks all keys in [start, end) as deleted. The keys of the
+// range are removed from the table, so any key left in the table
+// is newer than the range tombstones of the table.
+func (mt *Memtable) DeleteRange(start, end []byte) {
+	var keys [][]byte
+	it := mt.data.Iterator()
+	for it.HasNext() {
+		k, _ := it.Next()
+		if bytes.Compare(k, end) >= 0 {
+			break
+		}
+		if bytes.Compare(k, start) >= 0 {
+			keys = append(keys, k)
+		}
+	}
+
+	for _, k := range keys {
+		v, _ := mt.data.Get(k)
+		mt.data.Delete(k)
+		mt.b -= len(k) + len(v)
+		mt.len--
+	}
+
+	mt.rangeDels = append(mt.rangeDels, sst.RangeTombstone{Start: start, End: end})
+	mt.b += len(start) + len(end)
+}
+
+// Covers tells if the key is deleted by a range tombstone of the table.
+func (mt *Memtable) Covers(key []byte) bool {
+	for _, rd := range mt.rangeDels {
+		if rd.Contains(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RangeDels returns the range tombstones of the table.
+func (mt *Memtable) RangeDels() []sst.RangeTombstone {
+	return mt.rangeDels
+}
+
 func (mt *Memtable) Len() int {
 	return mt.len
 }
@@ -49,6 +96,7 @@ func (mt *Memtable) Size() uint32 {
 func (mt *Memtable) Switch() Memtable {
 	old := *mt
 	mt.data = sl.NewSkipList()
+	mt.rangeDels = nil
 	mt.b = 0
 	mt.len = 0
 
@@ -58,6 +106,7 @@ func (mt *Memtable) Switch() Memtable {
 // clear clears all the data and resets the size.
 func (mt *Memtable) Clear() {
 	mt.data = sl.NewSkipList()
+	mt.rangeDels = nil
 	mt.b = 0
 }
 
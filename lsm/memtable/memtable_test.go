@@ -22,3 +22,29 @@ func TestMemSwitch(t *testing.T) {
 		t.Fatal("key not found!")
 	}
 }
+
+func TestMemDeleteRange(t *testing.T) {
+	mem := NewMem()
+	mem.Put([]byte("a"), []byte("a"))
+	mem.Put([]byte("b"), []byte("b"))
+	mem.Put([]byte("c"), []byte("c"))
+
+	mem.DeleteRange([]byte("a"), []byte("c"))
+	if _, ok := mem.Get([]byte("a")); ok {
+		t.Fatal("key a found!")
+	}
+	if !mem.Covers([]byte("b")) {
+		t.Fatal("key b not covered!")
+	}
+	if mem.Covers([]byte("c")) {
+		t.Fatal("key c covered!")
+	}
+	if mem.Len() != 1 {
+		t.Fatalf("len %d != 1", mem.Len())
+	}
+
+	mem.Put([]byte("b"), []byte("bb"))
+	if v, ok := mem.Get([]byte("b")); !ok || string(v) != "bb" {
+		t.Fatal("key b not found!")
+	}
+}
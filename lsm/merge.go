@@ -6,71 +6,107 @@ import (
 	"log"
 	"log/slog"
 	"math"
+	"slices"
 	"time"
 
+	"github.com/wubba-com/lsm-distributed/lsm/ratelimit"
 	"github.com/wubba-com/lsm-distributed/lsm/sst"
 )
 
 // MergeJob runs as a background thread and coordinates when to check SST levels for merging.
-func (t *LSMTree) mergeJob() {
-	defer t.wg.Done()
-	if t.config.Merge.Interval == 0 {
-		log.Println("mergeJob interval not set, stopping goroutine")
-		return
+// Levels are checked every Merge.Interval, if it is set, and whenever the write
+// controller requests a compaction because writes are stalled.
+func (cf *ColumnFamily) mergeJob() {
+	defer cf.db.wg.Done()
+
+	var tick <-chan time.Time
+	if interval := cf.mergeSettings().Interval; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
 	}
-	ticker := time.NewTicker(t.config.Merge.Interval)
 
 	for {
 		select {
-		case <-ticker.C:
-			//log.Println("LSM merge job woke up")
-			if err := t.merge(); err != nil {
-				t.logger.Debug(err.Error())
-			}
-		case <-t.ctx.Done():
+		case <-tick:
+		case <-cf.compactCh:
+		case <-cf.db.ctx.Done():
 			return
 		}
 
+		if err := cf.merge(); err != nil {
+			cf.db.logger.Debug(err.Error())
+		}
+	}
+}
+
+// requestCompaction будит mergeJob, чтобы он уплотнил уровень 0,
+// замедляющий или останавливающий записи. Запрос не ждет mergeJob,
+// запросы, пришедшие до его пробуждения, объединяются.
+func (cf *ColumnFamily) requestCompaction() {
+	select {
+	case cf.compactCh <- struct{}{}:
+	default:
 	}
 }
 
 func (s *LSMTree) SetMergeSettings(ms MergeSettings) {
-	s.config.Merge = ms
+	s.def.SetMergeSettings(ms)
+}
+
+func (cf *ColumnFamily) SetMergeSettings(ms MergeSettings) {
+	cf.db.lock.Lock()
+	defer cf.db.lock.Unlock()
+
+	cf.config.Merge = ms
+}
+
+// mergeSettings возвращает настройки слияния, которые может менять SetMergeSettings.
+func (cf *ColumnFamily) mergeSettings() MergeSettings {
+	cf.db.lock.RLock()
+	defer cf.db.lock.RUnlock()
+
+	return cf.config.Merge
 }
 
-func (t *LSMTree) merge() error {
-	lvls, err := sst.Levels(t.root)
+func (cf *ColumnFamily) merge() error {
+	lvls, err := sst.Levels(cf.root)
 	if err != nil {
 		return err
 	}
 
-	//t.logger.Debug("мем уровни", slog.Any("lvls", lvls))
+	//cf.db.logger.Debug("мем уровни", slog.Any("lvls", lvls))
 
+	settings := cf.mergeSettings()
 	for _, lvl := range lvls {
-		files, err := sst.Filename(t.root, lvl)
+		files, err := sst.Filename(cf.root, lvl)
 		if err != nil {
 			return err
 		}
 
 		var (
 			isMerge = false
-			num     = t.config.Merge.NumberOfSstFiles
+			num     = settings.NumberOfSstFiles
 		)
-		if t.debug {
-			t.logger.Debug("нужно сливать?", slog.Bool("is merge", num > 0 && len(files) > num*int(lvl+1)), slog.Int("lvl", int(lvl)))
+		if cf.db.debug {
+			cf.db.logger.Debug("нужно сливать?", slog.Bool("is merge", num > 0 && len(files) > num*int(lvl+1)), slog.Int("lvl", int(lvl)))
 		}
 		if num > 0 && len(files) > num*int(lvl+1) {
 			log.Printf("merge level %d, number of files %d exceeded merge threshold", lvl, len(files))
 			isMerge = true
 
-			if lvl == sst.Level(t.config.Merge.MaxLevels) {
+			if lvl == sst.Level(settings.MaxLevels) {
 				// условия для последнего уровня
 				isMerge = false
 			}
 		}
+		if lvl == sst.BaseLevel && cf.stallsWrites(len(files)) {
+			isMerge = true
+		}
 
 		if isMerge {
-			t.compact(lvl)
+			cf.compact(lvl)
+			cf.db.notifyWriters()
 		}
 	}
 
@@ -80,7 +116,7 @@ func (t *LSMTree) merge() error {
 // Merge берет все текущие SST-файлы на уровне и объединяет их с
 // SST-файлами на следующем уровне дерева LSM. Во время этого
 // процесса данные уплотняются, и все старые значения ключей или надгробные плиты удаляются безвозвратно.
-func (t *LSMTree) compact(level sst.Level) error {
+func (cf *ColumnFamily) compact(level sst.Level) error {
 	// Общий алгоритм
 	//
 	// - найти путь к уровню, получить все sst-файлы
@@ -95,7 +131,10 @@ func (t *LSMTree) compact(level sst.Level) error {
 	// - записываем в syslog, считаем WAL
 	// TODO: если level == tree.merge.MaxLevels, то уплотнить этот уровень вместо слияния в l+1
 
-	currentMaxLvl := sst.Level(len(t.levels))
+	cf.compactMu.Lock()
+	defer cf.compactMu.Unlock()
+
+	currentMaxLvl := sst.Level(len(cf.levels))
 	if level > currentMaxLvl {
 		desc := fmt.Sprintf("merge cannot process level %d because the tree only has %d levels", level, currentMaxLvl)
 		log.Println(desc)
@@ -103,59 +142,76 @@ func (t *LSMTree) compact(level sst.Level) error {
 		return errors.New(desc)
 	}
 
-	if level > 0 && level == sst.Level(t.config.Merge.MaxLevels) {
+	if level > 0 && level == sst.Level(cf.mergeSettings().MaxLevels) {
 		// if max lvl
 
 		return nil
 	}
 
-	currentLvlFiles, err := sst.Filename(t.root, level)
+	currentLvlFiles, err := sst.Filename(cf.root, level)
 	if err != nil {
 		return err
 	}
 
-	nextLvlFiles, err := sst.Filename(t.root, level+1)
+	nextLvlFiles, err := sst.Filename(cf.root, level+1)
 	if err != nil {
 		return err
 	}
 
 	currentLvlFiles = append(currentLvlFiles, nextLvlFiles...)
 
-	if t.debug {
-		t.logger.Debug("файлы для уплотнения", slog.Any("files", currentLvlFiles))
+	if cf.db.debug {
+		cf.db.logger.Debug("файлы для уплотнения", slog.Any("files", currentLvlFiles))
 	}
 
-	var removedTombstone bool
-	if level == currentMaxLvl {
-		removedTombstone = true
+	// надгробия можно удалить, только если ниже level+1 нет данных
+	lvls, err := sst.Levels(cf.root)
+	if err != nil {
+		return err
 	}
-
-	meta, err := sst.Compact(t.root, currentLvlFiles, level, t.config.MemtblDataSize*uint32(math.Pow(2, float64(level+1))), t.sparseKeyDistance, removedTombstone)
+	removedTombstone := len(lvls) == 0 || lvls[len(lvls)-1] <= level+1
+	metas, err := sst.Compact(cf.root, currentLvlFiles, level, cf.config.MemtblDataSize*uint32(math.Pow(2, float64(level+1))), cf.config.SparseKeyDistance, cf.filterPolicy(removedTombstone), removedTombstone, cf.db.wal.NextSequence, cf.writerOptions(ratelimit.PriorityLow)...)
 	if err != nil {
 		return err
 	}
-
-	if !t.config.Merge.Immediate {
-		t.lock.Lock()
-		defer t.lock.Unlock()
+	for i := range metas {
+		if err := cf.openTable(&metas[i]); err != nil {
+			return err
+		}
 	}
 
+	// таблицы удаляются и заменяются под блокировкой дерева на запись,
+	// чтобы поиск и контрольная точка видели согласованные уровни
+	cf.db.lock.Lock()
+	defer cf.db.lock.Unlock()
+
 	for idx := range currentLvlFiles {
-		if err := sst.Remove(t.root, currentLvlFiles[idx].Level, currentLvlFiles[idx].SeqNum); err != nil {
+		if err := sst.Remove(cf.root, currentLvlFiles[idx].Level, currentLvlFiles[idx].SeqNum); err != nil {
 			return err
 		}
 	}
 
-	if meta.Level-level == 1 {
-		t.levels = append(t.levels, sst.SSTLevel{})
-		t.levels[meta.Level].Files = append(t.levels[meta.Level].Files, meta)
-		t.levels[level] = sst.SSTLevel{}
-	} else {
-		return fmt.Errorf("the level is too high")
+	for len(cf.levels) <= int(level)+1 {
+		cf.levels = append(cf.levels, sst.SSTLevel{})
+	}
+
+	// файлы, сброшенные на уровень во время уплотнения, остаются на месте
+	var files, removed []sst.SSTFile
+	for _, file := range cf.levels[level].Files {
+		if !slices.ContainsFunc(currentLvlFiles, func(f sst.LevelFile) bool {
+			return f.Level == file.Level && f.SeqNum == file.SeqNum
+		}) {
+			files = append(files, file)
+		} else {
+			removed = append(removed, file)
+		}
 	}
+	closeTables([]sst.SSTLevel{{Files: removed}, cf.levels[level+1]})
+	cf.levels[level].Files = files
+	cf.levels[level+1].Files = metas
 
-	if t.debug {
-		t.logger.Debug("уплотнение закончено", slog.Int("lvls", int(level)), slog.Any("lvls", t.levels))
+	if cf.db.debug {
+		cf.db.logger.Debug("уплотнение закончено", slog.Int("lvls", int(level)), slog.Any("lvls", cf.levels))
 	}
 
 	return nil
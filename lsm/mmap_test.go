@@ -0,0 +1,68 @@
+package lsm
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+func TestMmapReads(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, SparseKeyDistance(4), MmapReads(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("key%03d", i))
+			if err := l.Put(key, []byte(fmt.Sprintf("%s-%d", key, round))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		l.lock.Lock()
+		err = l.flushMemTable()
+		l.lock.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.def.compact(sst.BaseLevel); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range l.def.levels[1].Files {
+		if file.Reader == nil || file.Reader.Mapped() != (runtime.GOOS == "linux") {
+			t.Fatalf("table %d is not mapped", file.SeqNum)
+		}
+	}
+	if err := l.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// таблицы отображаются в память и после открытия
+	l, err = Open(dir, MmapReads(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		value, ok, err := l.Get(key)
+		if err != nil || !ok || string(value) != fmt.Sprintf("%s-1", key) {
+			t.Fatalf("%s: get %s %t (%v)", key, value, ok, err)
+		}
+	}
+	if _, ok, _ := l.Get([]byte("missing")); ok {
+		t.Fatal("missing key found")
+	}
+
+	values, errs := l.MultiGet([][]byte{[]byte("key001"), []byte("key099")})
+	for i, want := range []string{"key001-1", "key099-1"} {
+		if errs[i] != nil || string(values[i]) != want {
+			t.Fatalf("multi get %d: %s (%v), want %s", i, values[i], errs[i], want)
+		}
+	}
+}
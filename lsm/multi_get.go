@@ -0,0 +1,98 @@
+package lsm
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/wubba-com/lsm-distributed/lsm/blob"
+	"github.com/wubba-com/lsm-distributed/lsm/encoder"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+// MultiGet returns the values of the keys from the db in the order of keys.
+// The error of a key is set if the key is not found or failed to read.
+func (t *LSMTree) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return t.def.MultiGet(keys)
+}
+
+// MultiGet returns the values of the keys from the column family in the order of keys.
+// The error of a key is set if the key is not found or failed to read.
+//
+// Unlike calling Get for each key, the keys are sorted and looked up
+// in the MemTable at once, and each DiskTable is visited once for all
+// keys left, see sst.MultiSearchInDiskTables.
+func (cf *ColumnFamily) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	// уникальные ключи в порядке возрастания
+	sorted := slices.Clone(keys)
+	slices.SortFunc(sorted, bytes.Compare)
+	sorted = slices.CompactFunc(sorted, bytes.Equal)
+
+	found := make(map[string]*encoder.EncodedValue, len(sorted))
+	failed := make(map[string]error)
+
+	cf.db.lock.RLock()
+	if cf.db.closed {
+		cf.db.lock.RUnlock()
+		for i := range errs {
+			errs[i] = ErrClosed
+		}
+		return values, errs
+	}
+
+	var pending [][]byte
+	for _, key := range sorted {
+		if value, ok := cf.mem.Get(key); ok {
+			found[string(key)] = cf.db.decoder.Decode(value)
+		} else if !cf.mem.Covers(key) {
+			pending = append(pending, key)
+		}
+	}
+
+	results := sst.MultiSearchInDiskTables(pending, cf.root, cf.levels)
+	if cf.blobs != nil {
+		// сборщик мусора не удалит blob-файлы, пока значения читаются
+		defer cf.blobs.Pin()()
+	}
+	cf.db.lock.RUnlock()
+
+	for i, res := range results {
+		if res.Err != nil {
+			failed[string(pending[i])] = fmt.Errorf("failed to search in DiskTables: %w", res.Err)
+		} else if res.Found {
+			found[string(pending[i])] = cf.db.decoder.Decode(res.Value)
+		}
+	}
+
+	for i, key := range keys {
+		if err, ok := failed[string(key)]; ok {
+			errs[i] = err
+			continue
+		}
+
+		dec, ok := found[string(key)]
+		if !ok || dec.IsTombstone() {
+			errs[i] = ErrNotFound
+			continue
+		}
+
+		if dec.IsBlobRef() {
+			ref, err := blob.DecodeRef(dec.Value())
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			if values[i], err = cf.blobs.Read(ref); err != nil {
+				errs[i] = fmt.Errorf("failed to read blob: %w", err)
+			}
+			continue
+		}
+
+		values[i] = dec.Value()
+	}
+
+	return values, errs
+}
@@ -0,0 +1,62 @@
+package lsm
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestMultiGet(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20), SparseKeyDistance(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	var flush = func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if err := l.flushMemTable(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := l.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("old%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush()
+	for i := 0; i < 100; i += 3 {
+		if err := l.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("new%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.DeleteRange([]byte("key050"), []byte("key060")); err != nil {
+		t.Fatal(err)
+	}
+	flush()
+	if err := l.Delete([]byte("key001")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put([]byte("key002"), []byte("mem")); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys [][]byte
+	for i := 99; i >= 0; i-- {
+		keys = append(keys, []byte(fmt.Sprintf("key%03d", i)))
+	}
+	keys = append(keys, []byte("key010"), []byte("missing"))
+
+	values, errs := l.MultiGet(keys)
+	if len(values) != len(keys) || len(errs) != len(keys) {
+		t.Fatalf("results %d, %d != %d", len(values), len(errs), len(keys))
+	}
+	for i, key := range keys {
+		value, ok, _ := l.Get(key)
+		if ok != (errs[i] == nil) || !bytes.Equal(value, values[i]) {
+			t.Fatalf("%s: multi get %s (%v) != get %s (%t)", key, values[i], errs[i], value, ok)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Package ratelimit limits the rate of background I/O of the tree.
+//
+// A Limiter is a token bucket shared by the writers of flushes and
+// compactions. Flushes are not limited: they run under the write lock
+// of the tree, and delaying them would block all reads and writes.
+// Requests of PriorityHigh return at once and take their tokens in debt,
+// and compactions wait until the debt is paid, so the bytes of flushes
+// are taken from the budget of compactions.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Period for which tokens are accumulated: the bucket holds at most
+// the bytes allowed for the period, so a long pause does not turn into a burst.
+const refillPeriod = 100 * time.Millisecond
+
+// Priority is the priority of an I/O request.
+type Priority int
+
+const (
+	// PriorityLow is the priority of compactions.
+	PriorityLow Priority = iota
+	// PriorityHigh is the priority of MemTable flushes.
+	// Requests of the priority are not delayed.
+	PriorityHigh
+
+	numPriorities
+)
+
+// Limiter limits the rate of I/O in bytes per second.
+// A nil Limiter does not limit anything.
+type Limiter struct {
+	mu sync.Mutex
+
+	rate   int64
+	tokens float64
+	last   time.Time
+
+	total [numPriorities]int64
+}
+
+// New returns a limiter of bytesPerSecond. Zero or negative rate does not limit I/O.
+func New(bytesPerSecond int64) *Limiter {
+	return &Limiter{rate: bytesPerSecond, last: time.Now()}
+}
+
+// SetBytesPerSecond changes the rate of the limiter. Waiting requests
+// are served with the new rate.
+func (l *Limiter) SetBytesPerSecond(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	l.rate = bytesPerSecond
+	if b := l.burst(); l.tokens > b {
+		l.tokens = b
+	}
+}
+
+// BytesPerSecond returns the rate of the limiter.
+func (l *Limiter) BytesPerSecond() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rate
+}
+
+// Total returns the number of bytes requested with the priority.
+func (l *Limiter) Total(pri Priority) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.total[pri]
+}
+
+// Request blocks until n bytes of the priority may be read or written.
+// Requests larger than the bucket are served in parts. Requests of
+// PriorityHigh return at once, taking the tokens they lack in debt.
+func (l *Limiter) Request(n int, pri Priority) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total[pri] += int64(n)
+	if pri == PriorityHigh && l.rate > 0 {
+		l.refill(time.Now())
+		l.tokens -= float64(n)
+		return
+	}
+	for n > 0 && l.rate > 0 {
+		l.refill(time.Now())
+
+		chunk := min(float64(n), l.burst())
+		if l.tokens >= chunk {
+			l.tokens -= chunk
+			n -= int(chunk)
+			continue
+		}
+
+		// ждем, пока накопятся токены, в том числе после долга сбросов
+		wait := time.Duration((chunk - l.tokens) / float64(l.rate) * float64(time.Second))
+		wait = min(max(wait, time.Millisecond), refillPeriod)
+
+		l.mu.Unlock()
+		time.Sleep(wait)
+		l.mu.Lock()
+	}
+}
+
+// refill добавляет токены, накопленные с прошлого пополнения.
+func (l *Limiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if b := l.burst(); l.tokens > b {
+		l.tokens = b
+	}
+	l.last = now
+}
+
+// burst возвращает емкость корзины.
+func (l *Limiter) burst() float64 {
+	return max(float64(l.rate)*refillPeriod.Seconds(), 1)
+}
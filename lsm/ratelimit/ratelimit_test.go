@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestRate(t *testing.T) {
+	l := New(100 << 10) // 100 KB/s, корзина 10 KB
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		l.Request(4<<10, PriorityLow)
+	}
+	// первые 10 KB из корзины, остальные 30 KB ~300ms
+	if d := time.Since(start); d < 250*time.Millisecond || d > time.Second {
+		t.Fatalf("40 KB at 100 KB/s took %s", d)
+	}
+	if n := l.Total(PriorityLow); n != 40<<10 {
+		t.Fatalf("total %d != %d", n, 40<<10)
+	}
+
+	l.SetBytesPerSecond(0)
+	start = time.Now()
+	l.Request(1<<30, PriorityLow)
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Fatalf("unlimited request took %s", d)
+	}
+
+	var nl *Limiter
+	nl.Request(1<<30, PriorityHigh)
+}
+
+func TestRequestHighPriorityNotDelayed(t *testing.T) {
+	l := New(100 << 10) // 100 KB/s
+
+	// сброс не ждет токенов, а берет их в долг
+	start := time.Now()
+	l.Request(30<<10, PriorityHigh)
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Fatalf("high priority request took %s", d)
+	}
+
+	// уплотнение ждет, пока долг не будет погашен: ~300ms
+	start = time.Now()
+	l.Request(1<<10, PriorityLow)
+	if d := time.Since(start); d < 250*time.Millisecond || d > time.Second {
+		t.Fatalf("low priority request after 30 KB of debt took %s", d)
+	}
+	if n := l.Total(PriorityHigh); n != 30<<10 {
+		t.Fatalf("total %d != %d", n, 30<<10)
+	}
+}
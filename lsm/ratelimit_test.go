@@ -0,0 +1,48 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/wubba-com/lsm-distributed/lsm/ratelimit"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := ratelimit.New(0)
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20), RateLimiter(limiter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	l.SetMergeSettings(MergeSettings{MaxLevels: 3})
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 10; j++ {
+			key := []byte(fmt.Sprintf("key-%d-%d", i, j))
+			if err := l.Put(key, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		l.lock.Lock()
+		err := l.flushMemTable()
+		l.lock.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	flushed := limiter.Total(ratelimit.PriorityHigh)
+	if flushed == 0 {
+		t.Fatal("flush is not rate limited")
+	}
+
+	if err := l.def.compact(sst.BaseLevel); err != nil {
+		t.Fatal(err)
+	}
+	if n := limiter.Total(ratelimit.PriorityLow); n == 0 {
+		t.Fatal("compaction is not rate limited")
+	}
+	if n := limiter.Total(ratelimit.PriorityHigh); n != flushed {
+		t.Fatalf("compaction requested %d bytes at high priority", n-flushed)
+	}
+}
@@ -0,0 +1,228 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/wubba-com/lsm-distributed/lsm/blob"
+	"github.com/wubba-com/lsm-distributed/lsm/memtable"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+	"github.com/wubba-com/lsm-distributed/lsm/wal"
+)
+
+// Число попыток TryCatchUpWithPrimary прочитать состояние основного
+// экземпляра, не измененное сбросом MemTable или уплотнением.
+const catchUpAttempts = 10
+
+// Пауза перед повторной попыткой, удваивается с каждой попыткой, чтобы
+// попытки не пришлись на запись одной таблицы основным экземпляром.
+const catchUpBackoff = time.Millisecond
+
+// OpenReadOnly opens the db for reading only. Unlike Open, it never writes to
+// the directory and starts no background jobs: the tables left on disk are
+// loaded, and the WAL is replayed into the MemTable in memory. The db may be
+// opened read-only by any number of instances, but it must not be opened by
+// a primary instance at the same time; use OpenAsSecondary for that.
+// All writes return ErrReadOnly.
+func OpenReadOnly(path string, options ...func(*LSMTree)) (*LSMTree, error) {
+	return openReadOnly(path, false, options...)
+}
+
+// OpenAsSecondary opens the db for reading only, like OpenReadOnly, while the
+// primary instance keeps writing to it. The secondary instance sees the state
+// of the primary at the time of opening; TryCatchUpWithPrimary refreshes it.
+func OpenAsSecondary(path string, options ...func(*LSMTree)) (*LSMTree, error) {
+	return openReadOnly(path, true, options...)
+}
+
+func openReadOnly(root string, secondary bool, options ...func(*LSMTree)) (*LSMTree, error) {
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+
+	w, err := wal.OpenReadOnly(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL of %s: %w", root, err)
+	}
+
+	t := newTree(root, w, options...)
+	t.readOnly, t.secondary = true, secondary
+
+	for _, cf := range t.cfs {
+		if _, err := os.Stat(cf.root); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := cf.loadLevels(); err != nil {
+			return nil, fmt.Errorf("failed to load levels of column family %s: %w", cf.name, err)
+		}
+
+		dir := path.Join(cf.root, blobDir)
+		if _, err := os.Stat(dir); err == nil {
+			if cf.blobs, err = blob.OpenReadOnly(dir); err != nil {
+				return nil, fmt.Errorf("failed to open blob files of column family %s: %w", cf.name, err)
+			}
+		}
+	}
+
+	if err := w.Replay(t.replay); err != nil {
+		return nil, fmt.Errorf("failed to load mem from %s: %w", w.Path(), err)
+	}
+
+	return t, nil
+}
+
+// TryCatchUpWithPrimary loads the tables and the WAL written by the primary
+// instance since the secondary one was opened or caught up last time.
+//
+// The primary instance may flush the MemTable or compact tables while they are
+// read, then the state is read again after a short pause. If it keeps changing,
+// an error is returned and the secondary instance keeps the previous state.
+// A WAL record the primary instance is still writing is not loaded until the
+// next catch-up. Reads of the secondary instance may also fail, if the primary
+// one removed the tables after compaction; TryCatchUpWithPrimary should be
+// called again then.
+func (t *LSMTree) TryCatchUpWithPrimary() error {
+	if !t.secondary {
+		return fmt.Errorf("db is not opened as secondary")
+	}
+
+	for attempt := 0; attempt < catchUpAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(catchUpBackoff << (attempt - 1))
+		}
+
+		ok, err := t.tryCatchUp()
+		if err != nil || ok {
+			return err
+		}
+	}
+
+	return fmt.Errorf("primary changed during %d attempts to catch up", catchUpAttempts)
+}
+
+// tryCatchUp делает одну попытку catchUp под блокировкой дерева.
+func (t *LSMTree) tryCatchUp() (bool, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.closed {
+		return false, ErrClosed
+	}
+
+	return t.catchUp()
+}
+
+// catchUp читает уровни и WAL основного экземпляра. Состояние применяется,
+// только если за время чтения не изменились номер последовательности WAL
+// и список таблиц, т.е. основной экземпляр не начал и не закончил сброс
+// MemTable или уплотнение. Иначе возвращается false, и чтение повторяется.
+func (t *LSMTree) catchUp() (bool, error) {
+	seq, err := t.wal.ReadSequence()
+	if err != nil {
+		return false, err
+	}
+
+	levels := make(map[*ColumnFamily][]sst.SSTLevel, len(t.cfs))
+	for _, cf := range t.cfs {
+		if _, err := os.Stat(cf.root); os.IsNotExist(err) {
+			levels[cf] = []sst.SSTLevel{{}}
+			continue
+		}
+		if levels[cf], err = cf.readLevels(); err != nil {
+			// основной экземпляр пишет или удаляет таблицу
+			for _, lvls := range levels {
+				closeTables(lvls)
+			}
+			return false, nil
+		}
+	}
+
+	// WAL применяется к новым MemTable, прежние возвращаются при ошибке
+	mems := make(map[*ColumnFamily]*memtable.Memtable, len(t.cfs))
+	for _, cf := range t.cfs {
+		mems[cf], cf.mem = cf.mem, memtable.NewMem()
+	}
+	var restore = func() {
+		for cf, mem := range mems {
+			cf.mem = mem
+		}
+		for _, lvls := range levels {
+			closeTables(lvls)
+		}
+	}
+
+	// WAL, очищенный сбросом во время чтения, читается как поврежденный:
+	// ошибка возвращается, только если основной экземпляр ничего не менял
+	replayErr := t.wal.Replay(t.replay)
+
+	after, err := t.wal.ReadSequence()
+	if err != nil || after != seq {
+		restore()
+		return false, err
+	}
+
+	// сброс, взявший номер до первого чтения, мог записать таблицу
+	// после чтения уровней и очистить WAL до его чтения
+	for cf, lvls := range levels {
+		changed, err := cf.tablesChanged(lvls)
+		if err != nil || changed {
+			restore()
+			return false, err
+		}
+	}
+
+	if replayErr != nil {
+		restore()
+		return false, fmt.Errorf("failed to load mem from %s: %w", t.wal.Path(), replayErr)
+	}
+
+	for cf, lvls := range levels {
+		closeTables(cf.levels)
+		cf.levels = lvls
+
+		dir := path.Join(cf.root, blobDir)
+		if cf.blobs == nil {
+			if _, err := os.Stat(dir); err == nil {
+				if cf.blobs, err = blob.OpenReadOnly(dir); err != nil {
+					return false, err
+				}
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// tablesChanged сообщает, отличается ли список таблиц column family
+// на диске от таблиц уровней levels.
+func (cf *ColumnFamily) tablesChanged(levels []sst.SSTLevel) (bool, error) {
+	var loaded []sst.LevelFile
+	for _, level := range levels {
+		for _, file := range level.Files {
+			loaded = append(loaded, sst.LevelFile{Level: file.Level, SeqNum: file.SeqNum, Ext: sst.ExtBin})
+		}
+	}
+
+	if _, err := os.Stat(cf.root); os.IsNotExist(err) {
+		return len(loaded) > 0, nil
+	}
+	lvls, err := sst.Levels(cf.root)
+	if err != nil {
+		return false, err
+	}
+
+	var files []sst.LevelFile
+	for _, lvl := range lvls {
+		lvlFiles, err := sst.Filename(cf.root, lvl)
+		if err != nil {
+			return false, err
+		}
+		files = append(files, lvlFiles...)
+	}
+
+	return !slices.Equal(files, loaded), nil
+}
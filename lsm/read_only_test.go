@@ -0,0 +1,290 @@
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+// listFiles возвращает пути и размеры всех файлов каталога.
+func listFiles(t *testing.T, dir string) map[string]int64 {
+	files := make(map[string]int64)
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		files[name] = info.Size()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	large := bytes.Repeat([]byte("l"), 128)
+
+	l, err := Open(dir, MemTableThreshold(1<<20), BlobThreshold(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put([]byte("flushed"), large); err != nil {
+		t.Fatal(err)
+	}
+	l.lock.Lock()
+	err = l.flushMemTable()
+	l.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put([]byte("wal"), []byte("wal")); err != nil {
+		t.Fatal(err)
+	}
+	l.Shutdown()
+	l.Close()
+
+	before := listFiles(t, dir)
+
+	r, err := OpenReadOnly(dir, BlobThreshold(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _, err := r.Get([]byte("flushed")); err != nil || !bytes.Equal(v, large) {
+		t.Fatalf("flushed = %s: %v", v, err)
+	}
+	if v, _, err := r.Get([]byte("wal")); err != nil || !bytes.Equal(v, []byte("wal")) {
+		t.Fatalf("wal = %s: %v", v, err)
+	}
+
+	if err := r.Put([]byte("a"), []byte("a")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("put err %v != %v", err, ErrReadOnly)
+	}
+	if err := r.DeleteRange([]byte("a"), []byte("z")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("delete range err %v != %v", err, ErrReadOnly)
+	}
+	txn := r.BeginTxn()
+	txn.Put(nil, []byte("a"), []byte("a"))
+	if err := txn.Commit(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("commit err %v != %v", err, ErrReadOnly)
+	}
+	if err := r.GarbageCollectBlobs(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("gc err %v != %v", err, ErrReadOnly)
+	}
+	if err := r.TryCatchUpWithPrimary(); err == nil {
+		t.Fatal("read-only db caught up with primary")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	after := listFiles(t, dir)
+	if len(before) != len(after) {
+		t.Fatalf("files %v != %v", after, before)
+	}
+	for name, size := range before {
+		if after[name] != size {
+			t.Fatalf("file %s changed: %d != %d", name, after[name], size)
+		}
+	}
+}
+
+func TestOpenAsSecondary(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir, MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	l.SetMergeSettings(MergeSettings{MaxLevels: 3})
+
+	var flush = func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if err := l.flushMemTable(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := l.Put([]byte("a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	flush()
+
+	s, err := OpenAsSecondary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if v, _, err := s.Get([]byte("a")); err != nil || !bytes.Equal(v, []byte("a")) {
+		t.Fatalf("a = %s: %v", v, err)
+	}
+
+	for _, key := range []string{"b", "c"} {
+		if err := l.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		flush()
+	}
+	if _, _, err := s.Get([]byte("b")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("b before catch up: %v", err)
+	}
+
+	if err := l.def.compact(sst.BaseLevel); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put([]byte("d"), []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	it, err := s.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.HasNext() {
+		k, _, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, string(k))
+	}
+	if !slices.Equal(keys, []string{"a", "b", "c", "d"}) {
+		t.Fatalf("keys of secondary %v", keys)
+	}
+
+	if err := s.Put([]byte("e"), []byte("e")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("put err %v != %v", err, ErrReadOnly)
+	}
+}
+
+func TestCatchUpWithTableInProgress(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir, MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	var flush = func(key string) {
+		if err := l.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if err := l.flushMemTable(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush("a")
+
+	s, err := OpenAsSecondary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// таблица, которую основной экземпляр еще пишет, не читается
+	// и не считается ошибкой: чтение повторяется
+	partial := sst.PathBy(l.def.root, sst.BaseLevel, 1<<20)
+	if err := os.WriteFile(partial, []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TryCatchUpWithPrimary(); err == nil || errors.Is(err, ErrCorruption) {
+		t.Fatalf("catch up with table in progress: %v", err)
+	}
+	if v, _, err := s.Get([]byte("a")); err != nil || !bytes.Equal(v, []byte("a")) {
+		t.Fatalf("a after failed catch up = %s: %v", v, err)
+	}
+	if err := sst.Remove(l.def.root, sst.BaseLevel, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.def.tablesChanged(s.def.levels); err != nil || changed {
+		t.Fatalf("tables changed %t after catch up: %v", changed, err)
+	}
+
+	// сброс после чтения уровней меняет список таблиц
+	flush("b")
+	if changed, err := s.def.tablesChanged(s.def.levels); err != nil || !changed {
+		t.Fatalf("tables changed %t after flush: %v", changed, err)
+	}
+}
+
+func TestCatchUpDuringWrites(t *testing.T) {
+	const n = 3000
+	dir := t.TempDir()
+
+	l, err := Open(dir, MemTableThreshold(32<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	if err := l.Put([]byte("key-00000"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenAsSecondary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// запись WAL, дописываемая основным экземпляром, не ошибка
+	done := make(chan error)
+	go func() {
+		for i := 1; i < n; i++ {
+			if err := l.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte("value")); err != nil {
+				done <- err
+				return
+			}
+		}
+		close(done)
+	}()
+
+	var catchUps int
+	for writing := true; writing; catchUps++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			writing = false
+		default:
+		}
+		if err := s.TryCatchUpWithPrimary(); err != nil {
+			t.Fatalf("catch up %d: %v", catchUps, err)
+		}
+	}
+
+	it, err := s.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys int
+	for ; it.HasNext(); keys++ {
+		if _, _, err := it.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keys != n {
+		t.Fatalf("secondary has %d keys after %d catch-ups, want %d", keys, catchUps, n)
+	}
+}
@@ -1,24 +1,58 @@
 package sst
 
 import (
+	"fmt"
 	"io"
 	"os"
 )
 
-func readSparseHeader(r io.Reader) (Header, error) {
-	var (
-		seqNum uint64
-		err    error
-	)
-	if seqNum, err = readUint64(r); err != nil {
+// footerSize is the size of the sparse index file footer:
+// [keys uint32][seq uint64][offsets pos uint32][meta pos uint32][range tombstones uint32]
+const footerSize = 6 * minBytes
+
+// footerSeqPos is the position of the sequence number in the footer.
+const footerSeqPos = minBytes
+
+// meta is the decoded meta block of the sparse index file.
+type meta struct {
+	Smallest, Largest []byte
+	RangeDels         []RangeTombstone
+}
+
+func readSparseHeader(r io.ReaderAt, size int64) (Header, error) {
+	if size < footerSize {
+		return Header{}, fmt.Errorf("%w: size %d less than footer %d", ErrCorruption, size, footerSize)
+	}
+
+	var buf [footerSize]byte
+	if _, err := r.ReadAt(buf[:], size-footerSize); err != nil {
 		return Header{}, err
 	}
 
 	return Header{
-		Seq: seqNum,
+		Keys:      decodeUInt32(buf[0:4]),
+		Seq:       decodeUInt64(buf[4:12]),
+		SparseEnd: int64(decodeUInt32(buf[12:16])),
+		MetaPos:   int64(decodeUInt32(buf[16:20])),
+		RangeDels: decodeUInt32(buf[20:24]),
+		MetaEnd:   size - footerSize,
 	}, nil
 }
 
+func readSparseHeaderFile(f *os.File) (Header, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return Header{}, err
+	}
+
+	header, err := readSparseHeader(f, stat.Size())
+	if err != nil {
+		return Header{}, Corrupted(f.Name(), 0, err)
+	}
+
+	return header, nil
+}
+
 func readCountKeysFile(filepath string) (int, error) {
 	f, err := os.OpenFile(filepath, os.O_RDONLY, os.FileMode(0600))
 	if err != nil {
@@ -26,18 +60,25 @@ func readCountKeysFile(filepath string) (int, error) {
 	}
 	defer f.Close()
 
-	return readCountKeys(f)
+	count, err := readCountKeys(f)
+	if err != nil {
+		return 0, Corrupted(f.Name(), 0, err)
+	}
+
+	return count, nil
 }
 
 func readCountKeys(r io.Reader) (int, error) {
-	var count int
-
-	var err error
+	var (
+		count int
+		pos   int64
+	)
 	for {
-		_, _, err = Decode(r)
+		k, v, err := Decode(r)
 		if err != nil && err != io.EOF {
-			return 0, err
+			return 0, Corrupted("", pos, err)
 		}
+		pos += recordSize(k, v)
 
 		if err == io.EOF {
 			return count, nil
@@ -47,27 +88,35 @@ func readCountKeys(r io.Reader) (int, error) {
 	}
 }
 
-func readSparseIndex(r io.Reader) ([]SSTIndex, Header, error) {
+func readSparseIndex(f *os.File) ([]SSTIndex, Header, error) {
 	var idxs []SSTIndex
 
-	header, err := readSparseHeader(r)
+	header, err := readSparseHeaderFile(f)
 	if err != nil {
 		return nil, Header{}, err
 	}
 
+	r := io.NewSectionReader(f, 0, header.SparseEnd)
+	var pos int64
 	for {
 		k, v, err := Decode(r)
 		if err != nil && err != io.EOF {
-			return nil, Header{}, err
+			return nil, Header{}, Corrupted(f.Name(), pos, err)
 		}
 		if err == io.EOF {
 			return idxs, header, nil
 		}
 
-		offset := decodeUInt64(v)
+		offset, restarts, err := decodeSparseValue(v)
+		if err != nil {
+			return nil, Header{}, Corrupted(f.Name(), pos, err)
+		}
+		pos += recordSize(k, v)
+
 		idxs = append(idxs, SSTIndex{
-			Key:    k,
-			Offset: int(offset),
+			Key:      k,
+			Offset:   offset,
+			Restarts: restarts,
 		})
 	}
 }
@@ -81,3 +130,48 @@ func readSparseIndexFile(filepath string) ([]SSTIndex, Header, error) {
 
 	return readSparseIndex(f)
 }
+
+// readMeta reads the meta block: the key range of the table
+// followed by the range tombstones.
+func readMeta(r io.ReaderAt, header Header) (meta, error) {
+	sr := io.NewSectionReader(r, header.MetaPos, header.MetaEnd-header.MetaPos)
+
+	smallest, largest, err := Decode(sr)
+	if err != nil {
+		return meta{}, fmt.Errorf("failed to read key range: %w", Corrupted("", header.MetaPos, err))
+	}
+
+	m := meta{Smallest: smallest, Largest: largest}
+	pos := header.MetaPos + recordSize(smallest, largest)
+	for i := uint32(0); i < header.RangeDels; i++ {
+		start, end, err := Decode(sr)
+		if err != nil {
+			return meta{}, fmt.Errorf("failed to read range tombstone %d: %w", i, Corrupted("", pos, err))
+		}
+		pos += recordSize(start, end)
+
+		m.RangeDels = append(m.RangeDels, RangeTombstone{Start: start, End: end})
+	}
+
+	return m, nil
+}
+
+func readMetaFile(filepath string) (Header, meta, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return Header{}, meta{}, err
+	}
+	defer f.Close()
+
+	header, err := readSparseHeaderFile(f)
+	if err != nil {
+		return Header{}, meta{}, err
+	}
+
+	m, err := readMeta(f, header)
+	if err != nil {
+		return Header{}, meta{}, Corrupted(f.Name(), 0, err)
+	}
+
+	return header, m, nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 )
 
 // encode encodes key and value and writes it to the specified writer.
@@ -47,55 +48,58 @@ func Encode(w io.Writer, key []byte, value []byte) (int, error) {
 	return bytes, nil
 }
 
+// maxRecordSize is the maximum size of a record without the length prefix.
+// Offsets in tables are 32-bit, so no valid record is larger; a larger
+// length is corrupted.
+const maxRecordSize = math.MaxUint32
+
+// Records larger than allocChunk are read into a growing buffer, so a corrupted
+// length does not allocate more memory than the reader actually has.
+const allocChunk = 64 << 10
+
 // decode decodes key and value by reading from the specified reader.
 // Returns the number of bytes read and error if occurred.
 // The function must be compatible with encode: encode(decode(v)) == v.
+// A truncated or malformed record returns ErrCorruption.
 func Decode(r io.Reader) ([]byte, []byte, error) {
 	// encoding format:
 	// [encoded total length in bytes][encoded key length in bytes][key][value]
 
 	var encdodedTotalLen [8]byte
-	if n, err := r.Read(encdodedTotalLen[:]); err != nil {
+	if n, err := io.ReadFull(r, encdodedTotalLen[:]); err == io.ErrUnexpectedEOF {
+		return nil, nil, fmt.Errorf("%w: failed to read entry %d < %d", ErrCorruption, n, len(encdodedTotalLen))
+	} else if err != nil {
 		return nil, nil, err
-	} else {
-		if n < len(encdodedTotalLen) {
-			return nil, nil, fmt.Errorf("the file is corrupted, failed to read entry %d < %d", n, len(encdodedTotalLen))
-		}
 	}
 
 	bytes := decodeUInt64(encdodedTotalLen[:])
-	buf := make([]byte, bytes)
-
-	// var (
-	// 	n   int
-	// 	err error
-	// )
-	// for {
-	// 	var buf = make([]byte, 4<<10)
-	// 	var nn int
-	// 	nn, err = r.Read(buf)
-	// 	if err != nil {
-	// 		if err != io.EOF {
-	// 			return nil, nil, err
-	// 		}
-	// 		break
-	// 	}
-
-	// 	copy(encodedEntry[n:], buf[:nn])
-	// 	n += nn
-	// }
-	n, err := io.ReadFull(r, buf)
-
-	if uint64(n) < bytes {
-		return nil, nil, fmt.Errorf("the file is corrupted, failed to read entry %d < %d", n, bytes)
+	if bytes < 8 || bytes > maxRecordSize {
+		return nil, nil, fmt.Errorf("%w: entry of %d bytes", ErrCorruption, bytes)
+	}
+
+	var buf []byte
+	if bytes <= allocChunk {
+		buf = make([]byte, bytes)
+		if n, err := io.ReadFull(r, buf); err != nil {
+			return nil, nil, fmt.Errorf("%w: failed to read entry %d < %d", ErrCorruption, n, bytes)
+		}
+	} else {
+		var b bufferWriter
+		if n, err := io.CopyN(&b, r, int64(bytes)); err != nil {
+			return nil, nil, fmt.Errorf("%w: failed to read entry %d < %d", ErrCorruption, n, bytes)
+		}
+		buf = b
 	}
 
 	keyLen := decodeUInt64(buf[0:8])
+	if keyLen > bytes-8 {
+		return nil, nil, fmt.Errorf("%w: key of %d bytes in entry of %d bytes", ErrCorruption, keyLen, bytes)
+	}
 	key := buf[8 : 8+keyLen]
 	keyPartLen := 8 + keyLen
 
 	if int(keyPartLen) == len(buf) {
-		return key, nil, err
+		return key, nil, nil
 	}
 
 	valueStart := keyPartLen
@@ -104,6 +108,14 @@ func Decode(r io.Reader) ([]byte, []byte, error) {
 	return key, value, nil
 }
 
+// bufferWriter накапливает записанные байты, увеличиваясь по мере записи.
+type bufferWriter []byte
+
+func (b *bufferWriter) Write(p []byte) (int, error) {
+	*b = append(*b, p...)
+	return len(p), nil
+}
+
 // encodeKeyOffset encodes key offset and writes it to the given writer.
 func EncodeKeyOffset(w io.Writer, key []byte, offset int) (int, error) {
 	return Encode(w, key, encodeUInt64(uint64(offset)))
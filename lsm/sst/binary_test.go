@@ -2,7 +2,9 @@ package sst
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/rand"
+	"errors"
 	"io"
 	"log"
 	"os"
@@ -354,3 +356,44 @@ func Test_EncodeDecode(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeCorrupted(t *testing.T) {
+	var record = func(total, keyLen uint64, rest int) []byte {
+		b := append(encodeUInt64(total), encodeUInt64(keyLen)...)
+		return append(b, make([]byte, rest)...)
+	}
+	var buf bytes.Buffer
+	if _, err := Encode(&buf, []byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	for _, tt := range []struct {
+		name string
+		b    []byte
+	}{
+		{"truncated length", valid[:3]},
+		{"truncated key length", valid[:12]},
+		{"truncated value", valid[:len(valid)-1]},
+		{"zero length", record(0, 0, 0)},
+		{"length less than key length field", encodeUInt64(5)},
+		{"huge length", record(1<<62, 3, 8)},
+		{"length beyond 32 bits", record(1<<32+8, 3, 8)},
+		{"large length with little data", record(1<<31, 3, 1<<10)},
+		{"key beyond record", record(16, 9, 8)},
+		{"huge key length", record(16, 1<<63, 8)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Decode(bytes.NewReader(tt.b)); !errors.Is(err, ErrCorruption) {
+				t.Fatalf("got %v, want %v", err, ErrCorruption)
+			}
+		})
+	}
+
+	if _, _, err := Decode(bytes.NewReader(nil)); err != io.EOF {
+		t.Fatalf("got %v for empty reader, want %v", err, io.EOF)
+	}
+	if k, v, err := Decode(bytes.NewReader(valid)); err != nil || string(k) != "key" || string(v) != "value" {
+		t.Fatalf("got %q %q %v", k, v, err)
+	}
+}
@@ -0,0 +1,123 @@
+package sst
+
+import (
+	"container/list"
+	"sync"
+)
+
+// BlockCache is an LRU cache of decoded blocks of tables, such as the index
+// and filter partitions. The total size of the cached blocks is limited by
+// the capacity. It is safe for concurrent use. A nil *BlockCache caches nothing.
+type BlockCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	ll       *list.List
+	items    map[blockKey]*list.Element
+
+	hits, misses uint64
+}
+
+// blockKey идентифицирует блок по пути файла и позиции в нем.
+type blockKey struct {
+	path string
+	pos  int64
+}
+
+type cacheEntry struct {
+	key    blockKey
+	value  any
+	charge int64
+}
+
+// NewBlockCache creates a block cache of the capacity in bytes.
+func NewBlockCache(capacity int64) *BlockCache {
+	return &BlockCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[blockKey]*list.Element),
+	}
+}
+
+// get возвращает блок из кэша и делает его последним использованным.
+func (c *BlockCache) get(key blockKey) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.ll.MoveToFront(e)
+
+	return e.Value.(*cacheEntry).value, true
+}
+
+// add добавляет блок размером charge байт и вытесняет давно не
+// использованные блоки, пока размер кэша больше емкости. Блок больше
+// емкости не кэшируется.
+func (c *BlockCache) add(key blockKey, value any, charge int64) {
+	if c == nil || charge > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, value: value, charge: charge})
+	c.size += charge
+
+	for c.size > c.capacity {
+		e := c.ll.Back()
+		entry := e.Value.(*cacheEntry)
+		c.ll.Remove(e)
+		delete(c.items, entry.key)
+		c.size -= entry.charge
+	}
+}
+
+// Size returns the total size of the cached blocks in bytes.
+func (c *BlockCache) Size() int64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// Len returns the number of the cached blocks.
+func (c *BlockCache) Len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+// Stats returns the numbers of lookups of blocks found and not found in the cache.
+func (c *BlockCache) Stats() (hits, misses uint64) {
+	if c == nil {
+		return 0, 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hits, c.misses
+}
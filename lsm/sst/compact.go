@@ -3,7 +3,8 @@ package sst
 import (
 	"bytes"
 	"container/heap"
-	"os"
+	"io"
+	"path/filepath"
 
 	"github.com/wubba-com/lsm-distributed/lsm/bloom"
 	"github.com/wubba-com/lsm-distributed/lsm/encoder"
@@ -27,109 +28,217 @@ func pop(h *Heap) *Node {
 	return heap.Pop(h).(*Node)
 }
 
-func Compact(dirname string, files []LevelFile, level Level, size uint32, sparseKeyDistance int32, removed bool) (SSTFile, error) {
+// seqRangeTombstone is a range tombstone of the table with the sequence number.
+type seqRangeTombstone struct {
+	RangeTombstone
+	seqNum uint64
+}
+
+// coveredBy tells if the key of the table with the sequence number seqNum
+// is deleted by a range tombstone of a newer table.
+func coveredBy(rds []seqRangeTombstone, key []byte, seqNum uint64) bool {
+	for _, rd := range rds {
+		if rd.seqNum > seqNum && rd.Contains(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Compact merges the files into the next level. Keys deleted by range
+// tombstones of newer files are dropped, files fully covered by a range
+// tombstone of a newer file are not read at all. If removed is true,
+// tombstones are dropped as well. Each output file gets the sequence
+// number from nextSeq and a filter built by the policy for the total number
+// of keys of the input files, or no filter if the policy is nil, for example,
+// if the filters are written to the partitions of tables. The options are
+// applied to the writers of output files.
+func Compact(dirname string, files []LevelFile, level Level, size uint32, sparseKeyDistance int32, policy bloom.FilterPolicy, removed bool, nextSeq func() (uint64, error), options ...OptionWriter) ([]SSTFile, error) {
 	hp := &Heap{}
 	heap.Init(hp)
 	level += 1
 	var (
-		maxSeqNum uint64 = 0
 		countKeys int
+		closers   []io.Closer
+		metas     = make([]meta, len(files))
+		headers   = make([]Header, len(files))
+		rangeDels []seqRangeTombstone
 	)
+	defer func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}()
 
-	for idxFile := range files {
-		binFile, idxFile, sparseFile, err := OpenBy(dirname, files[idxFile].Level, files[idxFile].SeqNum, os.O_RDONLY)
+	for i := range files {
+		h, m, err := readMetaFile(tablePath(dirname, files[i], ExtSparse))
 		if err != nil {
-			return SSTFile{}, err
+			return nil, err
 		}
-		defer idxFile.Close()
-		defer sparseFile.Close()
 
-		it, err := NewIterator(binFile)
-		if err != nil {
-			if errClose := binFile.Close(); errClose != nil {
-				return SSTFile{}, errClose
-			}
+		headers[i], metas[i] = h, m
+		for _, rd := range m.RangeDels {
+			rangeDels = append(rangeDels, seqRangeTombstone{RangeTombstone: rd, seqNum: h.Seq})
+		}
+	}
 
-			return SSTFile{}, err
+	for i := range files {
+		if covered(rangeDels, metas[i], headers[i].Seq) {
+			continue
 		}
-		defer it.CLose()
 
-		c, err := readCountKeys(idxFile)
+		c, err := readCountKeysFile(tablePath(dirname, files[i], ExtIdx))
 		if err != nil {
-			return SSTFile{}, err
+			return nil, err
 		}
 
-		countKeys = c
+		// фильтр выходной таблицы рассчитывается на ключи всех входных
+		countKeys += c
 
-		_, header, err := readSparseIndex(sparseFile)
+		it, err := NewFileIterator(tablePath(dirname, files[i], ExtBin))
 		if err != nil {
-			return SSTFile{}, err
+			return nil, err
 		}
+		closers = append(closers, it.fd)
 
-		if header.Seq > maxSeqNum {
-			maxSeqNum = header.Seq
+		push(hp, &iterator{it: it, seqNum: headers[i].Seq})
+	}
+	if hp.Len() == 0 && (len(rangeDels) == 0 || removed) {
+		return nil, nil
+	}
+
+	var (
+		wr     *Writer
+		filter bloom.FilterBuilder
+		lvls   []SSTFile
+	)
+	var newWriter = func() error {
+		seqNum, err := nextSeq()
+		if err != nil {
+			return err
 		}
 
-		push(hp, &iterator{it: it, seqNum: header.Seq})
-	}
-	if hp.Len() == 0 {
-		return SSTFile{}, nil
+		opts := append([]OptionWriter{AtLevel(level), SeqNum(seqNum), SparseKeyDistance(sparseKeyDistance)}, options...)
+		if wr, err = NewWriter(dirname, opts...); err != nil {
+			return err
+		}
+		if policy != nil {
+			filter = policy.NewBuilder(countKeys)
+		}
+
+		if len(lvls) == 0 && !removed {
+			// all range tombstones go to the first file of the level,
+			// so any entry of the level is newer than them
+			for _, rd := range rangeDels {
+				wr.AddRangeDel(rd.Start, rd.End)
+			}
+		}
+
+		return nil
 	}
+	var closeWriter = func() error {
+		if err := wr.AddIdxBlock(); err != nil {
+			return err
+		}
+		if err := wr.Close(); err != nil {
+			return err
+		}
 
-	wr, err := NewWriter(dirname)
-	if err != nil {
-		return SSTFile{}, err
+		var f bloom.KeyFilter
+		if filter != nil {
+			f = filter.Finish()
+		}
+		meta, err := NewMemMetaSST(wr.NameSparseFile(), level, f)
+		if err != nil {
+			return err
+		}
+		lvls = append(lvls, meta)
+
+		return nil
 	}
+
 	decoder := encoder.NewDecoder()
-	filter := bloom.New(countKeys, 100)
 
-	var write = func(key, val []byte, removed bool) error {
-		if decoder.Decode(val).IsTombstone() && removed {
+	var write = func(node *Node, removed bool) error {
+		if coveredBy(rangeDels, node.SST.Key, node.Seq) {
+			return nil
+		}
+		if decoder.Decode(node.SST.Val).IsTombstone() && removed {
 			return nil
 		}
-		filter.Add(string(key))
 
-		if wr.Bytes() > int(size) {
-			wr = NewWriter(dirname, level)
+		if wr != nil && wr.Bytes() > int(size) {
+			if err := closeWriter(); err != nil {
+				return err
+			}
+			wr = nil
+		}
+		if wr == nil {
+			if err := newWriter(); err != nil {
+				return err
+			}
+		}
+		if filter != nil {
+			filter.AddKey(node.SST.Key)
 		}
 
-		return wr.Write(key, val)
+		return wr.Write(node.SST.Key, node.SST.Val)
 	}
 
-	var (
-		cur  = pop(hp)
-		next *Node
-	)
-	push(hp, cur.It)
-
-	for hp.Len() > 0 {
-		next = pop(hp)
-		push(hp, next.It)
-		if cur != nil && bytes.Equal(cur.SST.Key, next.SST.Key) {
-			if next.Seq > cur.Seq {
-				cur = next
+	if hp.Len() > 0 {
+		var (
+			cur  = pop(hp)
+			next *Node
+		)
+		push(hp, cur.It)
+
+		for hp.Len() > 0 {
+			next = pop(hp)
+			push(hp, next.It)
+			if cur != nil && bytes.Equal(cur.SST.Key, next.SST.Key) {
+				if next.Seq > cur.Seq {
+					cur = next
+				}
+				continue
 			}
-			continue
+			if err := write(cur, removed); err != nil {
+				return nil, err
+			}
+
+			cur = next
 		}
-		if err := write(cur.SST.Key, cur.SST.Val, removed); err != nil {
-			return SSTFile{}, err
+		if err := write(cur, removed); err != nil {
+			return nil, err
 		}
+	}
 
-		cur = next
+	if wr == nil && len(lvls) == 0 && len(rangeDels) > 0 && !removed {
+		// all keys are deleted, but the lower levels still need the range tombstones
+		if err := newWriter(); err != nil {
+			return nil, err
+		}
 	}
-	if err := write(cur.SST.Key, cur.SST.Val, removed); err != nil {
-		return SSTFile{}, err
+	if wr != nil {
+		if err := closeWriter(); err != nil {
+			return nil, err
+		}
 	}
 
-	lvl := SSTFile{
-		Level:  level,
-		SeqNum: maxSeqNum,
-		Filter: filter,
-	}
+	return lvls, nil
+}
 
-	if err := wr.Close(); err != nil {
-		return SSTFile{}, err
+// covered tells if all keys of the table are deleted by a range tombstone of a newer table.
+func covered(rds []seqRangeTombstone, m meta, seqNum uint64) bool {
+	for _, rd := range rds {
+		if rd.seqNum > seqNum && rd.ContainsRange(m.Smallest, m.Largest) {
+			return true
+		}
 	}
 
-	return lvl, nil
+	return false
+}
+
+func tablePath(dirname string, file LevelFile, ext string) string {
+	return filepath.Join(dirname, nameBy(file.Level, file.SeqNum, ext))
 }
@@ -0,0 +1,69 @@
+package sst
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/wubba-com/lsm-distributed/lsm/bloom"
+	"github.com/wubba-com/lsm-distributed/lsm/encoder"
+)
+
+// countingPolicy запоминает число ключей, на которое рассчитываются фильтры.
+type countingPolicy struct {
+	bloom.FilterPolicy
+	n *int
+}
+
+func (p countingPolicy) NewBuilder(n int) bloom.FilterBuilder {
+	*p.n = n
+	return p.FilterPolicy.NewBuilder(n)
+}
+
+func TestCompactFilterKeys(t *testing.T) {
+	dir := t.TempDir()
+	enc := encoder.NewEncoder()
+
+	var files []LevelFile
+	for seq := uint64(1); seq <= 3; seq++ {
+		w, err := NewWriter(dir, AtLevel(BaseLevel), SeqNum(seq))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100*int(seq); i++ {
+			key := []byte(fmt.Sprintf("key-%d-%05d", seq, i))
+			if err := w.Write(key, enc.Encode(encoder.OpKindSet, key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.AddIdxBlock(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, LevelFile{Level: BaseLevel, SeqNum: seq, Ext: ExtBin})
+	}
+
+	var n int
+	seq := uint64(3)
+	metas, err := Compact(dir, files, BaseLevel, 1<<30, 4, countingPolicy{bloom.NewScalablePolicy(100), &n}, false, func() (uint64, error) {
+		seq++
+		return seq, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 600 {
+		t.Fatalf("filter is built for %d keys, want 600", n)
+	}
+	if len(metas) != 1 {
+		t.Fatalf("compaction wrote %d tables, want 1", len(metas))
+	}
+	for seq := 1; seq <= 3; seq++ {
+		for i := 0; i < 100*seq; i++ {
+			if key := fmt.Sprintf("key-%d-%05d", seq, i); !metas[0].Filter.TestByte([]byte(key)) {
+				t.Fatalf("%s is not a member of filter of compacted table", key)
+			}
+		}
+	}
+}
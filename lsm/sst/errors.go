@@ -0,0 +1,54 @@
+package sst
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCorruption is returned when a file can not be decoded. The error is
+// wrapped into CorruptionError, if the file and the offset are known.
+var ErrCorruption = errors.New("corruption")
+
+// CorruptionError describes the corrupted record of a file.
+type CorruptionError struct {
+	File   string
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptionError) Error() string {
+	if errors.Is(e.Err, ErrCorruption) {
+		return fmt.Sprintf("%s at offset %d: %s", e.File, e.Offset, e.Err)
+	}
+
+	return fmt.Sprintf("%s at offset %d: %s: %s", e.File, e.Offset, ErrCorruption, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() []error {
+	return []error{ErrCorruption, e.Err}
+}
+
+// Corrupted wraps the corruption error of the file at the offset into CorruptionError.
+// If err already is a CorruptionError of an unknown file, the file is set and
+// the offset is added to its offset, so readers of a part of the file may
+// report offsets relative to that part. Other errors are returned as is.
+func Corrupted(file string, offset int64, err error) error {
+	var ce *CorruptionError
+	if errors.As(err, &ce) {
+		if ce.File == "" {
+			ce.File = file
+			ce.Offset += offset
+		}
+		return err
+	}
+	if !errors.Is(err, ErrCorruption) {
+		return err
+	}
+
+	return &CorruptionError{File: file, Offset: offset, Err: err}
+}
+
+// recordSize returns the size of the encoded record of the key and the value.
+func recordSize(key, value []byte) int64 {
+	return int64(16 + len(key) + len(value))
+}
@@ -1,11 +1,13 @@
 package sst
 
 import (
+	"cmp"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -27,11 +29,13 @@ type File struct {
 
 const (
 	// DiskTable data file name. It contains raw data.
-	extBin = ".bin"
+	ExtBin = ".bin"
 	// DiskTable key file name. It contains keys and positions to values in the data file.
-	extIdx = ".idx"
+	ExtIdx = ".idx"
 	// DiskTable sparse index. A sampling of every 64th entry in the index file.
-	extSparse = ".spr"
+	ExtSparse = ".spr"
+	// DiskTable partitions: index and filter partitions of a partitioned table, see Partitioned.
+	ExtPartition = ".ptn"
 	// A flag to open file for new disk table files: data, index and sparse index.
 	newflags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC | os.O_APPEND
 )
@@ -45,7 +49,7 @@ type LevelFile struct {
 func NewIterator(f *os.File) (*FileIterator, error) {
 	key, val, err := Decode(f)
 	if err != nil && err != io.EOF {
-		return nil, err
+		return nil, Corrupted(f.Name(), 0, err)
 	}
 
 	return &FileIterator{
@@ -53,6 +57,7 @@ func NewIterator(f *os.File) (*FileIterator, error) {
 		err: err,
 		key: key,
 		val: val,
+		pos: recordSize(key, val),
 	}, nil
 }
 
@@ -64,7 +69,8 @@ func NewFileIterator(filepath string) (*FileIterator, error) {
 
 	key, val, err := Decode(fd)
 	if err != nil && err != io.EOF {
-		return nil, err
+		fd.Close()
+		return nil, Corrupted(fd.Name(), 0, err)
 	}
 
 	return &FileIterator{
@@ -72,6 +78,7 @@ func NewFileIterator(filepath string) (*FileIterator, error) {
 		err: err,
 		key: key,
 		val: val,
+		pos: recordSize(key, val),
 	}, nil
 }
 
@@ -80,6 +87,8 @@ type FileIterator struct {
 	key []byte
 	val []byte
 	err error
+	// Offset of the record after the current one.
+	pos int64
 }
 
 func (it *FileIterator) HasNext() bool {
@@ -91,9 +100,10 @@ func (it *FileIterator) Next() ([]byte, []byte, error) {
 
 	nextKey, nextVal, err := Decode(it.fd)
 	if err != nil && err != io.EOF {
-		it.err = err
-		return nil, nil, err
+		it.err = Corrupted(it.fd.Name(), it.pos, err)
+		return nil, nil, it.err
 	}
+	it.pos += recordSize(nextKey, nextVal)
 	if err == io.EOF {
 		it.err = io.EOF
 	}
@@ -108,26 +118,165 @@ func (it *FileIterator) CLose() error {
 	return it.fd.Close()
 }
 
-// Levels возвращает имена любых каталогов, содержащих консолидированные
-// Файлы SST на уровнях, превышающих уровень 0. Это означает, что данные
+var reName = regexp.MustCompile(`^([0-9]+)[-.]([0-9]+)(\.[a-z]+)$`)
+
+// nameBy returns the name of the SST file of the level with the sequence number.
+func nameBy(level Level, num uint64, ext string) string {
+	return fmt.Sprintf("%d-%d%s", level, num, ext)
+}
+
+// ParseName parses the level, the sequence number and the extension
+// from the name of the SST file.
+func ParseName(name string) (Level, uint64, string, error) {
+	m := reName.FindStringSubmatch(name)
+	if m == nil {
+		return 0, 0, "", fmt.Errorf("invalid sst file name %s", name)
+	}
+
+	level, err := strconv.ParseUint(m[1], 10, 16)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid level of sst file %s: %w", name, err)
+	}
+
+	num, err := strconv.ParseUint(m[2], 10, 64)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid sequence number of sst file %s: %w", name, err)
+	}
+
+	return Level(level), num, m[3], nil
+}
+
+// PathBy returns the path to the data file of the level with the sequence number.
+func PathBy(dirname string, level Level, num uint64) string {
+	return path.Join(dirname, nameBy(level, num, ExtBin))
+}
+
+// Levels возвращает отсортированный список уровней, для которых
+// в каталоге есть SST-файлы. Начиная с уровня 1 данные
 // организованы в неперекрывающиеся области между файлами на этом уровне.
-func Levels(path string) ([]string, error) {
+func Levels(path string) ([]Level, error) {
 	files, err := os.ReadDir(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var lvls []string
+	var lvls []Level
 	for _, file := range files {
-		matched, _ := regexp.Match(`^level-[0-9]*`, []byte(file.Name()))
-		if matched && file.IsDir() {
-			lvls = append(lvls, file.Name())
+		if file.IsDir() {
+			continue
+		}
+
+		lvl, _, _, err := ParseName(file.Name())
+		if err != nil {
+			continue
+		}
+
+		if !slices.Contains(lvls, lvl) {
+			lvls = append(lvls, lvl)
 		}
 	}
+	slices.Sort(lvls)
 
 	return lvls, nil
 }
 
+// Filename возвращает SST-файлы уровня, отсортированные по номеру последовательности.
+func Filename(path string, level Level) ([]LevelFile, error) {
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lvlFiles []LevelFile
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		lvl, num, ext, err := ParseName(file.Name())
+		if err != nil || lvl != level || ext != ExtBin {
+			continue
+		}
+
+		lvlFiles = append(lvlFiles, LevelFile{Level: lvl, SeqNum: num, Ext: ext})
+	}
+	slices.SortFunc(lvlFiles, func(a, b LevelFile) int {
+		return cmp.Compare(a.SeqNum, b.SeqNum)
+	})
+
+	return lvlFiles, nil
+}
+
+// Remove removes the data, index, sparse index and partitions files of the table.
+func Remove(dirname string, level Level, num uint64) error {
+	for _, ext := range []string{ExtBin, ExtIdx, ExtSparse, ExtPartition} {
+		if err := os.Remove(path.Join(dirname, nameBy(level, num, ext))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadTable reads the metadata of the table of the directory.
+// The bloom filter of the table is not stored, so it is not set.
+func LoadTable(dirname string, file LevelFile) (SSTFile, error) {
+	return NewMemMetaSST(tablePath(dirname, file, ExtSparse), file.Level, nil)
+}
+
+// Link creates hard links to the data, index, sparse index and partitions
+// files of the table of the directory src in the directory dst, see LinkOrCopy.
+// Tables without partitions have no partitions file.
+func Link(src, dst string, level Level, num uint64) error {
+	for _, ext := range []string{ExtBin, ExtIdx, ExtSparse, ExtPartition} {
+		name := nameBy(level, num, ext)
+		if ext == ExtPartition {
+			if _, err := os.Stat(path.Join(src, name)); os.IsNotExist(err) {
+				continue
+			}
+		}
+		if err := LinkOrCopy(path.Join(src, name), path.Join(dst, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LinkOrCopy creates a hard link dst to the file src. If the link can not
+// be created, for example, across file systems, the file is copied.
+func LinkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	return CopyFile(src, dst)
+}
+
+// CopyFile copies the file src to the new file dst and syncs it.
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, newflags, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy file %s to %s: %w", src, dst, err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
 func PathForLevel(base string, level int) string {
 	return fmt.Sprintf("%s/level-%d", base, level)
 }
@@ -198,32 +347,37 @@ func NextFilename(path string) (string, error) {
 
 // Get filename of index file for given SST file
 func sparseFileForIdx(filename string) string {
-	return strings.TrimSuffix(filename, extIdx) + extSparse
+	return strings.TrimSuffix(filename, ExtIdx) + ExtSparse
 }
 
 // Get filename of index file for given SST file
 func sparseFileForBin(filename string) string {
-	return strings.TrimSuffix(filename, extBin) + extSparse
+	return strings.TrimSuffix(filename, ExtBin) + ExtSparse
 }
 
 // Get filename of index file for given SST file
 func indexFileForBin(filename string) string {
-	return strings.TrimSuffix(filename, extBin) + extIdx
+	return strings.TrimSuffix(filename, ExtBin) + ExtIdx
 }
 
 // Get filename of index file for given SST file
 func indexFileForSparse(filename string) string {
-	return strings.TrimSuffix(filename, extSparse) + extIdx
+	return strings.TrimSuffix(filename, ExtSparse) + ExtIdx
+}
+
+// Get filename of partitions file for given SST file
+func partitionFileForBin(filename string) string {
+	return strings.TrimSuffix(filename, ExtBin) + ExtPartition
 }
 
 // Get filename of binary file for given index file
 func binFileForIndex(filename string) string {
-	return strings.TrimSuffix(filename, extIdx) + extBin
+	return strings.TrimSuffix(filename, ExtIdx) + ExtBin
 }
 
 // Get filename of binary file for given index file
 func binFileForSparse(filename string) string {
-	return strings.TrimSuffix(filename, extSparse) + extBin
+	return strings.TrimSuffix(filename, ExtSparse) + ExtBin
 }
 
 func OpenBy(binpath string) (*os.File, *os.File, *os.File, error) {
@@ -239,10 +393,10 @@ func OpenBy(binpath string) (*os.File, *os.File, *os.File, error) {
 				binFile.Close()
 			}
 			if idxFile != nil {
-				binFile.Close()
+				idxFile.Close()
 			}
 			if sparFile != nil {
-				binFile.Close()
+				sparFile.Close()
 			}
 		}
 	}()
@@ -264,15 +418,23 @@ func OpenBy(binpath string) (*os.File, *os.File, *os.File, error) {
 	return binFile, idxFile, sparFile, nil
 }
 
-func NewMemMetaSST(filename string, level Level, filter *bloom.Filter) (SSTFile, error) {
-	_, h, err := readSparseIndexFile(filename)
+func NewMemMetaSST(filename string, level Level, filter bloom.KeyFilter) (SSTFile, error) {
+	h, meta, err := readMetaFile(filename)
+	if err != nil {
+		return SSTFile{}, err
+	}
+	stat, err := os.Stat(strings.TrimSuffix(filename, ExtSparse) + ExtBin)
 	if err != nil {
 		return SSTFile{}, err
 	}
 
 	return SSTFile{
-		Filter: filter,
-		SeqNum: h.Seq,
-		Level:  level,
+		Size:      stat.Size(),
+		Filter:    filter,
+		SeqNum:    h.Seq,
+		Level:     level,
+		Smallest:  meta.Smallest,
+		Largest:   meta.Largest,
+		RangeDels: meta.RangeDels,
 	}, nil
 }
@@ -0,0 +1,97 @@
+package sst
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+)
+
+// FileName sets the name of the data file of the table instead of the name
+// by the level and the sequence number. The index and sparse index files
+// are named after it. It is used to write external tables, see Ingest.
+func FileName(name string) OptionWriter {
+	return func(w *Writer) {
+		w.name = name
+	}
+}
+
+// ReadExternal reads the metadata of the external table by the path of its data
+// file and checks that the keys of the table are sorted and within its bounds.
+func ReadExternal(binPath string) (SSTFile, error) {
+	file, err := NewMemMetaSST(sparseFileForBin(binPath), BaseLevel, nil)
+	if err != nil {
+		return SSTFile{}, err
+	}
+
+	it, err := NewFileIterator(binPath)
+	if err != nil {
+		return SSTFile{}, err
+	}
+	defer it.CLose()
+
+	var prev []byte
+	for it.HasNext() {
+		key, _, err := it.Next()
+		if err != nil {
+			return SSTFile{}, err
+		}
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			return SSTFile{}, Corrupted(binPath, it.pos, fmt.Errorf("%w: key %q is not greater than %q", ErrCorruption, key, prev))
+		}
+		if bytes.Compare(key, file.Smallest) < 0 || bytes.Compare(key, file.Largest) > 0 {
+			return SSTFile{}, Corrupted(binPath, it.pos, fmt.Errorf("%w: key %q is out of bounds [%q, %q]", ErrCorruption, key, file.Smallest, file.Largest))
+		}
+		prev = key
+	}
+
+	return file, nil
+}
+
+// Ingest places the external table with the data file binPath into the
+// directory at the level with the sequence number. The data and index files
+// are hard linked or copied, see LinkOrCopy; the sparse index file is copied
+// and its footer gets the new sequence number, so compactions order the keys
+// of the table after all keys written before.
+func Ingest(binPath, dirname string, level Level, seqNum uint64) (SSTFile, error) {
+	bin := path.Join(dirname, nameBy(level, seqNum, ExtBin))
+	if err := LinkOrCopy(binPath, bin); err != nil {
+		return SSTFile{}, err
+	}
+	if err := LinkOrCopy(indexFileForBin(binPath), indexFileForBin(bin)); err != nil {
+		return SSTFile{}, err
+	}
+
+	spr := sparseFileForBin(bin)
+	if err := CopyFile(sparseFileForBin(binPath), spr); err != nil {
+		return SSTFile{}, err
+	}
+	if err := writeSeqNum(spr, seqNum); err != nil {
+		return SSTFile{}, fmt.Errorf("failed to set sequence number of %s: %w", spr, err)
+	}
+
+	return NewMemMetaSST(spr, level, nil)
+}
+
+// writeSeqNum перезаписывает номер последовательности в футере разреженного индекса.
+func writeSeqNum(spr string, seqNum uint64) error {
+	f, err := os.OpenFile(spr, os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() < footerSize {
+		return Corrupted(spr, 0, fmt.Errorf("%w: size %d less than footer %d", ErrCorruption, stat.Size(), footerSize))
+	}
+
+	if _, err := f.WriteAt(encodeUInt64(seqNum), stat.Size()-footerSize+footerSeqPos); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
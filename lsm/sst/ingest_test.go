@@ -0,0 +1,31 @@
+package sst
+
+import "testing"
+
+func TestIngestSeqNum(t *testing.T) {
+	ext, dir := t.TempDir(), t.TempDir()
+	writeTable(t, ext, 1, 10)
+
+	// номер последовательности не помещается в uint32
+	const seq = 1<<33 + 3
+	file, err := Ingest(PathBy(ext, BaseLevel, 1), dir, BaseLevel, seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.SeqNum != seq {
+		t.Fatalf("ingested table has sequence number %d, want %d", file.SeqNum, seq)
+	}
+
+	r, err := New(PathBy(dir, BaseLevel, seq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.header.Seq != seq {
+		t.Fatalf("footer has sequence number %d, want %d", r.header.Seq, seq)
+	}
+	if value, ok, err := r.Get([]byte("key-00003")); err != nil || !ok || string(value) != "value-00003" {
+		t.Fatalf("got %q %t %v", value, ok, err)
+	}
+}
@@ -2,25 +2,61 @@ package sst
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"io"
-	"os"
-	"path"
+
+	"github.com/wubba-com/lsm-distributed/lsm/encoder"
 )
 
+// tombstone is an encoded value of the deleted key.
+var tombstone = encoder.NewEncoder().Encode(encoder.OpKindDelete, nil)
+
 // searchInDiskTables searches a value by the key in DiskTables, by traversing
 // all tables in the directory.
+//
+// A key deleted by a range tombstone is returned as an encoded tombstone.
+// Tables of the base level overlap, so each of them is checked from
+// the newest to the oldest, and a value in a table is newer than its
+// range tombstones. Tables of the other levels are produced by a single
+// compaction, so a value found in any table of the level is newer
+// than the range tombstones of the level. Tables that can not contain
+// the key by their bounds or filters are not opened.
 func SearchInDiskTables(key []byte, dirname string, lvls []SSTLevel) ([]byte, bool, error) {
+	return SearchInDiskTablesContext(context.Background(), key, dirname, lvls)
+}
+
+// SearchInDiskTablesContext is like SearchInDiskTables, but stops
+// with the error of the context before reading the next table.
+func SearchInDiskTablesContext(ctx context.Context, key []byte, dirname string, lvls []SSTLevel) ([]byte, bool, error) {
 	for lvl := 0; lvl < len(lvls); lvl++ {
 		for last := len(lvls[lvl].Files) - 1; last >= 0; last-- {
-			seq := lvls[lvl].Files[last].SeqNum
-			value, exists, err := searchInDiskTable(key, dirname, Level(lvl), seq)
-			if err != nil {
-				return nil, false, fmt.Errorf("failed to search in disk table with index %d lvl %d: %w", last, lvl, err)
+			if err := ctx.Err(); err != nil {
+				return nil, false, err
+			}
+
+			// таблица, которой по границам и фильтру нет ключа, не открывается
+			file := lvls[lvl].Files[last]
+			if file.mayContain(key) {
+				value, exists, err := searchInDiskTable(key, dirname, file)
+				if err != nil {
+					return nil, false, fmt.Errorf("failed to search in disk table with index %d lvl %d: %w", last, lvl, err)
+				}
+
+				if exists {
+					return value, exists, nil
+				}
+			}
+
+			if lvl == int(BaseLevel) && file.Covers(key) {
+				return tombstone, true, nil
 			}
+		}
 
-			if exists {
-				return value, exists, nil
+		if lvl != int(BaseLevel) {
+			for _, file := range lvls[lvl].Files {
+				if file.Covers(key) {
+					return tombstone, true, nil
+				}
 			}
 		}
 	}
@@ -29,128 +65,211 @@ func SearchInDiskTables(key []byte, dirname string, lvls []SSTLevel) ([]byte, bo
 }
 
 // searchInDiskTable searches a given key in a given disk table.
-func searchInDiskTable(key []byte, dirname string, lvl Level, seqNum uint64) ([]byte, bool, error) {
-	df, idxf, spf, err := OpenBy(dirname, lvl, seqNum, os.O_RDONLY)
-	if err != nil {
-		return nil, false, err
+// The files of a partitioned table are not opened at all,
+// if the filter of the partition of the key does not contain it.
+func searchInDiskTable(key []byte, dirname string, file SSTFile) ([]byte, bool, error) {
+	var (
+		block indexBlock
+		ok    bool
+		err   error
+	)
+	if file.Partitions != nil {
+		if block, ok, err = file.Partitions.search(key); err != nil {
+			return nil, false, fmt.Errorf("failed to search in partitions: %w", err)
+		}
+		if !ok {
+			return nil, false, nil
+		}
 	}
-	defer func() {
-		df.Close()
-		idxf.Close()
-		spf.Close()
-	}()
 
-	from, to, ok, err := searchInSparseIndex(spf, key)
+	r, done, err := file.reader(dirname)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in sparse index file %s: %w", path.Join(dirname, spf.Name()), err)
-	}
-	if !ok {
-		return nil, false, nil
+		return nil, false, err
 	}
+	defer done()
 
-	offset, ok, err := searchInIndex(idxf, from, to, key)
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in index file %s: %w", path.Join(dirname, idxf.Name()), err)
+	if file.Partitions == nil {
+		if block, ok, err = r.search(key); err != nil {
+			return nil, false, fmt.Errorf("failed to search in sparse index file %s: %w", r.sparse.name(), err)
+		}
+		if !ok {
+			return nil, false, nil
+		}
 	}
-	if !ok {
-		return nil, false, nil
+
+	return r.get(key, block)
+}
+
+// reader returns the open reader of the table, see SSTFile.Reader, or opens
+// the table with ReadAt. done closes the reader opened by reader.
+func (f SSTFile) reader(dirname string) (*Reader, func(), error) {
+	if f.Reader != nil {
+		return f.Reader, func() {}, nil
 	}
 
-	value, ok, err := searchInDataFile(df, offset, key)
+	r, err := New(PathBy(dirname, f.Level, f.SeqNum))
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in data file %s: %w", path.Join(dirname, df.Name()), err)
+		return nil, nil, err
 	}
 
-	return value, ok, nil
+	return r, func() { r.Close() }, nil
 }
 
-// searchInDataFile searches a value by the key in the data file from the given offset.
-// The offset must always point to the beginning of the record.
-func searchInDataFile(r io.ReadSeeker, offset int, searchKey []byte) ([]byte, bool, error) {
-	if _, err := r.Seek(int64(offset), io.SeekStart); err != nil {
-		return nil, false, fmt.Errorf("failed to seek: %w", err)
+// indexBlock is the block of the index file between two keys
+// of the sparse index.
+type indexBlock struct {
+	From, To int64
+	// Restart points of the block, see searchInIndexBlock.
+	Restarts []uint32
+}
+
+// searchInIndexBlock searches the offset of the key in the data file
+// by the block of the index file.
+//
+// Every restartInterval-th entry of the block is a restart point, their
+// positions relative to the beginning of the block are stored in the sparse
+// index entry of the block after the position of the block. The first entry
+// is a restart point too, but it is not stored. The search finds the last
+// restart point with the key not greater than the key by binary search and
+// decodes the entries from it until the key, so it decodes
+// O(log(n/restartInterval) + restartInterval) entries of n entries
+// of the block. Blocks without restart points are decoded from the beginning.
+func searchInIndexBlock(block []byte, restarts []uint32, searchKey []byte) (int, bool, error) {
+	from, to, err := restartRange(block, restarts, searchKey)
+	if err != nil {
+		return 0, false, err
 	}
 
-	for {
-		key, value, err := Decode(r)
-		if err != nil && err != io.EOF {
-			return nil, false, fmt.Errorf("failed to read: %w", err)
-		}
-		if err == io.EOF {
-			return nil, false, nil
+	for pos := from; pos < to; {
+		key, value, n, err := nextRecord(block[pos:to])
+		if err != nil {
+			return 0, false, Corrupted("", int64(pos), err)
 		}
+		pos += n
 
-		if bytes.Equal(key, searchKey) {
-			return value, true, nil
+		switch cmp := bytes.Compare(key, searchKey); {
+		case cmp == 0:
+			offset, err := indexOffset(value, pos-n)
+			return offset, err == nil, err
+		case cmp > 0:
+			// ключи блока упорядочены, дальше искомого нет
+			return 0, false, nil
 		}
 	}
+
+	return 0, false, nil
 }
 
-// searchInIndex searches key in the index file in specified range.
-func searchInIndex(r io.ReadSeeker, from, to int, searchKey []byte) (int, bool, error) {
-	if _, err := r.Seek(int64(from), io.SeekStart); err != nil {
-		return 0, false, fmt.Errorf("failed to seek: %w", err)
+// floorInIndexBlock returns the offset in the data file of the last key
+// of the block not greater than the key, see searchInIndexBlock.
+// It is false if all keys of the block are greater than the key.
+func floorInIndexBlock(block []byte, restarts []uint32, searchKey []byte) (int, bool, error) {
+	from, to, err := restartRange(block, restarts, searchKey)
+	if err != nil {
+		return 0, false, err
 	}
 
-	for {
-		key, value, err := Decode(r)
-		if err != nil && err != io.EOF {
-			return 0, false, fmt.Errorf("failed to read: %w", err)
+	var (
+		offset int
+		found  bool
+	)
+	for pos := from; pos < to; {
+		key, value, n, err := nextRecord(block[pos:to])
+		if err != nil {
+			return 0, false, Corrupted("", int64(pos), err)
 		}
-		if err == io.EOF {
-			return 0, false, nil
+		pos += n
+
+		if bytes.Compare(key, searchKey) > 0 {
+			break
 		}
-		offset := int(decodeUInt64(value))
+		if offset, err = indexOffset(value, pos-n); err != nil {
+			return 0, false, err
+		}
+		found = true
+	}
 
-		if bytes.Equal(key, searchKey) {
-			return offset, true, nil
+	return offset, found, nil
+}
+
+// restartRange returns the part [from, to) of the block between the last
+// restart point with the key not greater than the key and the next one.
+func restartRange(block []byte, restarts []uint32, searchKey []byte) (int, int, error) {
+	// keyAt декодирует ключ записи на позиции pos
+	var keyAt = func(pos uint32) ([]byte, error) {
+		if int(pos) >= len(block) {
+			return nil, fmt.Errorf("%w: restart point %d beyond block of %d bytes", ErrCorruption, pos, len(block))
 		}
+		key, _, _, err := nextRecord(block[pos:])
+		if err != nil {
+			return nil, Corrupted("", int64(pos), err)
+		}
+		return key, nil
+	}
 
-		if to > from {
-			current, err := r.Seek(0, io.SeekCurrent)
-			if err != nil {
-				return 0, false, fmt.Errorf("failed to seek: %w", err)
-			}
+	// первая точка перезапуска с ключом больше искомого
+	low, high := 0, len(restarts)
+	for low < high {
+		mid := (low + high) / 2
 
-			if current > int64(to) {
-				return 0, false, nil
-			}
+		key, err := keyAt(restarts[mid])
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if bytes.Compare(key, searchKey) <= 0 {
+			low = mid + 1
+		} else {
+			high = mid
 		}
 	}
+
+	var from, to = 0, len(block)
+	if low > 0 {
+		from = int(restarts[low-1])
+	}
+	if low < len(restarts) {
+		to = int(restarts[low])
+	}
+	if from > to || to > len(block) {
+		return 0, 0, fmt.Errorf("%w: restart points %d, %d of block of %d bytes", ErrCorruption, from, to, len(block))
+	}
+
+	return from, to, nil
 }
 
-// searchInSparseIndex searches a range between which the key is located.
-func searchInSparseIndex(r io.Reader, searchKey []byte) (int, int, bool, error) {
-	from := -1
+// indexOffset decodes the offset in the data file from the value
+// of the index entry at the position pos of the block.
+func indexOffset(value []byte, pos int) (int, error) {
+	if len(value) < 8 {
+		return 0, Corrupted("", int64(pos), fmt.Errorf("%w: index value of %d bytes", ErrCorruption, len(value)))
+	}
 
-	_, err := readSparseHeader(r)
-	if err != nil {
-		return from, 0, false, err
+	return int(decodeUInt64(value)), nil
+}
+
+// encodeSparseValue encodes the value of the sparse index entry:
+// the position of the block in the index file and its restart points.
+func encodeSparseValue(offset int, restarts []uint32) []byte {
+	buf := encodeUInt64(uint64(offset))
+	for _, r := range restarts {
+		buf = append(buf, encodeUInt32(r)...)
 	}
-	//log.Println("searchInSparseIndex seq num:", h.Seq)
-	for {
-		key, value, err := Decode(r)
-		if err != nil && err != io.EOF {
-			return 0, 0, false, fmt.Errorf("failed to read: %w", err)
-		}
-		if err == io.EOF {
-			return from, 0, from != -1, nil
-		}
-		offset := int(decodeUInt64(value))
-
-		cmp := bytes.Compare(key, searchKey)
-		if cmp == 0 {
-			return offset, offset, true, nil
-		} else if cmp < 0 {
-			from = offset
-		} else if cmp > 0 {
-			if from == -1 {
-				// если первый ключ в разреженном индексе больше, чем
-				// ключа поиска, это означает, что ключ отсутствует
-				return 0, 0, false, nil
-			} else {
-				return from, offset, true, nil
-			}
-		}
+
+	return buf
+}
+
+// decodeSparseValue decodes the value of the sparse index entry. Tables
+// written before restart points were added have only the position.
+func decodeSparseValue(value []byte) (int, []uint32, error) {
+	if len(value) < 8 || (len(value)-8)%minBytes != 0 {
+		return 0, nil, fmt.Errorf("%w: sparse index value of %d bytes", ErrCorruption, len(value))
 	}
+
+	var restarts []uint32
+	for b := value[8:]; len(b) > 0; b = b[minBytes:] {
+		restarts = append(restarts, decodeUInt32(b))
+	}
+
+	return int(decodeUInt64(value)), restarts, nil
 }
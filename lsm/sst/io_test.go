@@ -0,0 +1,45 @@
+package sst
+
+import (
+	"os"
+	"testing"
+)
+
+// rejectFilter - фильтр, в котором нет ни одного ключа.
+type rejectFilter struct{}
+
+func (rejectFilter) TestByte([]byte) bool { return false }
+func (rejectFilter) Size() int            { return 0 }
+
+func TestSearchSkipsFilteredTables(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTable(t, dir, 1, 100)
+
+	value, ok, err := SearchInDiskTables([]byte("key-00042"), dir, []SSTLevel{{Files: []SSTFile{file}}})
+	if err != nil || !ok || string(value) != "value-00042" {
+		t.Fatalf("got %q %t %v", value, ok, err)
+	}
+
+	// таблица без файла данных: поиск, открывший бы ее, вернул бы ошибку
+	if err := os.Remove(PathBy(dir, BaseLevel, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := SearchInDiskTables([]byte("key-00042"), dir, []SSTLevel{{Files: []SSTFile{file}}}); err == nil {
+		t.Fatal("table without data file is searched without error")
+	}
+
+	filtered := file
+	filtered.Filter = rejectFilter{}
+	for _, tt := range []struct {
+		key  string
+		file SSTFile
+	}{
+		{"key-00042", filtered},
+		{"a", file},
+		{"z", file},
+	} {
+		if _, ok, err := SearchInDiskTables([]byte(tt.key), dir, []SSTLevel{{Files: []SSTFile{tt.file}}}); err != nil || ok {
+			t.Fatalf("%s: got %t %v, want the table skipped", tt.key, ok, err)
+		}
+	}
+}
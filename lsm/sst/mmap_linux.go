@@ -0,0 +1,42 @@
+//go:build linux
+
+package sst
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps size bytes of the file into memory for reading
+// and advises the kernel of the access pattern.
+func mmapFile(f *os.File, size int64, access Access) ([]byte, error) {
+	if size == 0 {
+		// пустой файл нельзя отобразить
+		return nil, nil
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	advice := syscall.MADV_RANDOM
+	if access == SequentialAccess {
+		advice = syscall.MADV_SEQUENTIAL
+	}
+	if err := syscall.Madvise(data, advice); err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// munmapFile unmaps the memory returned by mmapFile.
+func munmapFile(data []byte) error {
+	if data == nil {
+		return nil
+	}
+
+	return syscall.Munmap(data)
+}
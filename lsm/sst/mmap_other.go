@@ -0,0 +1,14 @@
+//go:build !linux
+
+package sst
+
+import "os"
+
+// mmapFile is not supported on this platform, readers fall back to ReadAt.
+func mmapFile(f *os.File, size int64, access Access) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
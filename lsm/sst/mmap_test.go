@@ -0,0 +1,125 @@
+package sst
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func TestReaderMmap(t *testing.T) {
+	const n = 1000
+	dir := t.TempDir()
+	writeTable(t, dir, 1, n, SparseKeyDistance(16), RestartInterval(4))
+
+	r, err := New(PathBy(dir, BaseLevel, 1), Mmap(RandomAccess))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Mapped() != (runtime.GOOS == "linux") {
+		t.Fatalf("reader is mapped %t on %s", r.Mapped(), runtime.GOOS)
+	}
+
+	values := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		value, ok, err := r.Get([]byte(fmt.Sprintf("key-%05d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || !bytes.Equal(value, []byte(fmt.Sprintf("value-%05d", i))) {
+			t.Fatalf("key-%05d: got %q %t, want value-%05d", i, value, ok, i)
+		}
+		values = append(values, value)
+
+		if _, ok, err := r.Get([]byte(fmt.Sprintf("key-%05da", i))); err != nil || ok {
+			t.Fatalf("key-%05da: got %t %v, want not found", i, ok, err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+
+	// значения скопированы из отображения и остаются после его закрытия
+	for i, value := range values {
+		if !bytes.Equal(value, []byte(fmt.Sprintf("value-%05d", i))) {
+			t.Fatalf("key-%05d: value %q changed after close", i, value)
+		}
+	}
+}
+
+func TestSearchInMappedTables(t *testing.T) {
+	const n = 2000
+	dir := t.TempDir()
+
+	var files []SSTFile
+	for seq, options := range [][]OptionWriter{
+		{SparseKeyDistance(16)},
+		{SparseKeyDistance(16), Partitioned(200, 100)},
+	} {
+		file := writeTable(t, dir, uint64(seq+1), n, options...)
+		binpath := PathBy(dir, BaseLevel, uint64(seq+1))
+
+		var err error
+		if file.Partitions, err = OpenPartitions(binpath, NewBlockCache(1<<20)); err != nil {
+			t.Fatal(err)
+		}
+		if file.Reader, err = New(binpath, Mmap(RandomAccess)); err != nil {
+			t.Fatal(err)
+		}
+		defer file.Reader.Close()
+
+		files = append(files, file)
+	}
+
+	for _, file := range files {
+		lvls := []SSTLevel{{Files: []SSTFile{file}}}
+		for i := 0; i < n; i += 7 {
+			key := []byte(fmt.Sprintf("key-%05d", i))
+			value, ok, err := SearchInDiskTables(key, dir, lvls)
+			if err != nil || !ok || !bytes.Equal(value, []byte(fmt.Sprintf("value-%05d", i))) {
+				t.Fatalf("%s: got %q %t %v", key, value, ok, err)
+			}
+		}
+
+		results := MultiSearchInDiskTables([][]byte{[]byte("key-00001"), []byte("key-00001a"), []byte("key-01999")}, dir, lvls)
+		if !results[0].Found || string(results[0].Value) != "value-00001" || results[1].Found || !results[2].Found || string(results[2].Value) != "value-01999" {
+			t.Fatalf("got %+v", results)
+		}
+	}
+}
+
+func BenchmarkReaderGetMmap(b *testing.B) {
+	const n = 100000
+	dir := b.TempDir()
+	w, err := NewWriter(dir, AtLevel(BaseLevel), SeqNum(1))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := w.Write([]byte(fmt.Sprintf("key-%06d", i)), []byte(fmt.Sprintf("value-%06d", i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := w.AddIdxBlock(); err != nil {
+		b.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	r, err := New(PathBy(dir, BaseLevel, 1), Mmap(RandomAccess))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, ok, err := r.Get([]byte(fmt.Sprintf("key-%06d", i*7919%n))); err != nil || !ok {
+			b.Fatal(ok, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package sst
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// SearchResult is the result of the search of a key in DiskTables.
+type SearchResult struct {
+	Value []byte
+	Found bool
+	Err   error
+}
+
+// MultiSearchInDiskTables searches values of the keys in DiskTables like
+// SearchInDiskTables, but visits each table once for all keys. The keys
+// must be sorted. A table is read only if the bloom filter or the bounds
+// of the table may contain some of the keys; then its sparse index is
+// read once, and each needed block of the index file is read once for
+// all keys of the block. An error of a table is returned for the keys
+// looked up in it, and these keys are not searched further.
+func MultiSearchInDiskTables(keys [][]byte, dirname string, lvls []SSTLevel) []SearchResult {
+	results := make([]SearchResult, len(keys))
+	pending := make([]int, len(keys))
+	for i := range keys {
+		pending[i] = i
+	}
+
+	// resolve убирает из pending найденные ключи и ключи с ошибками
+	var resolve = func() {
+		pending = slices.DeleteFunc(pending, func(i int) bool {
+			return results[i].Found || results[i].Err != nil
+		})
+	}
+
+	for lvl := 0; lvl < len(lvls) && len(pending) > 0; lvl++ {
+		for last := len(lvls[lvl].Files) - 1; last >= 0 && len(pending) > 0; last-- {
+			file := lvls[lvl].Files[last]
+
+			var candidates []int
+			for _, i := range pending {
+				if file.mayContain(keys[i]) {
+					candidates = append(candidates, i)
+				}
+			}
+			if len(candidates) > 0 {
+				multiSearchInDiskTable(keys, candidates, results, dirname, file)
+			}
+
+			if lvl == int(BaseLevel) {
+				for _, i := range pending {
+					if !results[i].Found && results[i].Err == nil && file.Covers(keys[i]) {
+						results[i] = SearchResult{Value: tombstone, Found: true}
+					}
+				}
+			}
+			resolve()
+		}
+
+		if lvl != int(BaseLevel) {
+			for _, i := range pending {
+				for _, file := range lvls[lvl].Files {
+					if file.Covers(keys[i]) {
+						results[i] = SearchResult{Value: tombstone, Found: true}
+						break
+					}
+				}
+			}
+			resolve()
+		}
+	}
+
+	return results
+}
+
+// mayContain tells if the key may be in the table according to its bounds and bloom filter.
+func (f SSTFile) mayContain(key []byte) bool {
+	if len(f.Smallest) > 0 && (bytes.Compare(key, f.Smallest) < 0 || bytes.Compare(key, f.Largest) > 0) {
+		return false
+	}
+
+	return f.Filter == nil || f.Filter.TestByte(key)
+}
+
+// multiSearchInDiskTable searches the candidate keys in the table and stores found values in results.
+func multiSearchInDiskTable(keys [][]byte, candidates []int, results []SearchResult, dirname string, file SSTFile) {
+	var fail = func(err error) {
+		for _, i := range candidates {
+			results[i].Err = err
+		}
+	}
+
+	r, done, err := file.reader(dirname)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer done()
+
+	// search возвращает блок индексного файла, в котором может быть ключ:
+	// по секциям секционированной таблицы или по разреженному индексу,
+	// прочитанному один раз для всех ключей
+	search := func(key []byte) (indexBlock, bool, error) {
+		return file.Partitions.search(key)
+	}
+	if file.Partitions == nil {
+		sparse, err := r.sparseIndex()
+		if err != nil {
+			fail(fmt.Errorf("failed to read sparse index file %s: %w", r.sparse.name(), err))
+			return
+		}
+
+		search = func(key []byte) (indexBlock, bool, error) {
+			// последний ключ разреженного индекса, не больший искомого
+			n := sort.Search(len(sparse), func(j int) bool {
+				return bytes.Compare(sparse[j].Key, key) > 0
+			}) - 1
+			if n < 0 {
+				return indexBlock{}, false, nil
+			}
+
+			block := indexBlock{From: int64(sparse[n].Offset), To: r.idx.size(), Restarts: sparse[n].Restarts}
+			if n+1 < len(sparse) {
+				block.To = int64(sparse[n+1].Offset)
+			}
+			return block, true, nil
+		}
+	}
+
+	// блоки индексного файла, прочитанные для нескольких ключей
+	blocks := make(map[int64][]byte)
+	for _, i := range candidates {
+		key := keys[i]
+
+		ib, ok, err := search(key)
+		if err != nil {
+			results[i].Err = fmt.Errorf("failed to search in partitions: %w", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		block, ok := blocks[ib.From]
+		if !ok {
+			if block, err = r.indexBlock(ib); err != nil {
+				results[i].Err = err
+				continue
+			}
+			blocks[ib.From] = block
+		}
+
+		results[i].Value, results[i].Found, results[i].Err = r.find(key, block, ib)
+	}
+}
@@ -0,0 +1,390 @@
+package sst
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/wubba-com/lsm-distributed/lsm/bloom"
+)
+
+// Partitioned tables
+//
+// The sparse index of a table has an entry for every sparseKeyDistance keys,
+// so for a table of billions of keys it grows to megabytes, as does
+// a filter of the whole table. A partitioned table splits both of them into
+// partitions of about the same number of keys and writes them to the
+// partitions file with a top-level index:
+//
+//	[index partition 0][filter partition 0] ... [index partition n][filter partition n]
+//	[top-level index][footer: top-level pos uint64, partitions uint32]
+//
+// An index partition holds the sparse index entries of the keys of the
+// partition and a filter partition holds the bloom.Filter of them.
+// An entry of the top-level index maps the first key of a partition to the
+// positions of its blocks. Only the top-level index stays in memory,
+// a lookup reads the filter partition of the key and, if the filter may
+// contain the key, its index partition through the BlockCache.
+//
+// The sparse index file is still written in full, so tables are readable
+// without the partitions file.
+
+// partitionFooterSize is the size of the footer of the partitions file.
+const partitionFooterSize = 8 + minBytes
+
+// partitionHandleSize is the size of the encoded partitionHandle.
+const partitionHandleSize = 8 + minBytes + 8 + minBytes + 8
+
+// partitionHandle is an entry of the top-level index.
+type partitionHandle struct {
+	// The first key of the partition.
+	Key []byte
+	// Position and length of the index partition.
+	IndexPos int64
+	IndexLen uint32
+	// Position and length of the filter partition.
+	FilterPos int64
+	FilterLen uint32
+	// End of the index entries of the partition in the index file.
+	IdxEnd int64
+}
+
+func (h partitionHandle) encode() []byte {
+	buf := make([]byte, 0, partitionHandleSize)
+	buf = append(buf, encodeUInt64(uint64(h.IndexPos))...)
+	buf = append(buf, encodeUInt32(h.IndexLen)...)
+	buf = append(buf, encodeUInt64(uint64(h.FilterPos))...)
+	buf = append(buf, encodeUInt32(h.FilterLen)...)
+	buf = append(buf, encodeUInt64(uint64(h.IdxEnd))...)
+
+	return buf
+}
+
+func decodePartitionHandle(key, value []byte) (partitionHandle, error) {
+	if len(value) != partitionHandleSize {
+		return partitionHandle{}, fmt.Errorf("%w: partition handle of %d bytes, want %d", ErrCorruption, len(value), partitionHandleSize)
+	}
+
+	return partitionHandle{
+		Key:       key,
+		IndexPos:  int64(decodeUInt64(value[0:8])),
+		IndexLen:  decodeUInt32(value[8:12]),
+		FilterPos: int64(decodeUInt64(value[12:20])),
+		FilterLen: decodeUInt32(value[20:24]),
+		IdxEnd:    int64(decodeUInt64(value[24:32])),
+	}, nil
+}
+
+// Partitioned makes the writer split the sparse index and the filter of the
+// table into partitions of about keys keys. The filters of partitions are
+// bloom.Filter with the false-positives rate less than 1/p and the options.
+func Partitioned(keys int, p int, options ...bloom.Option) OptionWriter {
+	return func(w *Writer) {
+		w.part = &partitioner{keys: max(keys, 1), p: p, options: options}
+	}
+}
+
+// partitioner пишет секции индекса и фильтров в файл секций.
+type partitioner struct {
+	keys    int
+	p       int
+	options []bloom.Option
+
+	f   *os.File
+	b   *bufio.Writer
+	pos int64
+
+	// Текущая секция: первый ключ, записи разреженного индекса,
+	// фильтр и число ключей.
+	first  []byte
+	index  bytes.Buffer
+	filter *bloom.Filter
+	n      int
+
+	handles []partitionHandle
+}
+
+// add добавляет ключ в фильтр текущей секции.
+func (p *partitioner) add(key []byte) {
+	if p.n == 0 {
+		p.first = slices.Clone(key)
+		p.filter = bloom.New(p.keys, p.p, p.options...)
+	}
+	p.filter.AddByte(key)
+	p.n++
+}
+
+// addSparse добавляет в текущую секцию запись разреженного индекса.
+func (p *partitioner) addSparse(key, value []byte) error {
+	_, err := Encode(&p.index, key, value)
+	return err
+}
+
+// full сообщает, что текущая секция набрала ключи. Секция закрывается
+// только перед блоком индексного файла, чтобы каждая секция
+// начиналась с записи разреженного индекса.
+func (p *partitioner) full() bool {
+	return p.n >= p.keys
+}
+
+// cut записывает текущую секцию, idxEnd - конец ее ключей в индексном файле.
+func (p *partitioner) cut(idxEnd int) error {
+	if p.n == 0 {
+		return nil
+	}
+
+	data, err := p.filter.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	h := partitionHandle{
+		Key:       p.first,
+		IndexPos:  p.pos,
+		IndexLen:  uint32(p.index.Len()),
+		FilterPos: p.pos + int64(p.index.Len()),
+		FilterLen: uint32(len(data)),
+		IdxEnd:    int64(idxEnd),
+	}
+	if _, err := p.b.Write(p.index.Bytes()); err != nil {
+		return err
+	}
+	if _, err := p.b.Write(data); err != nil {
+		return err
+	}
+	p.pos += int64(p.index.Len() + len(data))
+	p.handles = append(p.handles, h)
+
+	p.index.Reset()
+	p.n = 0
+
+	return nil
+}
+
+// finish записывает последнюю секцию, индекс верхнего уровня и футер.
+func (p *partitioner) finish(idxEnd int) error {
+	if err := p.cut(idxEnd); err != nil {
+		return err
+	}
+
+	top := p.pos
+	for _, h := range p.handles {
+		if _, err := Encode(p.b, h.Key, h.encode()); err != nil {
+			return err
+		}
+	}
+	if _, err := writeUint64(p.b, uint64(top)); err != nil {
+		return err
+	}
+	if _, err := writeUint32(p.b, uint32(len(p.handles))); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *partitioner) close() error {
+	if err := p.b.Flush(); err != nil {
+		return fmt.Errorf("err flush at the close: %s", err)
+	}
+	if err := p.f.Sync(); err != nil {
+		return fmt.Errorf("err sync at the close: %s", err)
+	}
+	if err := p.f.Close(); err != nil {
+		return fmt.Errorf("err close at the close: %s", err)
+	}
+
+	return nil
+}
+
+// Partitions is the top-level index of a partitioned table. It loads
+// the index and filter partitions on demand through the block cache.
+type Partitions struct {
+	path    string
+	handles []partitionHandle
+	cache   *BlockCache
+}
+
+// OpenPartitions reads the top-level index of the table with the data file
+// binpath. It returns nil if the table is not partitioned. Partitions are
+// cached in the cache, which may be nil.
+func OpenPartitions(binpath string, cache *BlockCache) (*Partitions, error) {
+	f, err := os.Open(partitionFileForBin(binpath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < partitionFooterSize {
+		return nil, Corrupted(f.Name(), 0, fmt.Errorf("%w: size %d less than footer %d", ErrCorruption, stat.Size(), partitionFooterSize))
+	}
+
+	var footer [partitionFooterSize]byte
+	if _, err := f.ReadAt(footer[:], stat.Size()-partitionFooterSize); err != nil {
+		return nil, err
+	}
+	top := int64(decodeUInt64(footer[0:8]))
+	n := decodeUInt32(footer[8:12])
+	if top < 0 || top > stat.Size()-partitionFooterSize {
+		return nil, Corrupted(f.Name(), stat.Size()-partitionFooterSize, fmt.Errorf("%w: top-level index at %d", ErrCorruption, top))
+	}
+
+	p := &Partitions{path: f.Name(), cache: cache, handles: make([]partitionHandle, 0, n)}
+	r := io.NewSectionReader(f, top, stat.Size()-partitionFooterSize-top)
+	pos := top
+	for i := uint32(0); i < n; i++ {
+		key, value, err := Decode(r)
+		if err != nil {
+			return nil, Corrupted(f.Name(), pos, err)
+		}
+		h, err := decodePartitionHandle(key, value)
+		if err != nil {
+			return nil, Corrupted(f.Name(), pos, err)
+		}
+		pos += recordSize(key, value)
+
+		p.handles = append(p.handles, h)
+	}
+
+	return p, nil
+}
+
+// Len returns the number of partitions.
+func (p *Partitions) Len() int {
+	return len(p.handles)
+}
+
+// Size returns the memory used by the top-level index in bytes.
+func (p *Partitions) Size() int {
+	var size int
+	for _, h := range p.handles {
+		size += len(h.Key) + partitionHandleSize
+	}
+
+	return size
+}
+
+// search returns the block of the index file where the key may be.
+// It is false if the key is before the first partition or the filter
+// of its partition does not contain it.
+func (p *Partitions) search(key []byte) (indexBlock, bool, error) {
+	i := sort.Search(len(p.handles), func(i int) bool {
+		return bytes.Compare(p.handles[i].Key, key) > 0
+	}) - 1
+	if i < 0 {
+		return indexBlock{}, false, nil
+	}
+	h := p.handles[i]
+
+	filter, err := p.filter(h)
+	if err != nil {
+		return indexBlock{}, false, err
+	}
+	if !filter.TestByte(key) {
+		return indexBlock{}, false, nil
+	}
+
+	index, err := p.index(h)
+	if err != nil {
+		return indexBlock{}, false, err
+	}
+	// первая запись секции - ее первый ключ, он не больше искомого
+	j := sort.Search(len(index), func(j int) bool {
+		return bytes.Compare(index[j].Key, key) > 0
+	}) - 1
+	if j < 0 {
+		return indexBlock{}, false, nil
+	}
+
+	block := indexBlock{From: int64(index[j].Offset), To: h.IdxEnd, Restarts: index[j].Restarts}
+	if j+1 < len(index) {
+		block.To = int64(index[j+1].Offset)
+	}
+
+	return block, true, nil
+}
+
+// filter возвращает фильтр секции.
+func (p *Partitions) filter(h partitionHandle) (*bloom.Filter, error) {
+	v, err := p.block(h.FilterPos, h.FilterLen, func(buf []byte) (any, int64, error) {
+		f := new(bloom.Filter)
+		if err := f.UnmarshalBinary(buf); err != nil {
+			return nil, 0, fmt.Errorf("%w: %w", ErrCorruption, err)
+		}
+		return f, int64(f.Size()), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*bloom.Filter), nil
+}
+
+// index возвращает записи разреженного индекса секции.
+func (p *Partitions) index(h partitionHandle) ([]SSTIndex, error) {
+	v, err := p.block(h.IndexPos, h.IndexLen, func(buf []byte) (any, int64, error) {
+		var (
+			index []SSTIndex
+			r     = bytes.NewReader(buf)
+			pos   int64
+		)
+		for r.Len() > 0 {
+			key, value, err := Decode(r)
+			if err != nil {
+				return nil, 0, Corrupted("", pos, err)
+			}
+			offset, restarts, err := decodeSparseValue(value)
+			if err != nil {
+				return nil, 0, Corrupted("", pos, err)
+			}
+			pos += recordSize(key, value)
+
+			index = append(index, SSTIndex{Key: key, Offset: offset, Restarts: restarts})
+		}
+		return index, int64(len(buf)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]SSTIndex), nil
+}
+
+// block возвращает декодированный блок из кэша или читает его из файла
+// секций и добавляет в кэш.
+func (p *Partitions) block(pos int64, n uint32, decode func([]byte) (any, int64, error)) (any, error) {
+	key := blockKey{path: p.path, pos: pos}
+	if v, ok := p.cache.get(key); ok {
+		return v, nil
+	}
+
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, pos); err != nil {
+		return nil, Corrupted(p.path, pos, err)
+	}
+
+	v, charge, err := decode(buf)
+	if err != nil {
+		return nil, Corrupted(p.path, pos, err)
+	}
+	p.cache.add(key, v, charge)
+
+	return v, nil
+}
@@ -0,0 +1,157 @@
+package sst
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// writeTable пишет таблицу из n ключей key-%05d со значениями value-%05d.
+func writeTable(t *testing.T, dir string, seq uint64, n int, options ...OptionWriter) SSTFile {
+	t.Helper()
+
+	w, err := NewWriter(dir, append([]OptionWriter{AtLevel(BaseLevel), SeqNum(seq)}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := w.Write([]byte(fmt.Sprintf("key-%05d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.AddIdxBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := NewMemMetaSST(w.NameSparseFile(), BaseLevel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestPartitions(t *testing.T) {
+	const n = 10000
+	dir := t.TempDir()
+	cache := NewBlockCache(1 << 20)
+
+	file := writeTable(t, dir, 1, n, SparseKeyDistance(16), Partitioned(500, 100))
+	p, err := OpenPartitions(PathBy(dir, BaseLevel, 1), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil {
+		t.Fatal("partitions of partitioned table are not opened")
+	}
+	// секция закрывается на первой записи разреженного индекса после 500 ключей
+	if p.Len() != n/512+1 {
+		t.Fatalf("table has %d partitions, want %d", p.Len(), n/512+1)
+	}
+	file.Partitions = p
+	lvls := []SSTLevel{{Files: []SSTFile{file}}}
+
+	for i := 0; i < n; i++ {
+		value, ok, err := SearchInDiskTables([]byte(fmt.Sprintf("key-%05d", i)), dir, lvls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || !bytes.Equal(value, []byte(fmt.Sprintf("value-%05d", i))) {
+			t.Fatalf("key-%05d: got %q %t, want value-%05d", i, value, ok, i)
+		}
+	}
+	for _, key := range []string{"a", "key-", "key-00000a", "key-99999", "z"} {
+		if _, ok, err := SearchInDiskTables([]byte(key), dir, lvls); err != nil || ok {
+			t.Fatalf("%s: got %t %v, want not found", key, ok, err)
+		}
+	}
+
+	// каждая секция прочитана с диска один раз
+	if cache.Len() != 2*p.Len() {
+		t.Fatalf("cache has %d blocks, want %d", cache.Len(), 2*p.Len())
+	}
+	if _, misses := cache.Stats(); misses != uint64(2*p.Len()) {
+		t.Fatalf("cache missed %d times, want %d", misses, 2*p.Len())
+	}
+
+	results := MultiSearchInDiskTables([][]byte{[]byte("key-00001"), []byte("key-00001a"), []byte("key-09999")}, dir, lvls)
+	for i, want := range []string{"value-00001", "", "value-09999"} {
+		if results[i].Err != nil || results[i].Found != (want != "") || string(results[i].Value) != want {
+			t.Fatalf("result %d: got %+v, want %q", i, results[i], want)
+		}
+	}
+}
+
+func TestPartitionsNotPartitioned(t *testing.T) {
+	dir := t.TempDir()
+	writeTable(t, dir, 1, 10)
+
+	p, err := OpenPartitions(PathBy(dir, BaseLevel, 1), nil)
+	if err != nil || p != nil {
+		t.Fatalf("got %v %v, want no partitions", p, err)
+	}
+}
+
+func TestPartitionsRemove(t *testing.T) {
+	dir, dst := t.TempDir(), t.TempDir()
+	writeTable(t, dir, 1, 10, SparseKeyDistance(2), Partitioned(4, 100))
+
+	if err := Link(dir, dst, BaseLevel, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := Remove(dir, BaseLevel, 1); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("%d files left after remove", len(entries))
+	}
+
+	p, err := OpenPartitions(PathBy(dst, BaseLevel, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil || p.Len() != 3 {
+		t.Fatalf("linked table has partitions %v, want 3", p)
+	}
+}
+
+func TestBlockCache(t *testing.T) {
+	c := NewBlockCache(100)
+	for i := 0; i < 5; i++ {
+		c.add(blockKey{path: "a", pos: int64(i)}, i, 30)
+	}
+	// в кэш помещаются только три последних блока
+	if c.Len() != 3 || c.Size() != 90 {
+		t.Fatalf("cache has %d blocks of %d bytes, want 3 of 90", c.Len(), c.Size())
+	}
+	if _, ok := c.get(blockKey{path: "a", pos: 1}); ok {
+		t.Fatal("evicted block is in cache")
+	}
+
+	// использованный блок вытесняется последним
+	if v, ok := c.get(blockKey{path: "a", pos: 2}); !ok || v != 2 {
+		t.Fatalf("got %v %t, want 2", v, ok)
+	}
+	c.add(blockKey{path: "b", pos: 0}, 0, 30)
+	if _, ok := c.get(blockKey{path: "a", pos: 2}); !ok {
+		t.Fatal("recently used block is evicted")
+	}
+	if _, ok := c.get(blockKey{path: "a", pos: 3}); ok {
+		t.Fatal("least recently used block is not evicted")
+	}
+
+	c.add(blockKey{path: "c", pos: 0}, 0, 101)
+	if _, ok := c.get(blockKey{path: "c", pos: 0}); ok {
+		t.Fatal("block larger than cache is cached")
+	}
+
+	var nilCache *BlockCache
+	nilCache.add(blockKey{}, 0, 1)
+	if _, ok := nilCache.get(blockKey{}); ok || nilCache.Len() != 0 {
+		t.Fatal("nil cache caches blocks")
+	}
+}
@@ -2,8 +2,10 @@ package sst
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"runtime"
 )
 
 const (
@@ -11,67 +13,358 @@ const (
 	minSize  = 4 << 10
 )
 
+// Reader looks up keys in a table. The sparse index file has a fixed layout:
+// the entries are followed by an array of their offsets, so the reader
+// binary-searches the sparse index reading O(log n) entries with ReadAt
+// instead of decoding the whole file.
+//
+// With Mmap the files are mapped into memory and the reader accesses the
+// index and data as slices of the mappings without copies and system calls.
+// Values returned by Get are copied out of the mappings. A mapped reader
+// may be kept open for many lookups, it is closed by Close or, if it is
+// not closed, when it becomes unreachable.
 type Reader struct {
-	binf    *os.File
-	idxf    *os.File
-	sparsef *os.File
+	bin    source
+	idx    source
+	sparse source
 
-	sprstat os.FileInfo
+	header Header
 
-	buf          *bytes.Buffer
-	sizeIdxBlock uint32
-	lenKeys      uint32
-	seqNum       uint32
+	mmap   bool
+	access Access
+	closed bool
 }
 
-func New(filepath string) (*Reader, error) {
+// OptionReader configures a Reader.
+type OptionReader func(r *Reader)
+
+// Mmap makes the reader map the files of the table into memory and advise
+// the kernel of the access pattern. On platforms without mmap the reader
+// reads the files with ReadAt.
+func Mmap(access Access) OptionReader {
+	return func(r *Reader) {
+		r.mmap, r.access = true, access
+	}
+}
+
+// New opens the table with the data file filepath.
+func New(filepath string, options ...OptionReader) (*Reader, error) {
+	r := &Reader{}
+	for _, opt := range options {
+		opt(r)
+	}
+
 	bin, idx, spr, err := OpenBy(filepath)
 	if err != nil {
 		return nil, err
 	}
 
-	stat, err := spr.Stat()
+	files := []*os.File{bin, idx, spr}
+	sources := make([]source, 0, len(files))
+	for _, f := range files {
+		src, err := r.open(f)
+		if err != nil {
+			for _, s := range sources {
+				s.close()
+			}
+			for _, f := range files[len(sources)+1:] {
+				f.Close()
+			}
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+	r.bin, r.idx, r.sparse = sources[0], sources[1], sources[2]
+
+	if r.header, err = readSparseHeader(r.sparse, r.sparse.size()); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to read footer of sparse index file %s: %w", r.sparse.name(), Corrupted(r.sparse.name(), 0, err))
+	}
+	if r.mmap {
+		runtime.SetFinalizer(r, (*Reader).Close)
+	}
+
+	return r, nil
+}
+
+// open возвращает источник байтов файла. Отображенный в память файл
+// закрывается сразу, отображение остается.
+func (r *Reader) open(f *os.File) (source, error) {
+	if r.mmap {
+		src, err := newMmapSource(f, r.access)
+		if !errors.Is(err, errMmapUnsupported) {
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			return src, nil
+		}
+		r.mmap = false
+	}
+
+	src, err := newFileSource(f)
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
 
-	r := &Reader{
-		binf:    bin,
-		idxf:    idx,
-		sparsef: spr,
-		sprstat: stat,
-		buf:     bytes.NewBuffer(make([]byte, minSize)),
+	return src, nil
+}
+
+// Mapped tells if the files of the table are mapped into memory.
+func (r *Reader) Mapped() bool {
+	return r.mmap
+}
+
+// Get returns the value of the key and false if the table has no such key.
+func (r *Reader) Get(key []byte) ([]byte, bool, error) {
+	block, ok, err := r.search(key)
+	if err != nil || !ok {
+		return nil, false, err
 	}
 
-	neededbytes := 3 * minBytes
+	return r.get(key, block)
+}
 
-	buf := r.buf.AvailableBuffer()[:neededbytes]
+// NewIterator returns an iterator over the records of the table starting
+// from the first key not less than start, nil start means the first key.
+// The iterator seeks the start by the sparse index and the index block
+// and then reads the data file sequentially. It must not be used after
+// the reader is closed.
+func (r *Reader) NewIterator(start []byte) (*TableIterator, error) {
+	it := &TableIterator{r: r}
+	if start != nil {
+		block, ok, err := r.search(start)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			buf, err := r.indexBlock(block)
+			if err != nil {
+				return nil, err
+			}
+			offset, found, err := floorInIndexBlock(buf, block.Restarts, start)
+			if err != nil {
+				return nil, fmt.Errorf("failed to seek in index file %s: %w", r.idx.name(), Corrupted(r.idx.name(), block.From, err))
+			}
+			if found {
+				it.pos = int64(offset)
+			}
+		}
+	}
 
-	pos := r.sprstat.Size() - int64(neededbytes)
-	if _, err := r.sparsef.ReadAt(buf, pos); err != nil {
-		return nil, err
+	it.read()
+	// ключ, с которого начато чтение, может быть меньше start
+	for it.HasNext() && start != nil && bytes.Compare(it.key, start) < 0 {
+		it.read()
 	}
 
-	r.lenKeys = decodeUInt32(buf[:minBytes])
-	r.seqNum = decodeUInt32(buf[:minBytes])
-	r.sizeIdxBlock = decodeUInt32(buf[:minBytes])
+	return it, nil
+}
+
+// TableIterator iterates over the records of the table in the order of keys,
+// see Reader.NewIterator.
+type TableIterator struct {
+	r   *Reader
+	pos int64
+
+	key, value []byte
+	err        error
+}
 
-	offsets := r.buf.AvailableBuffer()
-	start := int64(r.lenKeys * 16)
-	end := int64(r.lenKeys*16) + int64(r.lenKeys*16) - int64(neededbytes)
+// HasNext tells if the iterator has more records or an error to return.
+func (it *TableIterator) HasNext() bool {
+	return it.key != nil || it.err != nil
+}
 
-	n, err := r.sparsef.ReadAt(offsets[start:end], start)
-	if n < len(offsets[start:end]) {
-		return nil, fmt.Errorf("read %d < n %d", len(offsets[start:end]), n)
+// Next returns the next key and its value.
+func (it *TableIterator) Next() ([]byte, []byte, error) {
+	if it.err != nil {
+		err := it.err
+		it.err = nil
+		return nil, nil, err
 	}
 
-	return r, nil
+	key, value := it.key, it.value
+	it.read()
+
+	return key, value, nil
 }
 
-func (r *Reader) search(key []byte) {
-	low, high := 0, int(r.lenKeys)
+// read читает запись на текущей позиции файла данных.
+func (it *TableIterator) read() {
+	defer runtime.KeepAlive(it.r)
+
+	it.key, it.value = nil, nil
+	if it.pos >= it.r.bin.size() {
+		return
+	}
+
+	key, value, err := recordAt(it.r.bin, it.pos, it.r.bin.size())
+	if err != nil {
+		it.err = fmt.Errorf("failed to read data file %s: %w", it.r.bin.name(), Corrupted(it.r.bin.name(), it.pos, err))
+		return
+	}
+	it.pos += recordSize(key, value)
+
+	if it.r.mmap {
+		// записи не должны ссылаться на отображение после его закрытия
+		key, value = bytes.Clone(key), bytes.Clone(value)
+	}
+	it.key, it.value = key, value
+}
+
+// Close closes the files of the table or unmaps them.
+func (r *Reader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	runtime.SetFinalizer(r, nil)
+
+	var err error
+	for _, src := range []source{r.bin, r.idx, r.sparse} {
+		if cerr := src.close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// search returns the block of the index file of the largest sparse key
+// that is less than or equal to the key.
+func (r *Reader) search(key []byte) (indexBlock, bool, error) {
+	var (
+		low, high = 0, int(r.header.Keys)
+		// значение записи high, если она прочитана
+		next []byte
+	)
 	for low < high {
 		mid := (low + high) / 2
 
+		k, v, err := r.readSparse(mid)
+		if err != nil {
+			return indexBlock{}, false, err
+		}
+
+		if bytes.Compare(k, key) <= 0 {
+			low = mid + 1
+		} else {
+			high, next = mid, v
+		}
 	}
+	if low == 0 {
+		return indexBlock{}, false, nil
+	}
+
+	_, v, err := r.readSparse(low - 1)
+	if err != nil {
+		return indexBlock{}, false, err
+	}
+	from, restarts, err := decodeSparseValue(v)
+	if err != nil {
+		return indexBlock{}, false, Corrupted(r.sparse.name(), 0, err)
+	}
+
+	block := indexBlock{From: int64(from), To: r.idx.size(), Restarts: restarts}
+	if next != nil {
+		to, _, err := decodeSparseValue(next)
+		if err != nil {
+			return indexBlock{}, false, Corrupted(r.sparse.name(), 0, err)
+		}
+		block.To = int64(to)
+	}
+
+	return block, true, nil
+}
+
+// readSparse reads the i-th entry of the sparse index.
+func (r *Reader) readSparse(i int) ([]byte, []byte, error) {
+	pos := r.header.SparseEnd + int64(i)*2*minBytes
+	buf, err := r.sparse.bytes(pos, 2*minBytes)
+	if err != nil {
+		return nil, nil, Corrupted(r.sparse.name(), pos, fmt.Errorf("%w: failed to read offset %d: %w", ErrCorruption, i, err))
+	}
+	_, o := DecodeUint32Pair(buf)
+
+	off := int64(o)
+	key, value, err := recordAt(r.sparse, off, r.header.SparseEnd)
+	if err != nil {
+		return nil, nil, Corrupted(r.sparse.name(), off, err)
+	}
+
+	return key, value, nil
+}
+
+// sparseIndex reads all entries of the sparse index.
+func (r *Reader) sparseIndex() ([]SSTIndex, error) {
+	idxs := make([]SSTIndex, 0, r.header.Keys)
+	for pos := int64(0); pos < r.header.SparseEnd; {
+		key, value, err := recordAt(r.sparse, pos, r.header.SparseEnd)
+		if err != nil {
+			return nil, Corrupted(r.sparse.name(), pos, err)
+		}
+		offset, restarts, err := decodeSparseValue(value)
+		if err != nil {
+			return nil, Corrupted(r.sparse.name(), pos, err)
+		}
+		pos += recordSize(key, value)
+
+		idxs = append(idxs, SSTIndex{Key: key, Offset: offset, Restarts: restarts})
+	}
+
+	return idxs, nil
+}
+
+// indexBlock returns the bytes of the block of the index file.
+func (r *Reader) indexBlock(block indexBlock) ([]byte, error) {
+	if block.To < block.From {
+		return nil, Corrupted(r.idx.name(), block.From, fmt.Errorf("%w: block [%d, %d) of index", ErrCorruption, block.From, block.To))
+	}
+
+	buf, err := r.idx.bytes(block.From, int(block.To-block.From))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index file %s: %w", r.idx.name(), Corrupted(r.idx.name(), block.From, err))
+	}
+
+	return buf, nil
+}
+
+// get searches the key in the block of the index file and reads its value.
+func (r *Reader) get(key []byte, block indexBlock) ([]byte, bool, error) {
+	buf, err := r.indexBlock(block)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return r.find(key, buf, block)
+}
+
+// find searches the key in the bytes buf of the block of the index file
+// and reads its value from the data file.
+func (r *Reader) find(key, buf []byte, block indexBlock) ([]byte, bool, error) {
+	defer runtime.KeepAlive(r)
+
+	offset, ok, err := searchInIndexBlock(buf, block.Restarts, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to search in index file %s: %w", r.idx.name(), Corrupted(r.idx.name(), block.From, err))
+	}
+	if !ok {
+		return nil, false, nil
+	}
+
+	k, value, err := recordAt(r.bin, int64(offset), r.bin.size())
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to search in data file %s: %w", r.bin.name(), Corrupted(r.bin.name(), int64(offset), err))
+	}
+	if !bytes.Equal(k, key) {
+		return nil, false, nil
+	}
+	if r.mmap {
+		// значение не должно ссылаться на отображение после его закрытия
+		value = bytes.Clone(value)
+	}
+
+	return value, true, nil
 }
@@ -0,0 +1,232 @@
+package sst
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	for _, tt := range []struct {
+		n                 int
+		sparse, restarts  int32
+		wantSparse, wantR int
+	}{
+		{n: 0, sparse: 128, restarts: 16},
+		{n: 1, sparse: 128, restarts: 16, wantSparse: 1},
+		{n: 1000, sparse: 128, restarts: 16, wantSparse: 8, wantR: 7},
+		{n: 1000, sparse: 100, restarts: 7, wantSparse: 10, wantR: 14},
+		{n: 1000, sparse: 8, restarts: 16, wantSparse: 125},
+	} {
+		t.Run(fmt.Sprintf("%d/%d/%d", tt.n, tt.sparse, tt.restarts), func(t *testing.T) {
+			dir := t.TempDir()
+			writeTable(t, dir, 1, tt.n, SparseKeyDistance(tt.sparse), RestartInterval(tt.restarts))
+
+			r, err := New(PathBy(dir, BaseLevel, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			sparse, _, err := readSparseIndexFile(r.sparse.name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sparse) != tt.wantSparse || int(r.header.Keys) != tt.wantSparse {
+				t.Fatalf("sparse index has %d (%d) entries, want %d", len(sparse), r.header.Keys, tt.wantSparse)
+			}
+			if len(sparse) > 0 && len(sparse[0].Restarts) != tt.wantR {
+				t.Fatalf("block has %d restart points, want %d", len(sparse[0].Restarts), tt.wantR)
+			}
+
+			for i := 0; i < tt.n; i++ {
+				value, ok, err := r.Get([]byte(fmt.Sprintf("key-%05d", i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok || !bytes.Equal(value, []byte(fmt.Sprintf("value-%05d", i))) {
+					t.Fatalf("key-%05d: got %q %t, want value-%05d", i, value, ok, i)
+				}
+
+				// отсутствующие ключи между ключами таблицы
+				if _, ok, err := r.Get([]byte(fmt.Sprintf("key-%05da", i))); err != nil || ok {
+					t.Fatalf("key-%05da: got %t %v, want not found", i, ok, err)
+				}
+			}
+			for _, key := range []string{"a", "key-", "z"} {
+				if _, ok, err := r.Get([]byte(key)); err != nil || ok {
+					t.Fatalf("%s: got %t %v, want not found", key, ok, err)
+				}
+			}
+		})
+	}
+}
+
+func TestReaderSeqNum(t *testing.T) {
+	dir := t.TempDir()
+	// номер последовательности не помещается в uint32
+	const seq = 1<<33 + 7
+	writeTable(t, dir, seq, 10)
+
+	r, err := New(PathBy(dir, BaseLevel, seq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.header.Seq != seq {
+		t.Fatalf("footer has sequence number %d, want %d", r.header.Seq, seq)
+	}
+}
+
+func TestReaderIterator(t *testing.T) {
+	const n = 1000
+	dir := t.TempDir()
+	writeTable(t, dir, 1, n, SparseKeyDistance(100), RestartInterval(7))
+
+	for _, options := range [][]OptionReader{nil, {Mmap(SequentialAccess)}} {
+		r, err := New(PathBy(dir, BaseLevel, 1), options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range []struct {
+			start string
+			first int
+		}{
+			{"", 0},
+			{"a", 0},
+			{"key-00000", 0},
+			{"key-00099a", 100},
+			{"key-00100", 100},
+			{"key-00537", 537},
+			{"key-00999", 999},
+			{"z", n},
+		} {
+			var start []byte
+			if tt.start != "" {
+				start = []byte(tt.start)
+			}
+			it, err := r.NewIterator(start)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			i := tt.first
+			for it.HasNext() {
+				k, v, err := it.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := fmt.Sprintf("key-%05d", i); string(k) != want || string(v) != fmt.Sprintf("value-%05d", i) {
+					t.Fatalf("start %q: got %q %q, want %s", tt.start, k, v, want)
+				}
+				i++
+			}
+			if i != n {
+				t.Fatalf("start %q: iterated to %d, want %d", tt.start, i, n)
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestSearchInIndexBlock(t *testing.T) {
+	var (
+		block    bytes.Buffer
+		restarts []uint32
+	)
+	for i := 0; i < 100; i++ {
+		if i > 0 && i%10 == 0 {
+			restarts = append(restarts, uint32(block.Len()))
+		}
+		if _, err := EncodeKeyOffset(&block, []byte(fmt.Sprintf("key-%03d", 2*i)), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// блок без точек перезапуска, как в таблицах, записанных до них
+	for _, rs := range [][]uint32{restarts, nil} {
+		for i := 0; i < 200; i++ {
+			offset, ok, err := searchInIndexBlock(block.Bytes(), rs, []byte(fmt.Sprintf("key-%03d", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != (i%2 == 0) || ok && offset != i/2 {
+				t.Fatalf("key-%03d: got %d %t", i, offset, ok)
+			}
+		}
+	}
+
+	_, _, err := searchInIndexBlock(block.Bytes(), []uint32{uint32(block.Len())}, []byte("key-000"))
+	if !errors.Is(err, ErrCorruption) {
+		t.Fatalf("got %v for restart point beyond block, want %v", err, ErrCorruption)
+	}
+}
+
+func TestReaderCorruptedOffsets(t *testing.T) {
+	dir := t.TempDir()
+	writeTable(t, dir, 1, 100, SparseKeyDistance(4))
+
+	r, err := New(PathBy(dir, BaseLevel, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := r.header
+	r.Close()
+
+	// смещение записи за концом записей разреженного индекса
+	f, err := os.OpenFile(sparseFileForBin(PathBy(dir, BaseLevel, 1)), os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(EncodeUint32Pair(0, uint32(header.SparseEnd)), header.SparseEnd+8*int64(header.Keys/2)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r, err = New(PathBy(dir, BaseLevel, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, _, err := r.Get([]byte("key-00050")); !errors.Is(err, ErrCorruption) {
+		t.Fatalf("got %v, want %v", err, ErrCorruption)
+	}
+}
+
+func BenchmarkReaderGet(b *testing.B) {
+	const n = 100000
+	dir := b.TempDir()
+	w, err := NewWriter(dir, AtLevel(BaseLevel), SeqNum(1))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := w.Write([]byte(fmt.Sprintf("key-%06d", i)), []byte(fmt.Sprintf("value-%06d", i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := w.AddIdxBlock(); err != nil {
+		b.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	r, err := New(PathBy(dir, BaseLevel, 1))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, ok, err := r.Get([]byte(fmt.Sprintf("key-%06d", i*7919%n))); err != nil || !ok {
+			b.Fatal(ok, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package sst
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errMmapUnsupported is returned by mmapFile on platforms without mmap.
+var errMmapUnsupported = errors.New("mmap is not supported")
+
+// Access is the expected pattern of access to a mapped file, the kernel
+// uses it to read ahead. See Mmap.
+type Access int
+
+const (
+	// RandomAccess disables read-ahead, as lookups read a few pages
+	// at random positions.
+	RandomAccess Access = iota
+	// SequentialAccess makes the kernel read ahead aggressively and free
+	// pages soon after they are read, as scans read the whole file once.
+	SequentialAccess
+)
+
+// source gives access to the bytes of a file of a table: with ReadAt
+// or as slices of the file mapped into memory.
+type source interface {
+	io.ReaderAt
+	// bytes returns n bytes at the offset. Bytes of a mapped file are
+	// a slice of the mapping without a copy, they must not be modified
+	// and must not be used after close.
+	bytes(off int64, n int) ([]byte, error)
+	size() int64
+	name() string
+	close() error
+}
+
+// fileSource reads the file with ReadAt.
+type fileSource struct {
+	f *os.File
+	n int64
+}
+
+func newFileSource(f *os.File) (*fileSource, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileSource{f: f, n: stat.Size()}, nil
+}
+
+func (s *fileSource) ReadAt(b []byte, off int64) (int, error) {
+	return s.f.ReadAt(b, off)
+}
+
+func (s *fileSource) bytes(off int64, n int) ([]byte, error) {
+	if off < 0 || n < 0 || off+int64(n) > s.n {
+		return nil, fmt.Errorf("%w: %d bytes at offset %d beyond file of %d bytes", ErrCorruption, n, off, s.n)
+	}
+
+	b := make([]byte, n)
+	if _, err := s.f.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (s *fileSource) size() int64 {
+	return s.n
+}
+
+func (s *fileSource) name() string {
+	return s.f.Name()
+}
+
+func (s *fileSource) close() error {
+	return s.f.Close()
+}
+
+// mmapSource is the file mapped into memory.
+type mmapSource struct {
+	filename string
+	data     []byte
+}
+
+// newMmapSource maps the file into memory with the access pattern. The file
+// may be closed after that, the mapping stays valid until close.
+func newMmapSource(f *os.File, access Access) (*mmapSource, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := mmapFile(f, stat.Size(), access)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map file %s: %w", f.Name(), err)
+	}
+
+	return &mmapSource{filename: f.Name(), data: data}, nil
+}
+
+func (s *mmapSource) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 || off > int64(len(s.data)) {
+		return 0, fmt.Errorf("invalid offset %d", off)
+	}
+
+	n := copy(b, s.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (s *mmapSource) bytes(off int64, n int) ([]byte, error) {
+	if off < 0 || n < 0 || off+int64(n) > int64(len(s.data)) {
+		return nil, fmt.Errorf("%w: %d bytes at offset %d beyond file of %d bytes", ErrCorruption, n, off, len(s.data))
+	}
+
+	return s.data[off : off+int64(n) : off+int64(n)], nil
+}
+
+func (s *mmapSource) size() int64 {
+	return int64(len(s.data))
+}
+
+func (s *mmapSource) name() string {
+	return s.filename
+}
+
+func (s *mmapSource) close() error {
+	data := s.data
+	s.data = nil
+
+	return munmapFile(data)
+}
+
+// recordAt decodes the record at the offset of the source, which must end
+// before the end. Encode describes the format of records. The key and the
+// value of a mapped file are slices of the mapping.
+func recordAt(src source, off, end int64) ([]byte, []byte, error) {
+	if off+8 > end {
+		return nil, nil, fmt.Errorf("%w: record at %d beyond end %d", ErrCorruption, off, end)
+	}
+	b, err := src.bytes(off, 8)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	total := decodeUInt64(b)
+	if total < 8 || total > uint64(end-off-8) {
+		return nil, nil, fmt.Errorf("%w: record of %d bytes at %d beyond end %d", ErrCorruption, total, off, end)
+	}
+	if b, err = src.bytes(off+8, int(total)); err != nil {
+		return nil, nil, err
+	}
+
+	key, value, _, err := decodeBytes(b, int(total))
+	return key, value, err
+}
+
+// decodeBytes decodes the record of total bytes without the length prefix
+// from b without a copy and returns the key, the value and the size of
+// the record without the prefix.
+func decodeBytes(b []byte, total int) ([]byte, []byte, int, error) {
+	if total < 8 || total > len(b) {
+		return nil, nil, 0, fmt.Errorf("%w: record of %d bytes in %d bytes", ErrCorruption, total, len(b))
+	}
+
+	keyLen := decodeUInt64(b[0:8])
+	if keyLen > uint64(total-8) {
+		return nil, nil, 0, fmt.Errorf("%w: key of %d bytes in record of %d bytes", ErrCorruption, keyLen, total)
+	}
+	key := b[8 : 8+keyLen : 8+keyLen]
+
+	var value []byte
+	if int(8+keyLen) < total {
+		value = b[8+keyLen : total : total]
+	}
+
+	return key, value, total, nil
+}
+
+// nextRecord decodes the first record of b without a copy
+// and returns its key, its value and its size.
+func nextRecord(b []byte) ([]byte, []byte, int, error) {
+	if len(b) < 8 {
+		return nil, nil, 0, fmt.Errorf("%w: failed to read entry %d < %d", ErrCorruption, len(b), 8)
+	}
+
+	total := decodeUInt64(b[0:8])
+	if total > uint64(len(b)-8) {
+		return nil, nil, 0, fmt.Errorf("%w: failed to read entry %d < %d", ErrCorruption, len(b)-8, total)
+	}
+
+	key, value, n, err := decodeBytes(b[8:], int(total))
+	return key, value, 8 + n, err
+}
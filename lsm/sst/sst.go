@@ -1,16 +1,29 @@
 package sst
 
 import (
+	"bytes"
+
 	"github.com/wubba-com/lsm-distributed/lsm/bloom"
 )
 
+// Header is the decoded footer of the sparse index file.
 type Header struct {
 	Seq uint64
+	// Number of keys in the sparse index.
+	Keys uint32
+	// Position of the offsets array, i.e. the end of the sparse index entries.
+	SparseEnd int64
+	// Position and the end of the meta block.
+	MetaPos, MetaEnd int64
+	// Number of range tombstones in the meta block.
+	RangeDels uint32
 }
 
 type SSTIndex struct {
 	Key    []byte
 	Offset int
+	// Restart points of the block of the index file, see searchInIndexBlock.
+	Restarts []uint32
 }
 
 type SSTLevel struct {
@@ -18,11 +31,65 @@ type SSTLevel struct {
 }
 
 type SSTFile struct {
-	Filter *bloom.Filter
+	Filter bloom.KeyFilter
 	Level  Level
 	SeqNum uint64
+
+	// The smallest and the largest keys of the table.
+	Smallest, Largest []byte
+	// Range tombstones of the table loaded from the meta block.
+	RangeDels []RangeTombstone
+	// Size of the data file in bytes.
+	Size int64
+	// Top-level index of a partitioned table, nil if the table is not
+	// partitioned or the partitions are not opened, see OpenPartitions.
+	Partitions *Partitions
+	// Open reader of the table for lookups, for example, with the files
+	// mapped into memory. If nil, each lookup opens the table.
+	Reader *Reader
+}
+
+// Covers tells if the key is deleted by a range tombstone of the table.
+func (f SSTFile) Covers(key []byte) bool {
+	for _, rd := range f.RangeDels {
+		if rd.Contains(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Overlaps tells if the keys or the range tombstones of the table
+// may intersect the keys [smallest, largest].
+func (f SSTFile) Overlaps(smallest, largest []byte) bool {
+	if len(f.Smallest) > 0 && bytes.Compare(f.Smallest, largest) <= 0 && bytes.Compare(smallest, f.Largest) <= 0 {
+		return true
+	}
+	for _, rd := range f.RangeDels {
+		if bytes.Compare(rd.Start, largest) <= 0 && bytes.Compare(smallest, rd.End) < 0 {
+			return true
+		}
+	}
+
+	return false
 }
 
 type ElemSST struct {
 	Key, Val []byte
 }
+
+// RangeTombstone marks all keys in [Start, End) as deleted.
+type RangeTombstone struct {
+	Start, End []byte
+}
+
+// Contains tells if the key is in [Start, End).
+func (rt RangeTombstone) Contains(key []byte) bool {
+	return bytes.Compare(rt.Start, key) <= 0 && bytes.Compare(key, rt.End) < 0
+}
+
+// ContainsRange tells if the whole [smallest, largest] is in [Start, End).
+func (rt RangeTombstone) ContainsRange(smallest, largest []byte) bool {
+	return bytes.Compare(rt.Start, smallest) <= 0 && bytes.Compare(largest, rt.End) < 0
+}
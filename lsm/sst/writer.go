@@ -4,6 +4,16 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path"
+
+	"github.com/wubba-com/lsm-distributed/lsm/ratelimit"
+)
+
+const (
+	// Default distance between keys in sparse index.
+	defaultSparseKeyDistance = 128
+	// Default distance between restart points of blocks of the index file.
+	defaultRestartInterval = 16
 )
 
 type OptionWriter func(w *Writer)
@@ -14,35 +24,67 @@ func SparseKeyDistance(sparseKeyDistance int32) OptionWriter {
 	}
 }
 
-func NewWriter(dirname string, options ...OptionWriter) (*Writer, error) {
-	binName, err := NextFilename(dirname)
-	if err != nil {
-		return nil, err
+// RestartInterval sets the distance between restart points of blocks
+// of the index file, see searchInIndexBlock.
+func RestartInterval(restartInterval int32) OptionWriter {
+	return func(w *Writer) {
+		w.restartInterval = max(restartInterval, 1)
 	}
+}
 
-	bin, idx, spr, err := NewSSTFiles(dirname, binName)
-	if err != nil {
-		return nil, err
+// AtLevel sets the level of the table.
+func AtLevel(level Level) OptionWriter {
+	return func(w *Writer) {
+		w.level = level
 	}
+}
 
-	w := &Writer{
-		fd:         bin,
-		fidx:       idx,
-		fsparseIdx: spr,
+// SeqNum sets the sequence number of the table.
+func SeqNum(seqNum uint64) OptionWriter {
+	return func(w *Writer) {
+		w.seqNum = seqNum
+	}
+}
 
-		bd:         bufio.NewWriter(bin),
-		bidx:       bufio.NewWriter(idx),
-		bsparseIdx: bufio.NewWriter(spr),
-		keyNum:     0,
-		dataPos:    0,
-		indexPos:   0,
-		n:          0,
+// RateLimit limits the rate of writes of the table with the limiter at the priority.
+func RateLimit(limiter *ratelimit.Limiter, pri ratelimit.Priority) OptionWriter {
+	return func(w *Writer) {
+		w.limiter, w.priority = limiter, pri
+	}
+}
+
+func NewWriter(dirname string, options ...OptionWriter) (*Writer, error) {
+	w := &Writer{
+		sparseKeyDistance: defaultSparseKeyDistance,
+		restartInterval:   defaultRestartInterval,
 	}
 
 	for _, opt := range options {
 		opt(w)
 	}
 
+	if w.name == "" {
+		w.name = nameBy(w.level, w.seqNum, ExtBin)
+	}
+
+	bin, idx, spr, err := NewSSTFiles(dirname, w.name)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.part != nil {
+		p := path.Join(dirname, partitionFileForBin(w.name))
+		if w.part.f, err = os.OpenFile(p, newflags, 0600); err != nil {
+			return nil, fmt.Errorf("failed to open file %s: %w", p, err)
+		}
+		w.part.b = bufio.NewWriter(w.part.f)
+	}
+
+	w.fd, w.fidx, w.fsparseIdx = bin, idx, spr
+	w.bd = bufio.NewWriter(bin)
+	w.bidx = bufio.NewWriter(idx)
+	w.bsparseIdx = bufio.NewWriter(spr)
+
 	return w, nil
 }
 
@@ -57,46 +99,99 @@ type Writer struct {
 
 	idxB bool
 
+	level  Level
+	seqNum uint64
+	name   string
+
+	smallest, largest []byte
+	rangeDels         []RangeTombstone
+
+	limiter  *ratelimit.Limiter
+	priority ratelimit.Priority
+
+	// Секции индекса и фильтров, если таблица секционирована, см. Partitioned.
+	part *partitioner
+
+	// Текущий блок индексного файла. Запись разреженного индекса блока
+	// пишется после его последнего ключа, когда известны точки перезапуска.
+	block sparseBlock
+
 	offsets                   []int32
 	sparseKeyDistance         int32
+	restartInterval           int32
 	keyNum                    int32
 	dataPos, indexPos, sprPos int
 	n                         int
 }
 
-func (w *Writer) AddIdxBlock(seqNum uint64) error {
+// AddIdxBlock writes the offsets of the sparse index, the meta block
+// and the footer to the end of the sparse index file:
+//
+//	[sparse entries][offsets][meta: key range, range tombstones][footer]
+func (w *Writer) AddIdxBlock() error {
 	var (
 		err error
 		n   int
 	)
+	if _, err = w.flushBlock(); err != nil {
+		return err
+	}
+
+	offsetsPos := w.sprPos
 	for idx, off := range w.offsets {
 		if n, err = WriteUInt32Pair(w.bsparseIdx, uint32(idx), uint32(off)); err != nil {
 			return err
 		}
 		w.sprPos += n
 	}
-	
-	if n, err = writeUint32(w.bsparseIdx, uint32(len(w.offsets))); err != nil {
+
+	metaPos := w.sprPos
+	if n, err = Encode(w.bsparseIdx, w.smallest, w.largest); err != nil {
 		return err
 	}
 	w.sprPos += n
 
-	if n, err = writeUint32(w.bsparseIdx, uint32(seqNum)); err != nil {
+	for _, rd := range w.rangeDels {
+		if n, err = Encode(w.bsparseIdx, rd.Start, rd.End); err != nil {
+			return err
+		}
+		w.sprPos += n
+	}
+
+	if n, err = writeUint32(w.bsparseIdx, uint32(len(w.offsets))); err != nil {
 		return err
 	}
 	w.sprPos += n
-
-
-	if n, err = writeUint32(w.bsparseIdx, uint32(w.sprPos)); err != nil {
+	if n, err = writeUint64(w.bsparseIdx, w.seqNum); err != nil {
 		return err
 	}
 	w.sprPos += n
+	for _, x := range []int{offsetsPos, metaPos, len(w.rangeDels)} {
+		if n, err = writeUint32(w.bsparseIdx, uint32(x)); err != nil {
+			return err
+		}
+		w.sprPos += n
+	}
+	if w.part != nil {
+		if err := w.part.finish(w.indexPos); err != nil {
+			return fmt.Errorf("failed to write to the partitions file: %w", err)
+		}
+	}
 	w.idxB = true
 
 	return nil
 }
 
+// AddRangeDel adds the range tombstone [start, end) to the meta block of the table.
+func (w *Writer) AddRangeDel(start, end []byte) {
+	w.rangeDels = append(w.rangeDels, RangeTombstone{Start: start, End: end})
+}
+
 func (w *Writer) Write(key, val []byte) error {
+	if w.keyNum == 0 {
+		w.smallest = key
+	}
+	w.largest = key
 
 	dBytes, err := Encode(w.bd, key, val)
 	if err != nil {
@@ -109,13 +204,21 @@ func (w *Writer) Write(key, val []byte) error {
 	}
 
 	var sprBytes = 0
-	if w.keyNum%w.sparseKeyDistance == 0 {
-		if sprBytes, err = EncodeKeyOffset(w.bsparseIdx, key, int(w.indexPos)); err != nil {
-			return fmt.Errorf("failed to write to the file: %w", err)
+	if i := w.keyNum % w.sparseKeyDistance; i == 0 {
+		if sprBytes, err = w.flushBlock(); err != nil {
+			return err
 		}
-
-		w.offsets = append(w.offsets, int32(w.sprPos))
-		w.sprPos += sprBytes
+		if w.part != nil && w.part.full() {
+			if err := w.part.cut(w.indexPos); err != nil {
+				return fmt.Errorf("failed to write to the partitions file: %w", err)
+			}
+		}
+		w.block = sparseBlock{key: key, pos: w.indexPos, open: true}
+	} else if i%w.restartInterval == 0 {
+		w.block.restarts = append(w.block.restarts, uint32(w.indexPos-w.block.pos))
+	}
+	if w.part != nil {
+		w.part.add(key)
 	}
 
 	w.dataPos += dBytes
@@ -123,9 +226,44 @@ func (w *Writer) Write(key, val []byte) error {
 	w.keyNum++
 	w.n += len(key) + len(val)
 
+	w.limiter.Request(dBytes+idxBytes+sprBytes, w.priority)
+
 	return nil
 }
 
+// sparseBlock is the block of the index file being written.
+type sparseBlock struct {
+	key      []byte
+	pos      int
+	restarts []uint32
+	open     bool
+}
+
+// flushBlock writes the sparse index entry of the current block
+// and returns the number of bytes written.
+func (w *Writer) flushBlock() (int, error) {
+	if !w.block.open {
+		return 0, nil
+	}
+
+	value := encodeSparseValue(w.block.pos, w.block.restarts)
+	n, err := Encode(w.bsparseIdx, w.block.key, value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write to the sparse index file: %w", err)
+	}
+	w.offsets = append(w.offsets, int32(w.sprPos))
+	w.sprPos += n
+
+	if w.part != nil {
+		if err := w.part.addSparse(w.block.key, value); err != nil {
+			return 0, fmt.Errorf("failed to write to the partitions file: %w", err)
+		}
+	}
+	w.block = sparseBlock{}
+
+	return n, nil
+}
+
 func (w *Writer) Bytes() int {
 	return w.n
 }
@@ -134,6 +272,14 @@ func (w *Writer) Len() int {
 	return int(w.keyNum)
 }
 
+func (w *Writer) Level() Level {
+	return w.level
+}
+
+func (w *Writer) SeqNum() uint64 {
+	return w.seqNum
+}
+
 func (w *Writer) NameSparseFile() string {
 	return w.fsparseIdx.Name()
 }
@@ -178,6 +324,9 @@ func (w *Writer) Close() error {
 	if err := w.fsparseIdx.Close(); err != nil {
 		return fmt.Errorf("err close at the close: %s", err)
 	}
+	if w.part != nil {
+		return w.part.close()
+	}
 
 	return nil
 }
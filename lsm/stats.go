@@ -0,0 +1,54 @@
+package lsm
+
+import "time"
+
+// Stats describes the state of the db.
+type Stats struct {
+	// The worst write state of the column families.
+	WriteState WriteState
+	// The number of writes delayed and stopped by the write controller,
+	// and the total time they waited.
+	DelayedWrites uint64
+	StoppedWrites uint64
+	StallDuration time.Duration
+
+	ColumnFamilies map[string]ColumnFamilyStats
+}
+
+// ColumnFamilyStats describes the state of a column family.
+type ColumnFamilyStats struct {
+	WriteState             WriteState
+	L0Files                int
+	PendingCompactionBytes uint64
+}
+
+// Stats returns the current stats of the db.
+func (t *LSMTree) Stats() Stats {
+	t.lock.RLock()
+	cfs := make(map[string]ColumnFamilyStats, len(t.cfs))
+	for name, cf := range t.cfs {
+		cfs[name] = cf.columnFamilyStats()
+	}
+	t.lock.RUnlock()
+
+	t.stallMu.Lock()
+	stats := t.stats
+	t.stallMu.Unlock()
+
+	stats.ColumnFamilies = cfs
+	for _, cf := range cfs {
+		if cf.WriteState > stats.WriteState {
+			stats.WriteState = cf.WriteState
+		}
+	}
+
+	return stats
+}
+
+// Stats returns the current stats of the column family.
+func (cf *ColumnFamily) Stats() ColumnFamilyStats {
+	cf.db.lock.RLock()
+	defer cf.db.lock.RUnlock()
+
+	return cf.columnFamilyStats()
+}
@@ -0,0 +1,609 @@
+package lsm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/wubba-com/lsm-distributed/lsm/encoder"
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+var (
+	// ErrConflict is returned by Txn.Commit when a key read by the transaction
+	// was changed by another write. The error is wrapped into ConflictError.
+	ErrConflict = errors.New("transaction conflict")
+	// ErrTxnDone is returned when using a committed or discarded transaction.
+	ErrTxnDone = errors.New("transaction already committed or discarded")
+)
+
+// ConflictError describes the key which made the transaction fail.
+type ConflictError struct {
+	ColumnFamily string
+	Key          []byte
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: key %q of column family %s was changed", ErrConflict, e.Key, e.ColumnFamily)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+type txnKey struct {
+	cf  *ColumnFamily
+	key string
+}
+
+// txnRange это полуинтервал ключей column family, прочитанный или удаленный
+// после записи с номером seq.
+type txnRange struct {
+	cf         *ColumnFamily
+	start, end []byte
+	seq        uint64
+}
+
+func (r txnRange) overlaps(start, end []byte) bool {
+	return (r.end == nil || start == nil || bytes.Compare(start, r.end) < 0) &&
+		(end == nil || r.start == nil || bytes.Compare(r.start, end) < 0)
+}
+
+type txnRead struct {
+	txnKey
+	seq uint64
+}
+
+// txnImage это значение ключа до записи с номером seq: закодированное
+// значение или nil, если ключа не было.
+type txnImage struct {
+	seq   uint64
+	value []byte
+}
+
+// oracle нумерует записи дерева. Пока есть активные оптимистичные
+// транзакции, он запоминает номер последней записи каждого ключа, чтобы при
+// фиксации проверить, не изменились ли прочитанные транзакцией ключи,
+// и значения ключей до записей, чтобы транзакция читала дерево на момент
+// своего начала. Записи, сделанные до начала самой старой активной
+// транзакции, забываются. Все поля защищены блокировкой дерева.
+type oracle struct {
+	// Номер последней записи.
+	seq uint64
+	// Номер последней начатой транзакции.
+	txnID uint64
+	// Номера записей, после которых начаты активные оптимистичные
+	// транзакции, по номерам транзакций.
+	active map[uint64]uint64
+
+	writes      map[txnKey]uint64
+	rangeWrites []txnRange
+	// Значения ключей до записей в порядке записей.
+	images map[txnKey][]txnImage
+	// Открепляют blob-файлы, на которые ссылаются значения images.
+	pins map[*ColumnFamily]func()
+}
+
+// begin регистрирует транзакцию и возвращает номер последней записи,
+// на момент которой транзакция читает дерево.
+func (o *oracle) begin(id uint64) uint64 {
+	if o.active == nil {
+		o.active = make(map[uint64]uint64)
+	}
+	o.active[id] = o.seq
+
+	return o.seq
+}
+
+// end снимает транзакцию с учета и забывает записи, которые не нужны
+// оставшимся транзакциям.
+func (o *oracle) end(id uint64) {
+	start := o.active[id]
+	delete(o.active, id)
+
+	if len(o.active) == 0 {
+		for _, unpin := range o.pins {
+			unpin()
+		}
+		o.writes, o.rangeWrites, o.images, o.pins = nil, nil, nil, nil
+		return
+	}
+
+	oldest := uint64(math.MaxUint64)
+	for _, seq := range o.active {
+		oldest = min(oldest, seq)
+	}
+	if oldest > start {
+		o.prune(oldest)
+	}
+}
+
+// prune забывает записи с номерами не больше seq: транзакции, начатые
+// после них, не проверяют их при фиксации и не читают значения до них.
+func (o *oracle) prune(seq uint64) {
+	for k, s := range o.writes {
+		if s <= seq {
+			delete(o.writes, k)
+		}
+	}
+	o.rangeWrites = slices.DeleteFunc(o.rangeWrites, func(r txnRange) bool {
+		return r.seq <= seq
+	})
+	for k, images := range o.images {
+		i := 0
+		for i < len(images) && images[i].seq <= seq {
+			i++
+		}
+		if i == len(images) {
+			delete(o.images, k)
+		} else {
+			o.images[k] = slices.Delete(images, 0, i)
+		}
+	}
+}
+
+// committed запоминает ключи записи и их значения до записи,
+// если есть активные транзакции.
+func (o *oracle) committed(ops []batchOp, images map[txnKey][]byte) {
+	o.seq++
+	if len(o.active) == 0 {
+		return
+	}
+
+	if o.writes == nil {
+		o.writes = make(map[txnKey]uint64)
+	}
+	for _, op := range ops {
+		if op.kind == encoder.OpKindDeleteRange {
+			o.rangeWrites = append(o.rangeWrites, txnRange{cf: op.cf, start: op.key, end: op.value, seq: o.seq})
+			continue
+		}
+		o.writes[txnKey{cf: op.cf, key: string(op.key)}] = o.seq
+	}
+
+	if o.images == nil {
+		o.images = make(map[txnKey][]txnImage)
+	}
+	for k, value := range images {
+		o.images[k] = append(o.images[k], txnImage{seq: o.seq, value: value})
+	}
+}
+
+// image возвращает значение ключа после записи с номером seq, если
+// ключ с тех пор изменялся. Значение nil означает, что ключа не было.
+func (o *oracle) image(cf *ColumnFamily, key []byte, seq uint64) ([]byte, bool) {
+	for _, img := range o.images[txnKey{cf: cf, key: string(key)}] {
+		if img.seq > seq {
+			return img.value, true
+		}
+	}
+
+	return nil, false
+}
+
+// pin закрепляет blob-файлы column family до завершения всех
+// активных транзакций.
+func (o *oracle) pin(cf *ColumnFamily) {
+	if _, ok := o.pins[cf]; ok {
+		return
+	}
+	if o.pins == nil {
+		o.pins = make(map[*ColumnFamily]func())
+	}
+	o.pins[cf] = cf.blobs.Pin()
+}
+
+// images возвращает значения ключей пакета до его записи, если их могут
+// прочитать активные транзакции. Для удаления диапазона запоминаются все
+// ключи диапазона. Вызывается под блокировкой дерева.
+func (t *LSMTree) images(ctx context.Context, ops []batchOp) (map[txnKey][]byte, error) {
+	if len(t.oracle.active) == 0 {
+		return nil, nil
+	}
+
+	images := make(map[txnKey][]byte)
+	for _, op := range ops {
+		if op.kind == encoder.OpKindDeleteRange {
+			it := &Iterator{ctx: ctx, cf: op.cf, encoded: true}
+			if err := it.open(op.key, op.value, nil); err != nil {
+				return nil, err
+			}
+			for it.HasNext() {
+				key, value, err := it.Next()
+				if err != nil {
+					return nil, err
+				}
+				t.addImage(images, op.cf, key, t.decoder.Decode(value))
+			}
+			continue
+		}
+
+		if _, ok := images[txnKey{cf: op.cf, key: string(op.key)}]; ok {
+			continue
+		}
+		dec, err := op.cf.get(ctx, op.key)
+		if err != nil {
+			return nil, err
+		}
+		t.addImage(images, op.cf, op.key, dec)
+	}
+
+	return images, nil
+}
+
+// addImage запоминает значение ключа, если оно еще не запомнено.
+// Значение nil означает, что ключа нет.
+func (t *LSMTree) addImage(images map[txnKey][]byte, cf *ColumnFamily, key []byte, dec *encoder.EncodedValue) {
+	k := txnKey{cf: cf, key: string(key)}
+	if _, ok := images[k]; ok {
+		return
+	}
+	if dec == nil {
+		images[k] = nil
+		return
+	}
+
+	kind := encoder.OpKindSet
+	if dec.IsBlobRef() {
+		kind = encoder.OpKindBlobRef
+		t.oracle.pin(cf)
+	}
+	images[k] = t.encoder.Encode(kind, dec.Value())
+}
+
+// conflict возвращает ошибку, если прочитанные ключи или диапазоны
+// были изменены после чтения.
+func (o *oracle) conflict(reads []txnRead, ranges []txnRange) error {
+	for _, r := range reads {
+		if seq, ok := o.writes[r.txnKey]; ok && seq > r.seq {
+			return &ConflictError{ColumnFamily: r.cf.name, Key: []byte(r.key)}
+		}
+		for _, rw := range o.rangeWrites {
+			if rw.cf == r.cf && rw.seq > r.seq && inRange([]byte(r.key), rw.start, rw.end) {
+				return &ConflictError{ColumnFamily: r.cf.name, Key: []byte(r.key)}
+			}
+		}
+	}
+
+	for _, rr := range ranges {
+		for k, seq := range o.writes {
+			if k.cf == rr.cf && seq > rr.seq && inRange([]byte(k.key), rr.start, rr.end) {
+				return &ConflictError{ColumnFamily: rr.cf.name, Key: []byte(k.key)}
+			}
+		}
+		for _, rw := range o.rangeWrites {
+			if rw.cf == rr.cf && rw.seq > rr.seq && rw.overlaps(rr.start, rr.end) {
+				return &ConflictError{ColumnFamily: rr.cf.name, Key: rw.start}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Txn это транзакция. Записи буферизуются до Commit и видны чтениям самой
+// транзакции, а Commit атомарно применяет их одним пакетом.
+//
+// Оптимистичная транзакция читает дерево на момент своего начала: записи,
+// сделанные после BeginTxn, ей не видны. Commit проверяет, что прочитанные
+// ключи и диапазоны не изменились с начала транзакции, иначе возвращает
+// ConflictError.
+//
+// Пессимистичная транзакция читает последние записи дерева и блокирует
+// записываемые ключи и ключи, прочитанные через GetForUpdate, до завершения
+// транзакции. Блокировки учитываются только
+// транзакциями: Put и Batch дерева их не проверяют.
+//
+// Транзакция не является goroutine-safe.
+type Txn struct {
+	db     *LSMTree
+	batch  *Batch
+	reads  []txnRead
+	ranges []txnRange
+	done   bool
+	// Номер последней записи дерева на момент начала оптимистичной транзакции.
+	start uint64
+
+	// Состояние пессимистичной транзакции.
+	pessimistic bool
+	id          uint64
+	lockTimeout time.Duration
+	locked      []txnKey
+	// Ошибка, из-за которой транзакция прервана.
+	err error
+}
+
+type TxnOption func(*Txn)
+
+// LockTimeout sets the time a pessimistic transaction waits for a key lock.
+func LockTimeout(timeout time.Duration) TxnOption {
+	return func(txn *Txn) {
+		txn.lockTimeout = timeout
+	}
+}
+
+// BeginTxn starts an optimistic transaction. The transaction reads the tree
+// as it was when the transaction started and must be finished with Commit or Discard.
+func (t *LSMTree) BeginTxn() *Txn {
+	t.lock.Lock()
+	t.oracle.txnID++
+	id := t.oracle.txnID
+	start := t.oracle.begin(id)
+	t.lock.Unlock()
+
+	return &Txn{db: t, batch: NewBatch(), id: id, start: start}
+}
+
+// BeginPessimisticTxn starts a pessimistic transaction. The transaction must be
+// finished with Commit or Discard. If transactions wait for the locks of each
+// other, the youngest one is aborted with ErrDeadlock.
+func (t *LSMTree) BeginPessimisticTxn(options ...TxnOption) *Txn {
+	t.lock.Lock()
+	t.oracle.txnID++
+	id := t.oracle.txnID
+	t.lock.Unlock()
+
+	txn := &Txn{
+		db:          t,
+		batch:       NewBatch(),
+		pessimistic: true,
+		id:          id,
+		lockTimeout: defaultLockTimeout,
+	}
+	for _, opt := range options {
+		opt(txn)
+	}
+	t.locks.register(id)
+
+	return txn
+}
+
+// Get returns the value of the key of the column family cf, nil means the default one.
+func (txn *Txn) Get(cf *ColumnFamily, key []byte) ([]byte, bool, error) {
+	if txn.done {
+		return nil, false, ErrTxnDone
+	}
+	cf = txn.columnFamily(cf)
+
+	value, ok, err := txn.get(cf, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return nil, false, ErrNotFound
+	}
+
+	return value, true, nil
+}
+
+// GetForUpdate returns the value of the key like Get. A pessimistic
+// transaction locks the key before reading it.
+func (txn *Txn) GetForUpdate(cf *ColumnFamily, key []byte) ([]byte, bool, error) {
+	if txn.done {
+		return nil, false, ErrTxnDone
+	}
+	if err := txn.lock(txn.columnFamily(cf), key); err != nil {
+		return nil, false, err
+	}
+
+	return txn.Get(cf, key)
+}
+
+func (txn *Txn) get(cf *ColumnFamily, key []byte) ([]byte, bool, error) {
+	if value, found := txn.lookup(cf, key); found {
+		return value, value != nil, nil
+	}
+	if txn.pessimistic {
+		return cf.value(context.Background(), key)
+	}
+
+	txn.reads = append(txn.reads, txnRead{
+		txnKey: txnKey{cf: cf, key: string(key)},
+		seq:    txn.start,
+	})
+
+	return cf.valueAt(context.Background(), key, txn.start)
+}
+
+// Put buffers the key for the column family cf, nil means the default one.
+func (txn *Txn) Put(cf *ColumnFamily, key, value []byte) error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	if err := checkKeyValue(key, value); err != nil {
+		return err
+	}
+	cf = txn.columnFamily(cf)
+	if err := txn.lock(cf, key); err != nil {
+		return err
+	}
+	txn.batch.add(cf, encoder.OpKindSet, key, value)
+
+	return nil
+}
+
+// Delete buffers deletion of the key from the column family cf, nil means the default one.
+func (txn *Txn) Delete(cf *ColumnFamily, key []byte) error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	if len(key) == 0 {
+		return ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	cf = txn.columnFamily(cf)
+	if err := txn.lock(cf, key); err != nil {
+		return err
+	}
+	txn.batch.add(cf, encoder.OpKindDelete, key, nil)
+
+	return nil
+}
+
+// NewIterator returns an iterator over the keys of the column family cf in [start, end),
+// including the keys written by the transaction. The range is checked for
+// conflicts on Commit of an optimistic transaction, a pessimistic one
+// does not lock it.
+func (txn *Txn) NewIterator(cf *ColumnFamily, start, end []byte) (*Iterator, error) {
+	if txn.done {
+		return nil, ErrTxnDone
+	}
+	cf = txn.columnFamily(cf)
+
+	if !txn.pessimistic {
+		txn.ranges = append(txn.ranges, txnRange{cf: cf, start: start, end: end, seq: txn.start})
+	}
+
+	return cf.newIterator(context.Background(), start, end, func() []sst.ElemSST {
+		var overlay []sst.ElemSST
+		var set = func(key, value []byte) {
+			e := sst.ElemSST{Key: key, Val: value}
+			i, found := slices.BinarySearchFunc(overlay, key, func(e sst.ElemSST, key []byte) int {
+				return bytes.Compare(e.Key, key)
+			})
+			if found {
+				overlay[i] = e
+			} else {
+				overlay = slices.Insert(overlay, i, e)
+			}
+		}
+
+		// ключи, измененные после начала транзакции, читаются со значениями
+		// на момент ее начала
+		if !txn.pessimistic {
+			for k := range txn.db.oracle.images {
+				if k.cf != cf || !inRange([]byte(k.key), start, end) {
+					continue
+				}
+				key := []byte(k.key)
+				value, ok := txn.db.oracle.image(cf, key, txn.start)
+				if !ok {
+					continue
+				}
+				if value == nil {
+					value = cf.db.encoder.Encode(encoder.OpKindDelete, nil)
+				}
+				set(key, value)
+			}
+		}
+
+		// записи транзакции новее записей дерева, последняя запись ключа
+		// заменяет предыдущие
+		for _, op := range txn.batch.ops {
+			if op.cf == cf && inRange(op.key, start, end) {
+				set(op.key, cf.db.encoder.Encode(op.kind, op.value))
+			}
+		}
+
+		return overlay
+	})
+}
+
+// Commit checks that no key read by the transaction was changed and
+// atomically writes the buffered changes.
+func (txn *Txn) Commit() error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	defer txn.Discard()
+
+	if txn.err != nil {
+		return txn.err
+	}
+
+	ops := txn.batch.ops
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := txn.db.throttle(context.Background(), ops); err != nil {
+		return err
+	}
+	if err := txn.db.prepare(context.Background(), ops); err != nil {
+		return err
+	}
+
+	txn.db.lock.Lock()
+	defer txn.db.lock.Unlock()
+
+	if !txn.pessimistic {
+		if err := txn.db.oracle.conflict(txn.reads, txn.ranges); err != nil {
+			return err
+		}
+	}
+
+	return txn.db.commit(context.Background(), ops)
+}
+
+// Discard drops the buffered changes. It is safe to call Discard after Commit.
+func (txn *Txn) Discard() {
+	if txn.done {
+		return
+	}
+	txn.done = true
+
+	t := txn.db
+	if txn.pessimistic {
+		t.locks.unlock(txn.id, txn.locked)
+		t.locks.unregister(txn.id)
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.oracle.end(txn.id)
+}
+
+// lookup ищет ключ среди записей транзакции. Для удаленного ключа
+// возвращается nil и true.
+func (txn *Txn) lookup(cf *ColumnFamily, key []byte) ([]byte, bool) {
+	for i := len(txn.batch.ops) - 1; i >= 0; i-- {
+		op := txn.batch.ops[i]
+		if op.cf != cf || !bytes.Equal(op.key, key) {
+			continue
+		}
+		if op.kind == encoder.OpKindSet {
+			return op.value, true
+		}
+
+		return nil, true
+	}
+
+	return nil, false
+}
+
+// lock блокирует ключ пессимистичной транзакции. Если транзакция
+// прервана из-за взаимоблокировки, все последующие операции и Commit
+// возвращают ErrDeadlock.
+func (txn *Txn) lock(cf *ColumnFamily, key []byte) error {
+	if !txn.pessimistic {
+		return nil
+	}
+	if txn.err != nil {
+		return txn.err
+	}
+
+	k := txnKey{cf: cf, key: string(key)}
+	if err := txn.db.locks.lock(txn.id, k, txn.lockTimeout); err != nil {
+		if err == ErrDeadlock {
+			txn.err = err
+		}
+		return err
+	}
+	txn.locked = append(txn.locked, k)
+
+	return nil
+}
+
+func (txn *Txn) columnFamily(cf *ColumnFamily) *ColumnFamily {
+	if cf == nil {
+		return txn.db.def
+	}
+
+	return cf
+}
@@ -0,0 +1,487 @@
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIterator(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if err := l.Put([]byte(k), []byte("disk-"+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.lock.Lock()
+	if err := l.flushMemTable(); err != nil {
+		t.Fatal(err)
+	}
+	l.lock.Unlock()
+
+	if err := l.Put([]byte("b"), []byte("mem-b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put([]byte("bb"), []byte("mem-bb")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Delete([]byte("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := l.NewIterator([]byte("b"), []byte("d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for it.HasNext() {
+		k, v, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(k)+"="+string(v))
+	}
+
+	want := []string{"b=mem-b", "bb=mem-bb"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v != %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v != %v", got, want)
+		}
+	}
+}
+
+// collect возвращает пары ключ=значение итератора.
+func collect(t *testing.T, it *Iterator) []string {
+	t.Helper()
+
+	var got []string
+	for it.HasNext() {
+		k, v, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(k)+"="+string(v))
+	}
+
+	return got
+}
+
+func TestIteratorVersions(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	var flush = func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if err := l.flushMemTable(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var do = func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// уровень 1: a..f=v1, c=v2
+	for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+		do(l.Put([]byte(k), []byte("v1")))
+	}
+	flush()
+	do(l.DeleteRange([]byte("b"), []byte("d")))
+	do(l.Put([]byte("c"), []byte("v2")))
+	flush()
+	do(l.def.compact(0))
+
+	// базовый уровень: надгробие [d, e) и b=v3 новее уровня 1
+	do(l.DeleteRange([]byte("d"), []byte("e")))
+	flush()
+	do(l.Put([]byte("b"), []byte("v3")))
+	flush()
+
+	// MemTable
+	do(l.DeleteRange([]byte("a"), []byte("b")))
+	do(l.Put([]byte("e"), []byte("v3")))
+	do(l.Delete([]byte("f")))
+
+	it, err := l.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := l.NewIterator([]byte("b"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"b=v3", "c=v2", "e=v3"}
+	if got := collect(t, it); !slices.Equal(got, want) {
+		t.Fatalf("got %v != %v", got, want)
+	}
+
+	// итератор видит дерево на момент создания
+	do(l.Put([]byte("a"), []byte("v4")))
+	do(l.Delete([]byte("c")))
+	flush()
+	do(l.def.compact(0))
+	if got := collect(t, before); !slices.Equal(got, want) {
+		t.Fatalf("got %v != %v after writes and compaction", got, want)
+	}
+
+	txn := l.BeginTxn()
+	defer txn.Discard()
+	do(txn.Put(nil, []byte("d"), []byte("txn")))
+	do(txn.Delete(nil, []byte("e")))
+	do(txn.Put(nil, []byte("f"), []byte("txn")))
+	do(txn.Delete(nil, []byte("f")))
+	tit, err := txn.NewIterator(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"a=v4", "b=v3", "d=txn"}
+	if got := collect(t, tit); !slices.Equal(got, want) {
+		t.Fatalf("txn got %v != %v", got, want)
+	}
+}
+
+func TestIteratorClose(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	for i := 0; i < 3; i++ {
+		if err := l.Put([]byte{'a' + byte(i)}, []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		l.lock.Lock()
+		err := l.flushMemTable()
+		l.lock.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it, err := l.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(it.readers) != 3 {
+		t.Fatalf("iterator opened %d tables, want 3", len(it.readers))
+	}
+	if _, _, err := it.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// исчерпанный итератор закрывает таблицы сам
+	it, err = l.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, it); len(got) != 3 || it.readers != nil {
+		t.Fatalf("got %v, %d tables left open", got, len(it.readers))
+	}
+}
+
+func TestTxnCommit(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	if err := l.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := l.BeginTxn()
+	if v, _, err := txn.Get(nil, []byte("a")); err != nil || !bytes.Equal(v, []byte("1")) {
+		t.Fatalf("a = %s: %v", v, err)
+	}
+	if err := txn.Put(nil, []byte("a"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put(nil, []byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete(nil, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	// read-your-own-writes
+	if v, _, _ := txn.Get(nil, []byte("a")); !bytes.Equal(v, []byte("2")) {
+		t.Fatalf("txn a = %s", v)
+	}
+	if _, ok, _ := txn.Get(nil, []byte("b")); ok {
+		t.Fatal("txn b found!")
+	}
+	if v, _, _ := l.Get([]byte("a")); !bytes.Equal(v, []byte("1")) {
+		t.Fatalf("a = %s before commit", v)
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ := l.Get([]byte("a")); !bytes.Equal(v, []byte("2")) {
+		t.Fatalf("a = %s after commit", v)
+	}
+	if err := txn.Commit(); err != ErrTxnDone {
+		t.Fatalf("err %v != %v", err, ErrTxnDone)
+	}
+}
+
+func TestTxnConflict(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	if err := l.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn1, txn2 := l.BeginTxn(), l.BeginTxn()
+	for _, txn := range []*Txn{txn1, txn2} {
+		v, _, err := txn.Get(nil, []byte("a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := txn.Put(nil, []byte("a"), append(v, '+')); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := txn1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = txn2.Commit()
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("err %v is not a conflict", err)
+	}
+	if !bytes.Equal(conflict.Key, []byte("a")) {
+		t.Fatalf("conflict key %s != a", conflict.Key)
+	}
+	if v, _, _ := l.Get([]byte("a")); !bytes.Equal(v, []byte("1+")) {
+		t.Fatalf("a = %s", v)
+	}
+
+	// a write into the iterated range conflicts as well
+	txn := l.BeginTxn()
+	it, err := txn.NewIterator(nil, []byte("a"), []byte("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.HasNext() {
+		if _, _, err := it.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := txn.Put(nil, []byte("sum"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put([]byte("b"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("err %v != %v", err, ErrConflict)
+	}
+}
+
+func TestTxnSnapshot(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if err := l.Put([]byte(k), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	txn := l.BeginTxn()
+	defer txn.Discard()
+
+	if err := l.Put([]byte("a"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Delete([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.DeleteRange([]byte("c"), []byte("e")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put([]byte("e"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	l.lock.Lock()
+	if err := l.flushMemTable(); err != nil {
+		t.Fatal(err)
+	}
+	l.lock.Unlock()
+
+	// the transaction reads the tree as it was when it started
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if v, _, err := txn.Get(nil, []byte(k)); err != nil || !bytes.Equal(v, []byte("1")) {
+			t.Fatalf("%s = %s: %v", k, v, err)
+		}
+	}
+	if _, _, err := txn.Get(nil, []byte("e")); err != ErrNotFound {
+		t.Fatalf("err %v != %v", err, ErrNotFound)
+	}
+	it, err := txn.NewIterator(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a=1", "b=1", "c=1", "d=1"}
+	if got := collect(t, it); !slices.Equal(got, want) {
+		t.Fatalf("got %v != %v", got, want)
+	}
+
+	// the history is kept only for the oldest transaction
+	latest := l.BeginTxn()
+	defer latest.Discard()
+	it, err = latest.NewIterator(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"a=2", "e=2"}
+	if got := collect(t, it); !slices.Equal(got, want) {
+		t.Fatalf("got %v != %v", got, want)
+	}
+
+	txn.Discard()
+	l.lock.RLock()
+	writes, images := len(l.oracle.writes)+len(l.oracle.rangeWrites), len(l.oracle.images)
+	l.lock.RUnlock()
+	if writes != 0 || images != 0 {
+		t.Fatalf("%d writes and %d images are kept", writes, images)
+	}
+
+	if err := l.Put([]byte("a"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, err := latest.Get(nil, []byte("a")); err != nil || !bytes.Equal(v, []byte("2")) {
+		t.Fatalf("a = %s: %v", v, err)
+	}
+}
+
+func TestPessimisticTxn(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	if err := l.Put([]byte("counter"), []byte{0}); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 8
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			txn := l.BeginPessimisticTxn()
+			defer txn.Discard()
+
+			v, _, err := txn.GetForUpdate(nil, []byte("counter"))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := txn.Put(nil, []byte("counter"), []byte{v[0] + 1}); err != nil {
+				errs <- err
+				return
+			}
+			errs <- txn.Commit()
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if v, _, _ := l.Get([]byte("counter")); !bytes.Equal(v, []byte{workers}) {
+		t.Fatalf("counter %v != %d", v, workers)
+	}
+
+	holder := l.BeginPessimisticTxn()
+	defer holder.Discard()
+	if _, _, err := holder.GetForUpdate(nil, []byte("counter")); err != nil {
+		t.Fatal(err)
+	}
+
+	waiter := l.BeginPessimisticTxn(LockTimeout(10 * time.Millisecond))
+	defer waiter.Discard()
+	if err := waiter.Put(nil, []byte("counter"), []byte{0}); err != ErrLockTimeout {
+		t.Fatalf("err %v != %v", err, ErrLockTimeout)
+	}
+}
+
+func TestPessimisticTxnDeadlock(t *testing.T) {
+	l, err := Open(t.TempDir(), MemTableThreshold(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	older, younger := l.BeginPessimisticTxn(LockTimeout(time.Minute)), l.BeginPessimisticTxn(LockTimeout(time.Minute))
+	if err := older.Put(nil, []byte("a"), []byte("older")); err != nil {
+		t.Fatal(err)
+	}
+	if err := younger.Put(nil, []byte("b"), []byte("younger")); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		// waits for younger
+		done <- older.Put(nil, []byte("b"), []byte("older"))
+	}()
+
+	// the younger transaction is aborted either here or while waiting
+	for !l.locks.waiting(older.id) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := younger.Put(nil, []byte("a"), []byte("younger")); err != ErrDeadlock {
+		t.Fatalf("err %v != %v", err, ErrDeadlock)
+	}
+	if err := younger.Commit(); err != ErrDeadlock {
+		t.Fatalf("commit err %v != %v", err, ErrDeadlock)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := older.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ := l.Get([]byte("b")); !bytes.Equal(v, []byte("older")) {
+		t.Fatalf("b = %s", v)
+	}
+}
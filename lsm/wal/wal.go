@@ -8,6 +8,7 @@ import (
 	"path"
 	"sync"
 
+	"github.com/wubba-com/lsm-distributed/lsm/encoder"
 	"github.com/wubba-com/lsm-distributed/lsm/memtable"
 	"github.com/wubba-com/lsm-distributed/lsm/sst"
 )
@@ -26,6 +27,9 @@ type WAL struct {
 	fsync  bool
 	seqNum uint64
 	root   string
+	// WAL открыт только для чтения, и другой экземпляр может
+	// дописывать его во время чтения.
+	readOnly bool
 }
 
 type Option func(*WAL)
@@ -72,6 +76,50 @@ func NewWAL(dir string, options ...Option) (*WAL, error) {
 	return w, nil
 }
 
+// OpenReadOnly opens the WAL of the directory for reading. Unlike NewWAL it
+// does not create the files, and the WAL can not be appended or cleared.
+func OpenReadOnly(dir string) (*WAL, error) {
+	walpath := path.Join(dir, walDir)
+
+	fIdx, err := os.Open(path.Join(walpath, indexNamePath))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path.Join(walpath, walFileName))
+	if err != nil {
+		fIdx.Close()
+		return nil, err
+	}
+
+	w := &WAL{
+		f:        f,
+		fIdx:     fIdx,
+		root:     walpath,
+		readOnly: true,
+	}
+	if w.seqNum, err = readSeqNum(w.fIdx); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// ReadSequence reads the sequence number from the index file,
+// which may be changed by another instance writing to the WAL.
+func (w *WAL) ReadSequence() (uint64, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	seq, err := readSeqNum(w.fIdx)
+	if err != nil {
+		return 0, err
+	}
+	w.seqNum = seq
+
+	return seq, nil
+}
+
 func (w *WAL) Path() string {
 	return path.Join(w.root, walFileName)
 }
@@ -123,14 +171,43 @@ func (w *WAL) UpSequence() error {
 	return nil
 }
 
+// NextSequence returns the current sequence number and moves the sequence forward.
+func (w *WAL) NextSequence() (uint64, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	seqNum := w.seqNum
+	if err := w.UpSequence(); err != nil {
+		return 0, err
+	}
+
+	return seqNum, nil
+}
+
 func (w *WAL) SetSequence(n uint64) {
 	w.seqNum = n
 }
 
 func (w *WAL) Sequence() uint64 {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
 	return w.seqNum
 }
 
+// WriteSequence sets the sequence number and writes it to the index file.
+func (w *WAL) WriteSequence(n uint64) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.seqNum = n
+	if _, err := writeSeqNum(w.seqNum, w.fIdx); err != nil {
+		return err
+	}
+
+	return w.fIdx.Sync()
+}
+
 func writeSeqNum(seq uint64, fidx io.WriterAt) (int, error) {
 	var encoded [8]byte
 	binary.LittleEndian.PutUint64(encoded[:], seq)
@@ -176,21 +253,72 @@ func (w *WAL) Append(key []byte, value []byte) error {
 
 // loadMemTable loads MemTable from the WAL file.
 func (w *WAL) LoadMem() (*memtable.Memtable, error) {
-	// for safety, since the file is open in read-write mode
-	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("failed to seek to the beginning: %w", err)
+	memTable := memtable.NewMem()
+	decoder := encoder.NewDecoder()
+	err := w.Replay(func(key, value []byte) error {
+		if decoder.Decode(value).IsRangeTombstone() {
+			memTable.DeleteRange(key, value[1:])
+			return nil
+		}
+
+		memTable.Put(key, value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	memTable := memtable.NewMem()
+	return memTable, nil
+}
+
+// Replay calls fn for each entry of the WAL file in the order they were appended.
+//
+// A WAL opened with OpenReadOnly may be appended by the primary instance
+// while it is replayed, so a record cut off by the end of the file is
+// the end of the log rather than corruption; a malformed record in the
+// middle of the file is still corruption.
+func (w *WAL) Replay(fn func(key, value []byte) error) error {
+	stat, err := w.f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat the file: %w", err)
+	}
+	// записи, дописанные во время чтения, не читаются
+	size := stat.Size()
+	r := io.NewSectionReader(w.f, 0, size)
+
+	var pos int64
 	for {
-		key, value, err := sst.Decode(w.f)
+		key, value, err := sst.Decode(r)
 		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("failed to read: %w", err)
+			if w.readOnly && partial(w.f, pos, size) {
+				return nil
+			}
+			return fmt.Errorf("failed to read: %w", sst.Corrupted(w.f.Name(), pos, err))
 		}
 		if err == io.EOF {
-			return memTable, nil
+			return nil
 		}
 
-		memTable.Put(key, value)
+		// ошибки разбора записи указывают смещение внутри нее
+		if err := fn(key, value); err != nil {
+			return sst.Corrupted(w.f.Name(), pos, err)
+		}
+		pos += int64(16 + len(key) + len(value))
+	}
+}
+
+// partial сообщает, что запись на позиции pos обрезана концом файла
+// размера size, т.е. дописывается.
+func partial(f io.ReaderAt, pos, size int64) bool {
+	var prefix [8]byte
+	if size-pos < int64(len(prefix)) {
+		return true
 	}
+	if _, err := f.ReadAt(prefix[:], pos); err != nil {
+		return false
+	}
+	// длина записи кодируется как в sst.Encode
+	total := binary.BigEndian.Uint64(prefix[:])
+
+	return total > uint64(size-pos-int64(len(prefix)))
 }
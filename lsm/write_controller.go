@@ -0,0 +1,274 @@
+package lsm
+
+import (
+	"context"
+	"time"
+
+	"github.com/wubba-com/lsm-distributed/lsm/sst"
+)
+
+// Default delay of a write when the writes are slowed down.
+const defaultSlowdownDelay = time.Millisecond
+
+// WriteState is the state of the write controller of a column family.
+type WriteState int
+
+const (
+	// WriteNormal means writes are not limited.
+	WriteNormal WriteState = iota
+	// WriteDelayed means each write is delayed by SlowdownDelay.
+	WriteDelayed
+	// WriteStopped means writes wait until flushes and compactions catch up.
+	WriteStopped
+)
+
+func (s WriteState) String() string {
+	switch s {
+	case WriteNormal:
+		return "normal"
+	case WriteDelayed:
+		return "delayed"
+	case WriteStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// StallSettings задает пороги, после которых записи в column family
+// замедляются или останавливаются. Нулевой порог не ограничивает записи.
+type StallSettings struct {
+	// Записи задерживаются, если на уровне 0 не меньше L0SlowdownFiles файлов,
+	// и останавливаются, если не меньше L0StopFiles.
+	L0SlowdownFiles int
+	L0StopFiles     int
+
+	// Записи задерживаются, если оценка объема данных, ожидающих уплотнения,
+	// не меньше PendingCompactionSlowdownBytes, и останавливаются,
+	// если не меньше PendingCompactionStopBytes.
+	PendingCompactionSlowdownBytes uint64
+	PendingCompactionStopBytes     uint64
+
+	// Задержка каждой записи в состоянии WriteDelayed.
+	SlowdownDelay time.Duration
+}
+
+// WriteStallInfo describes the change of the write state of a column family.
+type WriteStallInfo struct {
+	ColumnFamily string
+	Prev, Cur    WriteState
+}
+
+// WriteStall устанавливает пороги замедления записей для column family по умолчанию.
+func WriteStall(settings StallSettings) func(*LSMTree) {
+	return func(t *LSMTree) {
+		if settings.SlowdownDelay == 0 {
+			settings.SlowdownDelay = defaultSlowdownDelay
+		}
+		t.def.config.Stall = settings
+	}
+}
+
+// OnWriteStall устанавливает функцию, которая вызывается при изменении
+// состояния записи любой column family.
+func OnWriteStall(fn func(WriteStallInfo)) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.onWriteStall = fn
+	}
+}
+
+// pendingCompactionBytes оценивает объем данных, ожидающих уплотнения:
+// размер всех уровней, число файлов которых превышает порог слияния.
+// Функция ожидает, что она будет выполняться под блокировкой дерева.
+func (cf *ColumnFamily) pendingCompactionBytes() uint64 {
+	num := cf.config.Merge.NumberOfSstFiles
+	if num <= 0 {
+		num = 1
+	}
+
+	var pending uint64
+	for lvl, level := range cf.levels {
+		if lvl > 0 && lvl == cf.config.Merge.MaxLevels {
+			break
+		}
+		if len(level.Files) <= num*(lvl+1) {
+			continue
+		}
+		for _, file := range level.Files {
+			pending += uint64(file.Size)
+		}
+	}
+
+	return pending
+}
+
+// stallsWrites сообщает, замедляет или останавливает записи уровень 0
+// из files файлов. Такой уровень уплотняется, даже если порог слияния
+// NumberOfSstFiles выше порогов замедления или слияние отключено,
+// иначе записи остановятся навсегда.
+func (cf *ColumnFamily) stallsWrites(files int) bool {
+	s := cf.config.Stall
+	return s.L0SlowdownFiles > 0 && files >= s.L0SlowdownFiles ||
+		s.L0StopFiles > 0 && files >= s.L0StopFiles
+}
+
+// columnFamilyStats вычисляет состояние записи column family.
+// Функция ожидает, что она будет выполняться под блокировкой дерева.
+func (cf *ColumnFamily) columnFamilyStats() ColumnFamilyStats {
+	stats := ColumnFamilyStats{
+		L0Files:                len(cf.levels[sst.BaseLevel].Files),
+		PendingCompactionBytes: cf.pendingCompactionBytes(),
+	}
+
+	s := cf.config.Stall
+	switch {
+	case s.L0StopFiles > 0 && stats.L0Files >= s.L0StopFiles,
+		s.PendingCompactionStopBytes > 0 && stats.PendingCompactionBytes >= s.PendingCompactionStopBytes:
+		stats.WriteState = WriteStopped
+	case s.L0SlowdownFiles > 0 && stats.L0Files >= s.L0SlowdownFiles,
+		s.PendingCompactionSlowdownBytes > 0 && stats.PendingCompactionBytes >= s.PendingCompactionSlowdownBytes:
+		stats.WriteState = WriteDelayed
+	}
+
+	return stats
+}
+
+// updateWriteState вычисляет состояние записи column family и сообщает
+// о его изменении через OnWriteStall.
+func (cf *ColumnFamily) updateWriteState() WriteState {
+	t := cf.db
+
+	t.lock.RLock()
+	cur := cf.columnFamilyStats().WriteState
+	t.lock.RUnlock()
+
+	t.stallMu.Lock()
+	prev := cf.writeState
+	cf.writeState = cur
+	t.stallMu.Unlock()
+
+	if prev != cur && t.onWriteStall != nil {
+		t.onWriteStall(WriteStallInfo{ColumnFamily: cf.name, Prev: prev, Cur: cur})
+	}
+	// без уплотнения, например, если Merge.Interval не задан,
+	// остановленные записи ждали бы вечно
+	if cur != WriteNormal {
+		cf.requestCompaction()
+	}
+
+	return cur
+}
+
+// throttle задерживает запись в column families операций, пока их
+// состояние записи не позволит ее. В состоянии WriteDelayed запись
+// задерживается один раз, в состоянии WriteStopped ждет, пока сброс
+// MemTable или уплотнение не изменит состояние.
+func (t *LSMTree) throttle(ctx context.Context, ops []batchOp) error {
+	var (
+		start   time.Time
+		delayed bool
+		stopped bool
+	)
+	defer func() {
+		if !delayed && !stopped {
+			return
+		}
+
+		t.stallMu.Lock()
+		defer t.stallMu.Unlock()
+		if delayed {
+			t.stats.DelayedWrites++
+		}
+		if stopped {
+			t.stats.StoppedWrites++
+		}
+		t.stats.StallDuration += time.Since(start)
+	}()
+
+	for {
+		// канал берется до проверки, чтобы не пропустить уведомление
+		changed := t.writeStateChanged()
+
+		var (
+			state WriteState
+			delay time.Duration
+		)
+		for _, op := range ops {
+			cf := op.cf
+			if cf == nil {
+				cf = t.def
+			}
+			if s := cf.updateWriteState(); s > state {
+				state = s
+			}
+			if cf.config.Stall.SlowdownDelay > delay {
+				delay = cf.config.Stall.SlowdownDelay
+			}
+		}
+
+		if state != WriteNormal && start.IsZero() {
+			start = time.Now()
+		}
+
+		var timeout <-chan time.Time
+		switch state {
+		case WriteNormal:
+			return nil
+		case WriteDelayed:
+			if delayed {
+				return nil
+			}
+			delayed = true
+			timeout = time.After(delay)
+		case WriteStopped:
+			stopped = true
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.ctx.Done():
+			return ErrClosed
+		}
+	}
+}
+
+// writeStateChanged возвращает канал, который закрывается после
+// следующего сброса MemTable или уплотнения.
+func (t *LSMTree) writeStateChanged() <-chan struct{} {
+	t.stallMu.Lock()
+	defer t.stallMu.Unlock()
+
+	return t.stallCh
+}
+
+// notifyWriters пересчитывает состояние записи column families
+// и будит записи, ожидающие сброса MemTable или уплотнения.
+func (t *LSMTree) notifyWriters() {
+	t.lock.RLock()
+	cfs := make([]*ColumnFamily, 0, len(t.cfs))
+	for _, cf := range t.cfs {
+		cfs = append(cfs, cf)
+	}
+	t.lock.RUnlock()
+
+	for _, cf := range cfs {
+		cf.updateWriteState()
+	}
+
+	t.wakeWriters()
+}
+
+// wakeWriters будит записи, ожидающие изменения состояния; они сами
+// пересчитывают его. В отличие от notifyWriters не берет блокировку
+// дерева, поэтому вызывается после сброса MemTable из commit,
+// который ее удерживает.
+func (t *LSMTree) wakeWriters() {
+	t.stallMu.Lock()
+	defer t.stallMu.Unlock()
+
+	close(t.stallCh)
+	t.stallCh = make(chan struct{})
+}
@@ -0,0 +1,160 @@
+package lsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteStall(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []WriteStallInfo
+	)
+	l, err := Open(t.TempDir(),
+		MemTableThreshold(1<<20),
+		WriteStall(StallSettings{L0SlowdownFiles: 2, L0StopFiles: 3, SlowdownDelay: 10 * time.Millisecond}),
+		OnWriteStall(func(info WriteStallInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, info)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	l.SetMergeSettings(MergeSettings{MaxLevels: 3})
+
+	// уплотнение, запрошенное контроллером записи, ждет, пока
+	// состояния записи не будут проверены
+	l.def.compactMu.Lock()
+	var put = func(key string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		return l.PutContext(ctx, []byte(key), []byte(key))
+	}
+	var flush = func(key string) {
+		if err := put(key); err != nil {
+			t.Fatal(err)
+		}
+		l.lock.Lock()
+		err := l.flushMemTable()
+		l.lock.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.notifyWriters()
+	}
+
+	flush("a")
+	if s := l.Stats(); s.WriteState != WriteNormal || s.ColumnFamilies[DefaultColumnFamily].L0Files != 1 {
+		t.Fatalf("stats after 1 flush: %+v", s)
+	}
+
+	flush("b")
+	if s := l.Stats(); s.WriteState != WriteDelayed {
+		t.Fatalf("state %s != %s", s.WriteState, WriteDelayed)
+	}
+	start := time.Now()
+	if err := put("c"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Fatalf("delayed write took %s", d)
+	}
+	if s := l.Stats(); s.DelayedWrites != 1 {
+		t.Fatalf("delayed writes %d != 1", s.DelayedWrites)
+	}
+
+	l.lock.Lock()
+	err = l.flushMemTable()
+	l.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.notifyWriters()
+	if s := l.def.Stats(); s.WriteState != WriteStopped || s.L0Files != 3 {
+		t.Fatalf("stats after 3 flushes: %+v", s)
+	}
+
+	if err := put("d"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("stopped write err %v != %v", err, context.DeadlineExceeded)
+	}
+	if s := l.Stats(); s.StoppedWrites != 1 || s.StallDuration == 0 {
+		t.Fatalf("stats of stopped write: %+v", s)
+	}
+
+	// уплотнение, запрошенное остановленной записью, освобождает ее
+	done := make(chan error)
+	go func() {
+		done <- l.Put([]byte("e"), []byte("e"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	l.def.compactMu.Unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write is not resumed after compaction")
+	}
+	if s := l.Stats(); s.WriteState != WriteNormal {
+		t.Fatalf("state after compaction %s != %s", s.WriteState, WriteNormal)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []WriteStallInfo{
+		{DefaultColumnFamily, WriteNormal, WriteDelayed},
+		{DefaultColumnFamily, WriteDelayed, WriteStopped},
+		{DefaultColumnFamily, WriteStopped, WriteNormal},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events %v != %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("event %d: %v != %v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestWriteStallTriggersCompaction(t *testing.T) {
+	// слияние по интервалу не запущено, а порог слияния выше порога
+	// остановки записей: уровень 0 уплотняется только по запросу
+	// контроллера записи
+	l, err := Open(t.TempDir(), MemTableThreshold(64), WriteStall(StallSettings{L0StopFiles: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	l.SetMergeSettings(MergeSettings{NumberOfSstFiles: 4, MaxLevels: 3})
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := l.PutContext(ctx, []byte(fmt.Sprintf("key-%03d", i)), []byte("value"))
+		cancel()
+		if err != nil {
+			t.Fatalf("put %d: %v, stats %+v", i, err, l.Stats())
+		}
+	}
+
+	s := l.Stats()
+	if s.StoppedWrites == 0 {
+		t.Fatalf("writes were never stopped: %+v", s)
+	}
+	if s.ColumnFamilies[DefaultColumnFamily].L0Files > 2 {
+		t.Fatalf("level 0 is not compacted: %+v", s)
+	}
+	for i := 0; i < n; i++ {
+		if _, _, err := l.Get([]byte(fmt.Sprintf("key-%03d", i))); err != nil {
+			t.Fatalf("key-%03d: %v", i, err)
+		}
+	}
+}